@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"slices"
 
 	"github.com/codecrafters-io/redis-starter-go/app/radisa"
 )
@@ -16,13 +17,108 @@ func main() {
 	port := flag.Int("port", 6379, "Port to run the server on")
 	replicaof := flag.String("replicaof", "", "Start redis as replica of master")
 
+	config := radisa.DefaultConfig()
+	save := flag.String("save", radisa.FormatSavePoints(config.SavePoints), "Snapshot save points as \"<seconds> <changes> ...\", empty to disable")
+
+	rdbCorruptPolicy := flag.String("rdb-corrupt-policy", config.RDBCorruptPolicy, "On a corrupt RDB file: refuse to start, or load-valid keys read before the damage")
+	rdbCompression := flag.Bool("rdbcompression", config.RDBCompression, "LZF-compress long strings in RDB snapshots")
+	appendOnly := flag.Bool("appendonly", config.AppendOnly, "Log every write to an append only file")
+	appendFilename := flag.String("appendfilename", config.AppendFilename, "Base name of the append only files")
+	appendDirName := flag.String("appenddirname", config.AppendDirName, "Directory under -dir holding the append only files")
+	appendFsync := flag.String("appendfsync", config.AppendFsync, "When to fsync the append only file: always, everysec or no")
+	aofLoadTruncated := flag.Bool("aof-load-truncated", config.AOFLoadTruncated, "Load an append only file whose last command was cut short")
+	aofUseRDBPreamble := flag.Bool("aof-use-rdb-preamble", config.AOFUseRDBPreamble, "Write the base of a rewritten append only file as an RDB")
+	autoAOFRewritePercentage := flag.Int("auto-aof-rewrite-percentage", config.AutoAOFRewritePercentage, "Rewrite the append only file once it grew by this percentage, 0 to disable")
+	autoAOFRewriteMinSize := flag.Int64("auto-aof-rewrite-min-size", config.AutoAOFRewriteMinSize, "Smallest append only file size in bytes that is rewritten automatically")
+	replBacklogSize := flag.Int("repl-backlog-size", config.ReplBacklogSize, "Bytes of the replication stream kept for partial resyncs")
+	replicaReadOnly := flag.Bool("replica-read-only", config.ReplicaReadOnly, "Refuse writes on a replica from clients other than its master")
+	replicaServeStaleData := flag.Bool("replica-serve-stale-data", config.ReplicaServeStaleData, "Keep serving reads on a replica while its master link is down")
+	replDisklessSync := flag.Bool("repl-diskless-sync", config.ReplDisklessSync, "Stream snapshots to replicas without encoding them whole first")
+	replDisklessSyncDelay := flag.Int("repl-diskless-sync-delay", config.ReplDisklessSyncDelay, "Seconds a diskless transfer waits for more replicas to share it")
+	replDisklessLoad := flag.String("repl-diskless-load", config.ReplDisklessLoad, "Parse the master's snapshot from the socket: disabled, on-empty-db or swapdb")
+	notifyKeyspaceEvents := flag.String("notify-keyspace-events", radisa.FormatKeyspaceEvents(config.NotifyKeyspaceEvents), "Keyspace event classes to publish, e.g. \"KEA\", empty to disable")
+	trackingTableMaxKeys := flag.Int("tracking-table-max-keys", config.TrackingTableMaxKeys, "Keys client side caching remembers readers for, 0 for no limit")
+	requirePass := flag.String("requirepass", config.RequirePass, "Password clients have to AUTH with, empty for none")
+	masterAuth := flag.String("masterauth", config.MasterAuth, "Password a replica authenticates to its master with")
+	aclFile := flag.String("aclfile", config.ACLFile, "File ACL SAVE and ACL LOAD keep the users in, loaded at startup")
+	aclLogMaxLen := flag.Int("acllog-max-len", config.ACLLogMaxLen, "Entries ACL LOG keeps")
+
 	flag.Parse()
 
+	if *rdbCorruptPolicy != radisa.RDBLoadRefuse && *rdbCorruptPolicy != radisa.RDBLoadValid {
+		fmt.Printf("Invalid -rdb-corrupt-policy %q: expected %s or %s\n", *rdbCorruptPolicy, radisa.RDBLoadRefuse, radisa.RDBLoadValid)
+		os.Exit(1)
+	}
+	config.RDBCorruptPolicy = *rdbCorruptPolicy
+	config.RDBCompression = *rdbCompression
+
+	if *appendFsync != radisa.FsyncAlways && *appendFsync != radisa.FsyncEverySec && *appendFsync != radisa.FsyncNo {
+		fmt.Printf("Invalid -appendfsync %q: expected always, everysec or no\n", *appendFsync)
+		os.Exit(1)
+	}
+	config.AppendOnly = *appendOnly
+	config.AppendFilename = *appendFilename
+	config.AppendDirName = *appendDirName
+	config.AppendFsync = *appendFsync
+	config.AOFLoadTruncated = *aofLoadTruncated
+	config.AOFUseRDBPreamble = *aofUseRDBPreamble
+	config.AutoAOFRewritePercentage = *autoAOFRewritePercentage
+	config.AutoAOFRewriteMinSize = *autoAOFRewriteMinSize
+
+	if *replBacklogSize < 1 {
+		fmt.Printf("Invalid -repl-backlog-size %d: must be positive\n", *replBacklogSize)
+		os.Exit(1)
+	}
+	config.ReplBacklogSize = *replBacklogSize
+	config.ReplicaReadOnly = *replicaReadOnly
+	config.ReplicaServeStaleData = *replicaServeStaleData
+
+	if *replDisklessSyncDelay < 0 {
+		fmt.Printf("Invalid -repl-diskless-sync-delay %d: must not be negative\n", *replDisklessSyncDelay)
+		os.Exit(1)
+	}
+	if !slices.Contains(radisa.ReplDisklessLoadModes, *replDisklessLoad) {
+		fmt.Printf("Invalid -repl-diskless-load %q: expected disabled, on-empty-db or swapdb\n", *replDisklessLoad)
+		os.Exit(1)
+	}
+	config.ReplDisklessSync = *replDisklessSync
+	config.ReplDisklessSyncDelay = *replDisklessSyncDelay
+	config.ReplDisklessLoad = *replDisklessLoad
+
+	notifyFlags, err := radisa.ParseKeyspaceEvents(*notifyKeyspaceEvents)
+	if err != nil {
+		fmt.Printf("Invalid -notify-keyspace-events: %v\n", err)
+		os.Exit(1)
+	}
+	config.NotifyKeyspaceEvents = notifyFlags
+
+	if *trackingTableMaxKeys < 0 {
+		fmt.Printf("Invalid -tracking-table-max-keys %d: must not be negative\n", *trackingTableMaxKeys)
+		os.Exit(1)
+	}
+	config.TrackingTableMaxKeys = *trackingTableMaxKeys
+	config.RequirePass = *requirePass
+	config.MasterAuth = *masterAuth
+	config.ACLFile = *aclFile
+
+	if *aclLogMaxLen < 0 {
+		fmt.Printf("Invalid -acllog-max-len %d: must not be negative\n", *aclLogMaxLen)
+		os.Exit(1)
+	}
+	config.ACLLogMaxLen = *aclLogMaxLen
+
+	savePoints, err := radisa.ParseSavePoints(*save)
+	if err != nil {
+		fmt.Printf("Invalid -save: %v\n", err)
+		os.Exit(1)
+	}
+	config.SavePoints = savePoints
+
 	var server *radisa.Radisa
 	if *replicaof != "" {
-		server = radisa.NewReplica(*dir, *dbfilename, *port, *replicaof)
+		server = radisa.NewReplica(*dir, *dbfilename, *port, *replicaof, config)
 	} else {
-		server = radisa.NewRadisa(*dir, *dbfilename, *port)
+		server = radisa.NewRadisa(*dir, *dbfilename, *port, config)
 	}
 
 	if err := server.Start(); err != nil {
@@ -0,0 +1,660 @@
+package radisa
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// aclCategories are the command categories rules can name with @, in the
+// order ACL CAT lists them. Some have no commands here yet, but rules that
+// mention them still parse like they do in redis.
+var aclCategories = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string",
+	"bitmap", "hyperloglog", "geo", "stream", "pubsub", "admin", "fast", "slow",
+	"blocking", "dangerous", "connection", "transaction", "scripting",
+}
+
+// Key permissions, which of read and write access a key pattern grants.
+const (
+	keyRead = 1 << iota
+	keyWrite
+)
+
+// commandChannels returns the channels cmd publishes or subscribes to, and
+// whether they are patterns.
+func commandChannels(cmd *Command) ([]string, bool) {
+	switch cmd.Name {
+	case "PUBLISH", "SPUBLISH":
+		return cmd.Args[:min(1, len(cmd.Args))], false
+	case "SUBSCRIBE", "SSUBSCRIBE":
+		return cmd.Args, false
+	case "PSUBSCRIBE":
+		return cmd.Args, true
+	}
+	return nil, false
+}
+
+// aclUser is an ACL user: whether it can log in, with which passwords, and
+// what it may run and touch once it did.
+type aclUser struct {
+	name    string
+	enabled bool
+	nopass  bool
+	// passwords are SHA-256 hashes in lowercase hex
+	passwords []string
+	// allowed is keyed by command name, or name|sub for subcommands
+	allowed map[string]bool
+	// commandRules are the command rules applied since the last +@all or
+	// -@all, which describe allowed
+	commandRules []string
+	keys         []keyPattern
+	channels     []string
+}
+
+// keyPattern is a glob keys may match and the access it grants.
+type keyPattern struct {
+	pattern string
+	flags   int
+}
+
+func (k keyPattern) String() string {
+	switch k.flags {
+	case keyRead:
+		return "%R~" + k.pattern
+	case keyWrite:
+		return "%W~" + k.pattern
+	}
+	return "~" + k.pattern
+}
+
+// newACLUser returns a user that is off and may do nothing, what ACL
+// SETUSER starts from.
+func newACLUser(name string) *aclUser {
+	return &aclUser{name: name, allowed: make(map[string]bool), commandRules: []string{"-@all"}}
+}
+
+// newDefaultUser returns the user connections start as: everything is
+// allowed, with requirepass as its password if there is one.
+func newDefaultUser(requirepass string) *aclUser {
+	u := newACLUser("default")
+	rules := []string{"on", "~*", "&*", "+@all", "nopass"}
+	if requirepass != "" {
+		rules[4] = ">" + requirepass
+	}
+	for _, rule := range rules {
+		u.applyRule(rule)
+	}
+	return u
+}
+
+// clone returns a copy that rules can be applied to without touching u.
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.allowed = maps.Clone(u.allowed)
+	c.commandRules = slices.Clone(u.commandRules)
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+	return &c
+}
+
+var errNoSuchPassword = errors.New("The password you are trying to remove from the user does not exist")
+
+// applyRule applies one ACL SETUSER rule to u.
+func (u *aclUser) applyRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+	case "allkeys":
+		u.keys = []keyPattern{{"*", keyRead | keyWrite}}
+	case "resetkeys":
+		u.keys = nil
+	case "allchannels":
+		u.channels = []string{"*"}
+	case "resetchannels":
+		u.channels = nil
+	case "allcommands":
+		return u.applyRule("+@all")
+	case "nocommands":
+		return u.applyRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			u.applyRule(r)
+		}
+	default:
+		if rule == "" {
+			return errors.New("Syntax error")
+		}
+		switch rule[0] {
+		case '>', '#':
+			hash := rule[1:]
+			if rule[0] == '>' {
+				hash = hashPassword(rule[1:])
+			} else if !isPasswordHash(hash) {
+				return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+			}
+			if !slices.Contains(u.passwords, hash) {
+				u.passwords = append(u.passwords, hash)
+			}
+			u.nopass = false
+		case '<', '!':
+			hash := rule[1:]
+			if rule[0] == '<' {
+				hash = hashPassword(rule[1:])
+			}
+			i := slices.Index(u.passwords, hash)
+			if i < 0 {
+				return errNoSuchPassword
+			}
+			u.passwords = slices.Delete(u.passwords, i, i+1)
+		case '~':
+			u.keys = append(u.keys, keyPattern{rule[1:], keyRead | keyWrite})
+		case '%':
+			perms, pattern, ok := strings.Cut(rule[1:], "~")
+			flags := 0
+			for _, p := range strings.ToUpper(perms) {
+				switch p {
+				case 'R':
+					flags |= keyRead
+				case 'W':
+					flags |= keyWrite
+				default:
+					ok = false
+				}
+			}
+			if !ok || flags == 0 {
+				return errors.New("Syntax error")
+			}
+			u.keys = append(u.keys, keyPattern{pattern, flags})
+		case '&':
+			u.channels = append(u.channels, rule[1:])
+		case '+', '-':
+			return u.applyCommandRule(rule[0] == '+', strings.ToLower(rule[1:]))
+		default:
+			return errors.New("Syntax error")
+		}
+	}
+	return nil
+}
+
+// applyCommandRule allows or denies a category, a command with all its
+// subcommands, or a single subcommand.
+func (u *aclUser) applyCommandRule(allow bool, name string) error {
+	rule := "-" + name
+	if allow {
+		rule = "+" + name
+	}
+
+	if category, ok := strings.CutPrefix(name, "@"); ok {
+		if category == "all" {
+			for _, spec := range commandList {
+				u.allowed[spec.name] = allow
+			}
+			u.commandRules = []string{rule}
+			return nil
+		}
+		if !slices.Contains(aclCategories, category) {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		for _, spec := range commandList {
+			if slices.Contains(spec.categories, category) {
+				u.allowed[spec.name] = allow
+			}
+		}
+		u.commandRules = append(u.commandRules, rule)
+		return nil
+	}
+
+	spec := lookupCommandName(name)
+	if spec == nil {
+		return errors.New("Unknown command or category name in ACL")
+	}
+	u.allowed[spec.name] = allow
+	for _, sub := range spec.subcommands {
+		u.allowed[sub.name] = allow
+	}
+	u.commandRules = append(u.commandRules, rule)
+	return nil
+}
+
+// check returns why u may not run cmd, as the ACL LOG reason and the
+// command, key or channel denied. The reason is empty if it may.
+func (u *aclUser) check(cmd *Command) (reason, object string) {
+	spec := lookupCommand(cmd)
+	if spec == nil {
+		// Unknown commands fail on their own
+		return "", ""
+	}
+	if !u.allowed[spec.name] {
+		return "command", spec.name
+	}
+
+	for _, key := range spec.keys.keys(cmd) {
+		if !u.keyAllowed(key, spec.keys.flags) {
+			return "key", key
+		}
+	}
+
+	channels, patterns := commandChannels(cmd)
+	for _, channel := range channels {
+		if !u.channelAllowed(channel, patterns) {
+			return "channel", channel
+		}
+	}
+	return "", ""
+}
+
+// keyAllowed reports whether one of u's key patterns matches key and
+// grants the access a command with the given key spec flags needs: read
+// to get at the data, write to change it, like in redis.
+func (u *aclUser) keyAllowed(key string, specFlags int) bool {
+	need := 0
+	if specFlags&keyAccess != 0 {
+		need |= keyRead
+	}
+	if specFlags&(keyUpdate|keyInsert|keyDelete) != 0 {
+		need |= keyWrite
+	}
+	for _, k := range u.keys {
+		if k.flags&need == need && matchesGlob(key, k.pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// channelAllowed reports whether u may use channel. A pattern subscription
+// has to be allowed as is, not just overlap the allowed channels.
+func (u *aclUser) channelAllowed(channel string, pattern bool) bool {
+	for _, allowed := range u.channels {
+		if allowed == "*" || !pattern && matchesGlob(channel, allowed) || pattern && allowed == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// denyMessage explains a denial returned by check.
+func (u *aclUser) denyMessage(reason, object string) string {
+	switch reason {
+	case "key":
+		return "No permissions to access a key"
+	case "channel":
+		return "No permissions to access a channel"
+	}
+	return fmt.Sprintf("User %s has no permissions to run the '%s' command", u.name, object)
+}
+
+// describe returns u as the rules that recreate it, the form ACL LIST and
+// the aclfile use.
+func (u *aclUser) describe() string {
+	parts := []string{"user", u.name, "off"}
+	if u.enabled {
+		parts[2] = "on"
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, hash := range u.passwords {
+		parts = append(parts, "#"+hash)
+	}
+	for _, k := range u.keys {
+		parts = append(parts, k.String())
+	}
+	if slices.Equal(u.channels, []string{"*"}) {
+		parts = append(parts, "&*")
+	} else {
+		parts = append(parts, "resetchannels")
+		for _, channel := range u.channels {
+			parts = append(parts, "&"+channel)
+		}
+	}
+	return strings.Join(append(parts, u.commandRules...), " ")
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPassword reports whether password is one of u's, comparing hashes
+// in constant time.
+func (u *aclUser) checkPassword(password string) bool {
+	if u.nopass {
+		return true
+	}
+	hash := hashPassword(password)
+	ok := false
+	for _, h := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// aclUsers returns the users by name, creating the default user on first
+// use. The caller holds mu.
+func (r *Radisa) aclUsers() map[string]*aclUser {
+	if r.users == nil {
+		r.users = map[string]*aclUser{"default": newDefaultUser(r.config.RequirePass)}
+	}
+	return r.users
+}
+
+// aclDenied checks that c's user may run cmd, logging the denial if not.
+// context is "toplevel" or "multi". The caller holds mu.
+func (r *Radisa) aclDenied(c *client, cmd *Command, context string) []byte {
+	if spec := commandTable[cmd.Name]; c.user == nil || spec != nil && spec.flags&cmdNoAuth != 0 {
+		return nil
+	}
+	reason, object := c.user.check(cmd)
+	if reason == "" {
+		return nil
+	}
+	r.addACLLogEntry(c, reason, context, object, c.user.name)
+	return FormatErrorCode("NOPERM", c.user.denyMessage(reason, object))
+}
+
+// checkACL is aclDenied for a command about to run on its own.
+func (r *Radisa) checkACL(c *client, cmd *Command) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.aclDenied(c, cmd, "toplevel")
+}
+
+// parseACLFile reads users in the describe form, one per line. A missing
+// default user is created as if there was no file.
+func (r *Radisa) parseACLFile(src io.Reader, path string) (map[string]*aclUser, error) {
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(src)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d should start with user keyword", path, line)
+		}
+		if _, ok := users[fields[1]]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate user '%s' found", path, line, fields[1])
+		}
+		u := newACLUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := u.applyRule(rule); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v. Error in user declaration '%s'", path, line, err, fields[1])
+			}
+		}
+		users[u.name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if users["default"] == nil {
+		users["default"] = newDefaultUser(r.config.RequirePass)
+	}
+	return users, nil
+}
+
+// loadACLFile replaces the users with the ones in aclfile. Clients keep
+// their user if it still exists, those whose user is gone are
+// disconnected. The caller holds mu.
+func (r *Radisa) loadACLFile() error {
+	f, err := os.Open(r.config.ACLFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	users, err := r.parseACLFile(f, r.config.ACLFile)
+	if err != nil {
+		return err
+	}
+
+	// Update users in place, so clients see their new rules
+	old := r.aclUsers()
+	for name, u := range users {
+		if prev, ok := old[name]; ok {
+			*prev = *u
+			users[name] = prev
+		}
+	}
+	r.users = users
+	for _, c := range r.clients {
+		if c.user != nil && users[c.user.name] != c.user {
+			c.conn.Close()
+		}
+	}
+	return nil
+}
+
+// saveACLFile writes the users to aclfile, sorted by name. The caller
+// holds mu.
+func (r *Radisa) saveACLFile() error {
+	users := r.aclUsers()
+	return writeFileAtomically(r.config.ACLFile, func(w io.Writer) error {
+		for _, name := range slices.Sorted(maps.Keys(users)) {
+			if _, err := fmt.Fprintln(w, users[name].describe()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// aclCommand implements the ACL subcommands.
+func (r *Radisa) aclCommand(c *client, cmd *Command) []byte {
+	if len(cmd.Args) == 0 {
+		return FormatError("wrong number of arguments for 'acl' command")
+	}
+	sub, args := strings.ToUpper(cmd.Args[0]), cmd.Args[1:]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := r.aclUsers()
+
+	switch sub {
+	case "WHOAMI":
+		if c.user == nil {
+			return FormatBulk("default")
+		}
+		return FormatBulk(c.user.name)
+
+	case "USERS":
+		return FormatArray(slices.Sorted(maps.Keys(users)))
+
+	case "LIST":
+		var lines []string
+		for _, name := range slices.Sorted(maps.Keys(users)) {
+			lines = append(lines, users[name].describe())
+		}
+		return FormatArray(lines)
+
+	case "CAT":
+		if len(args) == 0 {
+			return FormatArray(aclCategories)
+		}
+		category := strings.ToLower(args[0])
+		if !slices.Contains(aclCategories, category) {
+			return FormatError("Unknown category '" + args[0] + "'")
+		}
+		var names []string
+		for _, spec := range commandList {
+			if slices.Contains(spec.categories, category) {
+				names = append(names, spec.name)
+			}
+		}
+		return FormatArray(names)
+
+	case "SETUSER":
+		if len(args) == 0 {
+			return FormatError("wrong number of arguments for 'acl|setuser' command")
+		}
+		u, ok := users[args[0]]
+		if !ok {
+			u = newACLUser(args[0])
+		}
+		// Rules apply all or nothing
+		updated := u.clone()
+		for _, rule := range args[1:] {
+			if err := updated.applyRule(rule); err != nil {
+				return FormatError(fmt.Sprintf("Error in ACL SETUSER modifier '%s': %v", rule, err))
+			}
+		}
+		*u = *updated
+		users[u.name] = u
+		return FormatSimpleString("OK")
+
+	case "GETUSER":
+		if len(args) != 1 {
+			return FormatError("wrong number of arguments for 'acl|getuser' command")
+		}
+		u, ok := users[args[0]]
+		if !ok {
+			return FormatNullBulkString()
+		}
+		return formatACLUser(u, c.resp)
+
+	case "DELUSER":
+		if len(args) == 0 {
+			return FormatError("wrong number of arguments for 'acl|deluser' command")
+		}
+		deleted := 0
+		for _, name := range args {
+			if name == "default" {
+				return FormatError("The 'default' user cannot be removed")
+			}
+		}
+		for _, name := range args {
+			u, ok := users[name]
+			if !ok {
+				continue
+			}
+			delete(users, name)
+			deleted++
+			// Connections logged in as the user go with it
+			for _, other := range r.clients {
+				if other.user != u {
+					continue
+				}
+				if other == c {
+					c.closeAfterReply = true
+				} else {
+					other.conn.Close()
+				}
+			}
+		}
+		return FormatInteger(int64(deleted))
+
+	case "LOG":
+		count := 10
+		if len(args) > 0 {
+			if strings.EqualFold(args[0], "RESET") {
+				r.aclLog = nil
+				return FormatSimpleString("OK")
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return FormatError("value is out of range, must be positive")
+			}
+			count = n
+		}
+		return r.aclLogReply(count)
+
+	case "DRYRUN":
+		if len(args) < 2 {
+			return FormatError("wrong number of arguments for 'acl|dryrun' command")
+		}
+		u, ok := users[args[0]]
+		if !ok {
+			return FormatError("User '" + args[0] + "' not found")
+		}
+		dry := &Command{Name: strings.ToUpper(args[1]), Args: args[2:]}
+		if commandTable[dry.Name] == nil {
+			return FormatError("Command '" + args[1] + "' not found")
+		}
+		if reply := checkArity(dry); reply != nil {
+			return reply
+		}
+		switch reason, object := u.check(dry); reason {
+		case "":
+			return FormatSimpleString("OK")
+		case "command":
+			return FormatBulk(u.denyMessage(reason, object))
+		default:
+			return FormatBulk(fmt.Sprintf("User %s has no permissions to access the '%s' %s", u.name, object, reason))
+		}
+
+	case "SAVE", "LOAD":
+		if r.config.ACLFile == "" {
+			return FormatError("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		if sub == "SAVE" {
+			if err := r.saveACLFile(); err != nil {
+				return FormatError("There was an error trying to save the ACLs. Please check the server logs for more information")
+			}
+		} else if err := r.loadACLFile(); err != nil {
+			return FormatError(err.Error())
+		}
+		return FormatSimpleString("OK")
+	}
+	return FormatError("unknown subcommand '" + cmd.Args[0] + "'. Try ACL HELP.")
+}
+
+// formatACLUser formats ACL GETUSER's reply, a map on RESP3.
+func formatACLUser(u *aclUser, resp int) []byte {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	keys := make([]string, len(u.keys))
+	for i, k := range u.keys {
+		keys[i] = k.String()
+	}
+	channels := make([]string, len(u.channels))
+	for i, channel := range u.channels {
+		channels[i] = "&" + channel
+	}
+
+	return formatPairs([][]byte{
+		FormatBulk("flags"), FormatArray(flags),
+		FormatBulk("passwords"), FormatArray(u.passwords),
+		FormatBulk("commands"), FormatBulk(strings.Join(u.commandRules, " ")),
+		FormatBulk("keys"), FormatBulk(strings.Join(keys, " ")),
+		FormatBulk("channels"), FormatBulk(strings.Join(channels, " ")),
+		FormatBulk("selectors"), FormatArray(nil),
+	}, resp)
+}
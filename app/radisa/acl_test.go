@@ -0,0 +1,173 @@
+package radisa
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestACLUser_Rules(t *testing.T) {
+	u := newACLUser("alice")
+	rules := []string{"on", ">pw", "~cache:*", "%R~ro:*", "%W~wo:*", "&news.*", "+@read", "-@dangerous", "+set", "+config|get", "+publish", "+psubscribe"}
+	for _, rule := range rules {
+		if err := u.applyRule(rule); err != nil {
+			t.Fatalf("Expected %q to apply, got: %v", rule, err)
+		}
+	}
+
+	tests := []struct {
+		args   []string
+		reason string
+		object string
+	}{
+		{[]string{"GET", "cache:1"}, "", ""},
+		{[]string{"GET", "other"}, "key", "other"},
+		{[]string{"SET", "cache:1", "v"}, "", ""},
+		{[]string{"SET", "ro:1", "v"}, "key", "ro:1"},
+		{[]string{"GET", "ro:1"}, "", ""},
+		{[]string{"GET", "wo:1"}, "key", "wo:1"},
+		{[]string{"SET", "wo:1", "v"}, "", ""},
+		{[]string{"DEL", "cache:1"}, "command", "del"},
+		{[]string{"KEYS", "*"}, "command", "keys"},
+		{[]string{"CONFIG", "GET", "dir"}, "", ""},
+		{[]string{"CONFIG", "SET", "dir", "/"}, "command", "config"},
+		{[]string{"PUBLISH", "news.eu", "m"}, "", ""},
+		{[]string{"PUBLISH", "sports", "m"}, "channel", "sports"},
+		{[]string{"PSUBSCRIBE", "news.*"}, "", ""},
+		{[]string{"PSUBSCRIBE", "news.e*"}, "channel", "news.e*"},
+	}
+	for _, tt := range tests {
+		reason, object := u.check(&Command{Name: tt.args[0], Args: tt.args[1:]})
+		if reason != tt.reason || object != tt.object {
+			t.Errorf("Expected %q %q for %v, got %q %q", tt.reason, tt.object, tt.args, reason, object)
+		}
+	}
+
+	expected := "user alice on #" + hashPassword("pw") + " ~cache:* %R~ro:* %W~wo:* resetchannels &news.* -@all +@read -@dangerous +set +config|get +publish +psubscribe"
+	if got := u.describe(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	for _, rule := range []string{"+nosuch", "+@nosuch", "#abc", "%X~a", "<notset", "bogus"} {
+		if err := u.clone().applyRule(rule); err == nil {
+			t.Errorf("Expected %q to be refused", rule)
+		}
+	}
+}
+
+func TestACL_Enforcement(t *testing.T) {
+	r := createTestServer()
+	r.config.ACLLogMaxLen = 128
+	port := serveForTest(t, r)
+	admin, adminReader := dialForTest(t, port)
+	conn, reader := dialForTest(t, port)
+
+	if got := commandForTest(t, admin, adminReader, "ACL", "SETUSER", "alice", "on", ">pw", "~cache:*", "+get", "+acl|whoami", "+multi", "+exec"); got != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", got)
+	}
+	if got := commandForTest(t, admin, adminReader, "ACL", "SETUSER", "bob", "+nosuch"); !strings.HasPrefix(got, "-ERR Error in ACL SETUSER modifier '+nosuch'") {
+		t.Errorf("Expected SETUSER to fail, got %q", got)
+	}
+	if got := commandForTest(t, conn, reader, "AUTH", "alice", "wrong"); !strings.HasPrefix(got, "-WRONGPASS") {
+		t.Errorf("Expected WRONGPASS, got %q", got)
+	}
+	commandForTest(t, conn, reader, "AUTH", "alice", "pw")
+
+	if got := commandForTest(t, conn, reader, "ACL", "WHOAMI"); got != "$5\r\nalice\r\n" {
+		t.Errorf("Expected alice, got %q", got)
+	}
+	if got := commandForTest(t, conn, reader, "GET", "cache:1"); got != "$-1\r\n" {
+		t.Errorf("Expected GET to be allowed, got %q", got)
+	}
+	for range 2 {
+		if got := commandForTest(t, conn, reader, "GET", "other"); got != "-NOPERM No permissions to access a key\r\n" {
+			t.Errorf("Expected NOPERM, got %q", got)
+		}
+	}
+	if got := commandForTest(t, conn, reader, "SET", "cache:1", "v"); got != "-NOPERM User alice has no permissions to run the 'set' command\r\n" {
+		t.Errorf("Expected NOPERM, got %q", got)
+	}
+
+	// Denied while queuing fails the transaction
+	commandForTest(t, conn, reader, "MULTI")
+	commandForTest(t, conn, reader, "SET", "cache:1", "v")
+	if got := commandForTest(t, conn, reader, "EXEC"); !strings.HasPrefix(got, "-EXECABORT") {
+		t.Errorf("Expected EXECABORT, got %q", got)
+	}
+
+	log := commandForTest(t, admin, adminReader, "ACL", "LOG")
+	entries := strings.Split(log, "$5\r\ncount\r\n")[1:]
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 log entries, got %q", log)
+	}
+	for i, want := range []string{
+		":1\r\n$6\r\nreason\r\n$7\r\ncommand\r\n$7\r\ncontext\r\n$5\r\nmulti\r\n$6\r\nobject\r\n$3\r\nset\r\n",
+		":1\r\n$6\r\nreason\r\n$7\r\ncommand\r\n$7\r\ncontext\r\n$8\r\ntoplevel\r\n$6\r\nobject\r\n$3\r\nset\r\n",
+		":2\r\n$6\r\nreason\r\n$3\r\nkey\r\n$7\r\ncontext\r\n$8\r\ntoplevel\r\n$6\r\nobject\r\n$5\r\nother\r\n",
+		":1\r\n$6\r\nreason\r\n$4\r\nauth\r\n$7\r\ncontext\r\n$8\r\ntoplevel\r\n$6\r\nobject\r\n$4\r\nAUTH\r\n",
+	} {
+		if !strings.HasPrefix(entries[i], want) {
+			t.Errorf("Expected entry %d to start with %q, got %q", i, want, entries[i])
+		}
+	}
+	commandForTest(t, admin, adminReader, "ACL", "LOG", "RESET")
+	if got := commandForTest(t, admin, adminReader, "ACL", "LOG"); got != "*0\r\n" {
+		t.Errorf("Expected an empty log, got %q", got)
+	}
+
+	if got := commandForTest(t, admin, adminReader, "ACL", "DRYRUN", "alice", "GET", "other"); got != "$55\r\nUser alice has no permissions to access the 'other' key\r\n" {
+		t.Errorf("Expected a key denial, got %q", got)
+	}
+	if got := commandForTest(t, admin, adminReader, "ACL", "DRYRUN", "alice", "GET", "cache:2"); got != "+OK\r\n" {
+		t.Errorf("Expected OK, got %q", got)
+	}
+
+	// Deleting a user disconnects whoever is logged in as it
+	if got := commandForTest(t, admin, adminReader, "ACL", "DELUSER", "alice", "nobody"); got != ":1\r\n" {
+		t.Errorf("Expected 1 deleted user, got %q", got)
+	}
+	if _, err := reader.ReadString('\n'); err != io.EOF {
+		t.Errorf("Expected alice to be disconnected, got: %v", err)
+	}
+	if got := commandForTest(t, admin, adminReader, "ACL", "DELUSER", "default"); got != "-ERR The 'default' user cannot be removed\r\n" {
+		t.Errorf("Expected the default user to stay, got %q", got)
+	}
+}
+
+func TestACL_SaveAndLoad(t *testing.T) {
+	config := DefaultConfig()
+	config.ACLFile = filepath.Join(t.TempDir(), "users.acl")
+	os.WriteFile(config.ACLFile, []byte("user default on nopass ~* &* +@all\n"), 0o644)
+	r := NewRadisa(t.TempDir(), "dump.rdb", 0, config)
+	if r.loadErr != nil {
+		t.Fatal(r.loadErr)
+	}
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	commandForTest(t, conn, reader, "ACL", "SETUSER", "cache", "on", ">pw", "%R~cache:*", "&inv", "+@read", "-keys")
+	if got := commandForTest(t, conn, reader, "ACL", "SAVE"); got != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", got)
+	}
+
+	loaded := NewRadisa(t.TempDir(), "dump.rdb", 0, config)
+	if loaded.loadErr != nil {
+		t.Fatal(loaded.loadErr)
+	}
+	for _, name := range []string{"default", "cache"} {
+		if got, expected := loaded.users[name].describe(), r.users[name].describe(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+
+	// A bad file is refused as a whole
+	os.WriteFile(config.ACLFile, []byte("user a on +get\nuser b on +nosuch\n"), 0o644)
+	got := commandForTest(t, conn, reader, "ACL", "LOAD")
+	if !strings.Contains(got, "users.acl:2: Unknown command or category name in ACL") {
+		t.Errorf("Expected an error for line 2, got %q", got)
+	}
+	if got := commandForTest(t, conn, reader, "ACL", "USERS"); got != "*2\r\n$5\r\ncache\r\n$7\r\ndefault\r\n" {
+		t.Errorf("Expected the users to stay, got %q", got)
+	}
+}
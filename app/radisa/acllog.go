@@ -0,0 +1,89 @@
+package radisa
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// aclLogGroupingWindow is how long a denial that repeats an earlier one
+// only bumps its count instead of adding an entry.
+const aclLogGroupingWindow = 60 * time.Second
+
+// aclLogEntry is a denied command, key, channel or login, as ACL LOG
+// reports it.
+type aclLogEntry struct {
+	count      int
+	reason     string
+	context    string
+	object     string
+	username   string
+	clientInfo string
+	entryID    int64
+	created    time.Time
+	updated    time.Time
+}
+
+// addACLLogEntry records a denial, newest first, keeping at most
+// acllog-max-len entries. The caller holds mu.
+func (r *Radisa) addACLLogEntry(c *client, reason, context, object, username string) {
+	now := time.Now()
+	for _, e := range r.aclLog {
+		if e.reason == reason && e.context == context && e.object == object && e.username == username &&
+			now.Sub(e.updated) < aclLogGroupingWindow {
+			e.count++
+			e.updated = now
+			e.clientInfo = c.info()
+			return
+		}
+	}
+
+	r.aclLogNextID++
+	entry := &aclLogEntry{
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: c.info(),
+		entryID:    r.aclLogNextID - 1,
+		created:    now,
+		updated:    now,
+	}
+	r.aclLog = append([]*aclLogEntry{entry}, r.aclLog...)
+	if len(r.aclLog) > r.config.ACLLogMaxLen {
+		r.aclLog = r.aclLog[:r.config.ACLLogMaxLen]
+	}
+}
+
+// aclLogReply formats up to count entries for ACL LOG.
+func (r *Radisa) aclLogReply(count int) []byte {
+	entries := r.aclLog[:min(count, len(r.aclLog))]
+	replies := make([][]byte, len(entries))
+	now := time.Now()
+	for i, e := range entries {
+		age := now.Sub(e.created).Seconds()
+		replies[i] = FormatReplies([][]byte{
+			FormatBulk("count"), FormatInteger(int64(e.count)),
+			FormatBulk("reason"), FormatBulk(e.reason),
+			FormatBulk("context"), FormatBulk(e.context),
+			FormatBulk("object"), FormatBulk(e.object),
+			FormatBulk("username"), FormatBulk(e.username),
+			FormatBulk("age-seconds"), FormatBulk(strconv.FormatFloat(age, 'f', 3, 64)),
+			FormatBulk("client-info"), FormatBulk(e.clientInfo),
+			FormatBulk("entry-id"), FormatInteger(e.entryID),
+			FormatBulk("timestamp-created"), FormatInteger(e.created.UnixMilli()),
+			FormatBulk("timestamp-last-updated"), FormatInteger(e.updated.UnixMilli()),
+		})
+	}
+	return FormatReplies(replies)
+}
+
+// info describes c for the ACL LOG, like a short CLIENT INFO line.
+func (c *client) info() string {
+	user := ""
+	if c.user != nil {
+		user = c.user.name
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s user=%s resp=%d", c.id, c.conn.RemoteAddr(), c.conn.LocalAddr(), user, c.resp)
+}
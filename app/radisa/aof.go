@@ -0,0 +1,655 @@
+package radisa
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The AOF uses redis 7's multi-part layout: a manifest in Dir/AppendDirName
+// lists one base file (an RDB snapshot or a command log) and the incremental
+// command logs written after it, in the order they are replayed.
+const (
+	aofTypeBase    = 'b'
+	aofTypeHistory = 'h'
+	aofTypeIncr    = 'i'
+)
+
+type aofFileInfo struct {
+	name string
+	seq  int
+	typ  byte
+}
+
+type aofManifest struct {
+	base    *aofFileInfo
+	incrs   []aofFileInfo
+	history []aofFileInfo
+}
+
+// parseAOFManifest reads lines like "file appendonly.aof.1.base.rdb seq 1 type b".
+func parseAOFManifest(data []byte) (*aofManifest, error) {
+	m := &aofManifest{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("manifest line %d: expected key value pairs", lineNo)
+		}
+
+		var info aofFileInfo
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				info.name = fields[i+1]
+			case "seq":
+				seq, err := strconv.Atoi(fields[i+1])
+				if err != nil || seq < 1 {
+					return nil, fmt.Errorf("manifest line %d: invalid seq %q", lineNo, fields[i+1])
+				}
+				info.seq = seq
+			case "type":
+				if len(fields[i+1]) != 1 {
+					return nil, fmt.Errorf("manifest line %d: invalid type %q", lineNo, fields[i+1])
+				}
+				info.typ = fields[i+1][0]
+			}
+		}
+		if info.name == "" || info.seq == 0 || strings.ContainsAny(info.name, `/\`) {
+			return nil, fmt.Errorf("manifest line %d: missing or invalid file", lineNo)
+		}
+
+		switch info.typ {
+		case aofTypeBase:
+			if m.base != nil {
+				return nil, fmt.Errorf("manifest line %d: more than one base file", lineNo)
+			}
+			m.base = &info
+		case aofTypeIncr:
+			if len(m.incrs) > 0 && m.incrs[len(m.incrs)-1].seq >= info.seq {
+				return nil, fmt.Errorf("manifest line %d: incr files out of order", lineNo)
+			}
+			m.incrs = append(m.incrs, info)
+		case aofTypeHistory:
+			m.history = append(m.history, info)
+		default:
+			return nil, fmt.Errorf("manifest line %d: unknown type %q", lineNo, info.typ)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.base == nil && len(m.incrs) == 0 {
+		return nil, errors.New("manifest lists no files")
+	}
+	return m, nil
+}
+
+func (m *aofManifest) String() string {
+	var sb strings.Builder
+	write := func(info aofFileInfo) {
+		fmt.Fprintf(&sb, "file %s seq %d type %c\n", info.name, info.seq, info.typ)
+	}
+	if m.base != nil {
+		write(*m.base)
+	}
+	for _, info := range m.history {
+		write(info)
+	}
+	for _, info := range m.incrs {
+		write(info)
+	}
+	return sb.String()
+}
+
+// aof appends commands to the last incr file. Commands go through buf so
+// that a failed write is retried by fsyncCron instead of being lost.
+type aof struct {
+	mu       sync.Mutex
+	dir      string
+	prefix   string
+	fsync    string
+	manifest *aofManifest
+	file     *os.File
+	buf      []byte
+	err      error
+	unsynced bool
+
+	// size is the total of all files in the manifest, baseSize what it was
+	// after the last rewrite; their ratio triggers automatic rewrites.
+	size     int64
+	baseSize int64
+
+	rewriting  bool
+	rewriteErr error
+	rewriteTry time.Time
+}
+
+func (a *aof) manifestPath() string {
+	return filepath.Join(a.dir, a.prefix+".manifest")
+}
+
+func (a *aof) baseName(seq int, rdb bool) string {
+	if rdb {
+		return fmt.Sprintf("%s.%d.base.rdb", a.prefix, seq)
+	}
+	return fmt.Sprintf("%s.%d.base.aof", a.prefix, seq)
+}
+
+func (a *aof) incrName(seq int) string {
+	return fmt.Sprintf("%s.%d.incr.aof", a.prefix, seq)
+}
+
+// writeManifest replaces the manifest atomically, which is what commits a
+// new set of AOF files.
+func (a *aof) writeManifest(m *aofManifest) error {
+	err := writeFileAtomically(a.manifestPath(), func(w io.Writer) error {
+		_, err := io.WriteString(w, m.String())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return syncDir(a.dir)
+}
+
+func (a *aof) openIncr(name string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(a.dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+// manifestSize adds up the sizes of the base and incr files.
+func (a *aof) manifestSize(m *aofManifest) int64 {
+	files := m.incrs
+	if m.base != nil {
+		files = append([]aofFileInfo{*m.base}, files...)
+	}
+
+	var size int64
+	for _, f := range files {
+		if info, err := os.Stat(filepath.Join(a.dir, f.name)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func (a *aof) append(cmd *Command) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.buf = append(a.buf, FormatCommand(cmd)...)
+	a.flush()
+}
+
+// flush writes buf out, fsyncing right away for appendfsync always. The
+// caller holds a.mu.
+func (a *aof) flush() {
+	if len(a.buf) == 0 {
+		return
+	}
+
+	n, err := a.file.Write(a.buf)
+	a.size += int64(n)
+	a.buf = append(a.buf[:0], a.buf[n:]...)
+	if err != nil {
+		if a.err == nil {
+			fmt.Printf("Error writing to the AOF file: %v\n", err)
+		}
+		a.err = err
+		return
+	}
+
+	if a.fsync == FsyncAlways {
+		if err := a.file.Sync(); err != nil {
+			fmt.Printf("Error fsyncing the AOF file: %v\n", err)
+			a.err = err
+			return
+		}
+	} else {
+		a.unsynced = true
+	}
+	a.err = nil
+}
+
+// writeError reports why the last write failed, nil once a retry succeeded.
+func (a *aof) writeError() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// fsyncCron retries failed writes and, for appendfsync everysec, fsyncs
+// once a second outside of a.mu so writers don't wait on the disk.
+func (a *aof) fsyncCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.mu.Lock()
+		a.flush()
+		file := a.file
+		needSync := a.fsync == FsyncEverySec && a.unsynced
+		if needSync {
+			a.unsynced = false
+		}
+		a.mu.Unlock()
+
+		if needSync {
+			if err := file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				fmt.Printf("Error fsyncing the AOF file: %v\n", err)
+			}
+		}
+	}
+}
+
+// openAOF loads the keyspace from the AOF and opens its last incr file for
+// appending. The first time appendonly is on there is no AOF yet, so the RDB
+// is loaded and written out as the base of a new one.
+func (r *Radisa) openAOF() error {
+	a := &aof{
+		dir:    filepath.Join(r.dir, r.config.AppendDirName),
+		prefix: r.config.AppendFilename,
+		fsync:  r.config.AppendFsync,
+	}
+
+	manifest, err := os.ReadFile(a.manifestPath())
+	if errors.Is(err, os.ErrNotExist) {
+		if err := r.loadRDBFile(); err != nil {
+			return err
+		}
+		if err := r.createAOF(a); err != nil {
+			return fmt.Errorf("failed to create AOF in %s: %v", a.dir, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read AOF manifest: %v", err)
+	} else {
+		if a.manifest, err = parseAOFManifest(manifest); err != nil {
+			return fmt.Errorf("bad AOF manifest %s: %v", a.manifestPath(), err)
+		}
+		if err := r.loadAOF(a); err != nil {
+			return err
+		}
+	}
+
+	if len(a.manifest.incrs) == 0 {
+		// Nothing to append to yet, start the first incr file
+		next := aofFileInfo{name: a.incrName(a.manifest.base.seq), seq: a.manifest.base.seq, typ: aofTypeIncr}
+		a.manifest.incrs = append(a.manifest.incrs, next)
+		if err := a.writeManifest(a.manifest); err != nil {
+			return err
+		}
+	}
+	a.file, err = a.openIncr(a.manifest.incrs[len(a.manifest.incrs)-1].name)
+	if err != nil {
+		return fmt.Errorf("failed to open AOF: %v", err)
+	}
+	a.size = a.manifestSize(a.manifest)
+	a.baseSize = a.size
+
+	r.aof = a
+	return nil
+}
+
+func (r *Radisa) createAOF(a *aof) error {
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return err
+	}
+
+	base := aofFileInfo{name: a.baseName(1, true), seq: 1, typ: aofTypeBase}
+	if err := writeRDBAtomically(filepath.Join(a.dir, base.name), r.data, r.config.RDBCompression); err != nil {
+		return err
+	}
+
+	a.manifest = &aofManifest{
+		base:  &base,
+		incrs: []aofFileInfo{{name: a.incrName(1), seq: 1, typ: aofTypeIncr}},
+	}
+	return a.writeManifest(a.manifest)
+}
+
+// loadAOF replays the base and then every incr file in manifest order.
+func (r *Radisa) loadAOF(a *aof) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.data = make(map[string]Data)
+	if base := a.manifest.base; base != nil {
+		if err := r.loadAOFBase(filepath.Join(a.dir, base.name), len(a.manifest.incrs) == 0); err != nil {
+			return err
+		}
+	}
+	for i, incr := range a.manifest.incrs {
+		last := i == len(a.manifest.incrs)-1
+		if err := r.replayAOF(filepath.Join(a.dir, incr.name), last); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("DB loaded from append only file: %d keys\n", len(r.data))
+	return nil
+}
+
+// loadAOFBase reads a base file, which is an RDB if it starts with the RDB
+// magic and a command log otherwise.
+func (r *Radisa) loadAOFBase(path string, last bool) error {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read AOF base: %v", err)
+	}
+
+	if !bytes.HasPrefix(file, []byte("REDIS")) {
+		return r.replayAOF(path, last)
+	}
+
+	data, err := NewRDBParser(file).Parse()
+	if err != nil {
+		return fmt.Errorf("bad AOF base %s: %v", path, err)
+	}
+	r.data = data
+	return nil
+}
+
+// replayAOF executes every command of a command log. A command cut short at
+// the end of the last file is what a crash mid-write leaves behind; with
+// aof-load-truncated it is dropped and the file truncated to the last
+// complete command. A transaction only runs once its EXEC is read, so one
+// the crash cut short is dropped the same way, from its MULTI on. The
+// caller holds mu.
+func (r *Radisa) replayAOF(path string, last bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open AOF %s: %v", path, err)
+	}
+	defer file.Close()
+
+	r.loading = true
+	defer func() { r.loading = false }()
+
+	reader := NewRESPReader(file)
+	var multi []*Command
+	multiOffset := int64(-1)
+	for {
+		offset := reader.Offset()
+		cmd, err := reader.ReadCommand()
+		if err == io.EOF && multiOffset >= 0 {
+			fmt.Printf("!!! Warning: revert incomplete MULTI/EXEC transaction in AOF file %s!!!\n", path)
+			err = io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			valid := reader.Offset()
+			if multiOffset >= 0 {
+				valid = multiOffset
+			}
+			if !last || !r.config.AOFLoadTruncated {
+				return fmt.Errorf("unexpected end of AOF %s at offset %d", path, valid)
+			}
+			fmt.Printf("!!! Warning: short read while loading the AOF file %s!!!\n", path)
+			fmt.Printf("AOF loaded anyway because aof-load-truncated is enabled, truncating to %d bytes\n", valid)
+			return os.Truncate(path, valid)
+		}
+		if err != nil {
+			return fmt.Errorf("bad AOF %s at offset %d: %v", path, reader.Offset(), err)
+		}
+
+		switch {
+		case cmd.Name == "MULTI":
+			multi, multiOffset = nil, offset
+			continue
+		case multiOffset >= 0 && cmd.Name != "EXEC":
+			multi = append(multi, cmd)
+			continue
+		}
+		cmds := []*Command{cmd}
+		if multiOffset >= 0 {
+			cmds, multiOffset = multi, -1
+		}
+		for _, cmd := range cmds {
+			if response := r.execute(cmd); bytes.HasPrefix(response, []byte("-")) {
+				return fmt.Errorf("error replaying %s from AOF %s: %s", cmd.Name, path, strings.TrimSpace(string(response)))
+			}
+		}
+	}
+}
+
+var errRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+
+// bgrewriteaof compacts the AOF in the background. Writes go to a fresh incr
+// file from the start, the keyspace as of now becomes the new base, and once
+// the base is on disk the manifest is switched over and the old files
+// deleted. The caller holds mu.
+func (r *Radisa) bgrewriteaof() error {
+	a := r.aof
+	if a == nil {
+		return errors.New("Append only file is disabled, enable appendonly to rewrite it")
+	}
+	if err := a.beginRewrite(); err != nil {
+		return err
+	}
+
+	snap := r.snapshot()
+	preamble := r.config.AOFUseRDBPreamble
+	compress := r.config.RDBCompression
+	go func() {
+		data := snap.copy(r)
+		err := a.finishRewrite(data, preamble || !onlyStrings(data), compress)
+		if err != nil {
+			fmt.Printf("Background AOF rewrite error: %v\n", err)
+		} else {
+			fmt.Println("Background AOF rewrite finished successfully")
+		}
+	}()
+	return nil
+}
+
+// beginRewrite starts the incr file the rewritten base will be paired with.
+func (a *aof) beginRewrite() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rewriting {
+		return errRewriteInProgress
+	}
+	a.rewriteTry = time.Now()
+
+	last := a.manifest.incrs[len(a.manifest.incrs)-1]
+	next := aofFileInfo{name: a.incrName(last.seq + 1), seq: last.seq + 1, typ: aofTypeIncr}
+	file, err := a.openIncr(next.name)
+	if err != nil {
+		a.rewriteErr = err
+		return err
+	}
+
+	m := *a.manifest
+	m.incrs = append(slices.Clone(m.incrs), next)
+	if err := a.writeManifest(&m); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		a.rewriteErr = err
+		return err
+	}
+
+	// Anything still buffered after a failed write was applied before the
+	// snapshot, so the new base has it already. Replaying it on top of that
+	// would apply INCR and the like twice.
+	a.file.Sync()
+	a.file.Close()
+	a.file = file
+	a.buf = nil
+	a.err = nil
+	a.manifest = &m
+	a.rewriting = true
+	return nil
+}
+
+func (a *aof) finishRewrite(data map[string]Data, preamble bool, compress bool) error {
+	a.mu.Lock()
+	baseSeq := 1
+	if a.manifest.base != nil {
+		baseSeq = a.manifest.base.seq + 1
+	}
+	a.mu.Unlock()
+
+	base := aofFileInfo{name: a.baseName(baseSeq, preamble), seq: baseSeq, typ: aofTypeBase}
+	basePath := filepath.Join(a.dir, base.name)
+	var err error
+	if preamble {
+		err = writeRDBAtomically(basePath, data, compress)
+	} else {
+		err = writeFileAtomically(basePath, func(w io.Writer) error {
+			return writeAOFCommands(w, data)
+		})
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rewriting = false
+	if err != nil {
+		a.rewriteErr = err
+		return err
+	}
+
+	// Everything but the incr file started by beginRewrite is now history
+	m := &aofManifest{
+		base:    &base,
+		incrs:   a.manifest.incrs[len(a.manifest.incrs)-1:],
+		history: slices.Clone(a.manifest.history),
+	}
+	if a.manifest.base != nil {
+		m.history = append(m.history, *a.manifest.base)
+	}
+	for _, incr := range a.manifest.incrs[:len(a.manifest.incrs)-1] {
+		m.history = append(m.history, aofFileInfo{name: incr.name, seq: incr.seq, typ: aofTypeHistory})
+	}
+	if err := a.writeManifest(m); err != nil {
+		os.Remove(basePath)
+		a.rewriteErr = err
+		return err
+	}
+	a.manifest = m
+	a.rewriteErr = nil
+	a.size = a.manifestSize(m)
+	a.baseSize = a.size
+
+	for _, old := range m.history {
+		if err := os.Remove(filepath.Join(a.dir, old.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Failed to remove AOF history file %s: %v\n", old.name, err)
+		}
+	}
+	m.history = nil
+	if err := a.writeManifest(m); err != nil {
+		fmt.Printf("Failed to clean up AOF manifest: %v\n", err)
+	}
+	return nil
+}
+
+// onlyStrings reports whether data can be written as SET commands. There
+// are no commands yet to rebuild lists, sets, hashes, sorted sets or
+// streams, so those force the RDB preamble.
+func onlyStrings(data map[string]Data) bool {
+	for _, value := range data {
+		if value.kind != kindString {
+			return false
+		}
+	}
+	return true
+}
+
+// writeAOFCommands writes the shortest command log that rebuilds data.
+func writeAOFCommands(w io.Writer, data map[string]Data) error {
+	now := time.Now()
+	keys := slices.Sorted(maps.Keys(data))
+	for _, key := range keys {
+		value := data[key]
+		cmd := &Command{Name: "SET", Args: []string{key, value.value}}
+		if !value.expire.IsZero() {
+			if now.After(value.expire) {
+				continue
+			}
+			cmd.Args = append(cmd.Args, "PXAT", strconv.FormatInt(value.expire.UnixMilli(), 10))
+		}
+		if _, err := w.Write(FormatCommand(cmd)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shouldRewrite applies auto-aof-rewrite-percentage and
+// auto-aof-rewrite-min-size, backing off after a failed rewrite.
+func (a *aof) shouldRewrite(percentage int, minSize int64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if percentage <= 0 || a.rewriting || a.size < minSize {
+		return false
+	}
+	if a.rewriteErr != nil && time.Since(a.rewriteTry) < bgsaveRetryDelay {
+		return false
+	}
+	base := max(a.baseSize, 1)
+	return (a.size-base)*100/base >= int64(percentage)
+}
+
+// aofRewriteCron checks once a second whether the AOF grew enough to be
+// rewritten.
+func (r *Radisa) aofRewriteCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !r.aof.shouldRewrite(r.config.AutoAOFRewritePercentage, r.config.AutoAOFRewriteMinSize) {
+			continue
+		}
+		fmt.Println("Starting automatic rewriting of AOF")
+		r.mu.Lock()
+		if err := r.bgrewriteaof(); err != nil {
+			fmt.Printf("Can't start AOF rewrite: %v\n", err)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// propagate records a write that was just applied so it survives a restart
+// and reaches the replicas. The caller holds mu.
+func (r *Radisa) propagate(cmd *Command) {
+	if r.loading {
+		return
+	}
+	if r.multiPending {
+		r.multiPending = false
+		r.multiPropagated = true
+		r.propagate(&Command{Name: "MULTI"})
+	}
+	if r.aof != nil {
+		r.aof.append(cmd)
+	}
+	r.feedReplicas(cmd)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,302 @@
+package radisa
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func newAOFTestServer(t *testing.T, dir string, config Config) *Radisa {
+	t.Helper()
+	config.AppendOnly = true
+	config.AppendFsync = FsyncAlways
+	r := NewRadisa(dir, "dump.rdb", 0, config)
+	if r.loadErr != nil {
+		t.Fatalf("Expected AOF to load, got: %v", r.loadErr)
+	}
+	t.Cleanup(func() { r.aof.file.Close() })
+	return r
+}
+
+func TestAOFManifest_RoundTrip(t *testing.T) {
+	text := "file appendonly.aof.2.base.rdb seq 2 type b\n" +
+		"file appendonly.aof.1.incr.aof seq 1 type h\n" +
+		"file appendonly.aof.2.incr.aof seq 2 type i\n" +
+		"file appendonly.aof.3.incr.aof seq 3 type i\n"
+
+	m, err := parseAOFManifest([]byte(text))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if m.base.name != "appendonly.aof.2.base.rdb" || len(m.incrs) != 2 || len(m.history) != 1 {
+		t.Errorf("Expected base, one history and two incr files, got %+v", m)
+	}
+	if m.String() != text {
+		t.Errorf("Expected %q, got %q", text, m.String())
+	}
+}
+
+func TestAOFManifest_Invalid(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"file a seq 1\n",
+		"file a seq x type i\n",
+		"file a seq 1 type z\n",
+		"file ../a seq 1 type i\n",
+		"file a seq 1 type b\nfile b seq 2 type b\n",
+		"file a seq 2 type i\nfile b seq 1 type i\n",
+	} {
+		if _, err := parseAOFManifest([]byte(text)); err == nil {
+			t.Errorf("Expected error for %q", text)
+		}
+	}
+}
+
+func TestAOF_CreatesLayoutFromRDB(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeRDBAtomically(filepath.Join(dir, "dump.rdb"), map[string]Data{"seeded": {value: "yes"}}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newAOFTestServer(t, dir, DefaultConfig())
+
+	manifest, err := os.ReadFile(filepath.Join(dir, "appendonlydir", "appendonly.aof.manifest"))
+	if err != nil {
+		t.Fatalf("Expected manifest, got: %v", err)
+	}
+	expected := "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n"
+	if string(manifest) != expected {
+		t.Errorf("Expected %q, got %q", expected, manifest)
+	}
+	if r.data["seeded"].value != "yes" {
+		t.Errorf("Expected RDB keys to be loaded, got %v", r.data)
+	}
+}
+
+func TestAOF_ReplayAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	r := newAOFTestServer(t, dir, DefaultConfig())
+
+	r.executeCommand(&Command{Name: "SET", Args: []string{"a", "1"}})
+	r.executeCommand(&Command{Name: "SET", Args: []string{"a", "2"}})
+	r.executeCommand(&Command{Name: "SET", Args: []string{"b", "bin\r\nary"}})
+	r.executeCommand(&Command{Name: "SET", Args: []string{"c", "x", "PX", "60000"}})
+	deadline := r.data["c"].expire
+
+	restarted := newAOFTestServer(t, dir, DefaultConfig())
+	if restarted.data["a"].value != "2" {
+		t.Errorf("Expected 2, got %q", restarted.data["a"].value)
+	}
+	if restarted.data["b"].value != "bin\r\nary" {
+		t.Errorf("Expected binary value to survive, got %q", restarted.data["b"].value)
+	}
+	if restarted.data["c"].expire.UnixMilli() != deadline.UnixMilli() {
+		t.Errorf("Expected expiry %v, got %v", deadline, restarted.data["c"].expire)
+	}
+
+	// Replaying must not append the commands a second time
+	incr, _ := os.ReadFile(filepath.Join(dir, "appendonlydir", "appendonly.aof.1.incr.aof"))
+	expected := string(FormatCommand(&Command{Name: "SET", Args: []string{"a", "1"}})) +
+		string(FormatCommand(&Command{Name: "SET", Args: []string{"a", "2"}})) +
+		string(FormatCommand(&Command{Name: "SET", Args: []string{"b", "bin\r\nary"}})) +
+		string(FormatCommand(&Command{Name: "SET", Args: []string{"c", "x", "PXAT", strconv.FormatInt(deadline.UnixMilli(), 10)}}))
+	if string(incr) != expected {
+		t.Errorf("Expected %q, got %q", expected, incr)
+	}
+}
+
+func TestAOF_TruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	r := newAOFTestServer(t, dir, DefaultConfig())
+	r.executeCommand(&Command{Name: "SET", Args: []string{"kept", "1"}})
+	r.executeCommand(&Command{Name: "SET", Args: []string{"lost", "2"}})
+
+	incrPath := filepath.Join(dir, "appendonlydir", "appendonly.aof.1.incr.aof")
+	complete := int64(len(FormatCommand(&Command{Name: "SET", Args: []string{"kept", "1"}})))
+	info, _ := os.Stat(incrPath)
+	if err := os.Truncate(incrPath, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.AppendOnly = true
+	config.AOFLoadTruncated = false
+	refused := NewRadisa(dir, "dump.rdb", 0, config)
+	if refused.loadErr == nil {
+		t.Fatal("Expected truncated AOF to be refused")
+	}
+
+	loaded := newAOFTestServer(t, dir, DefaultConfig())
+	if loaded.data["kept"].value != "1" {
+		t.Errorf("Expected kept=1, got %v", loaded.data)
+	}
+	if _, ok := loaded.data["lost"]; ok {
+		t.Errorf("Expected the cut-off SET to be dropped")
+	}
+
+	info, _ = os.Stat(incrPath)
+	if info.Size() != complete {
+		t.Errorf("Expected file truncated to %d bytes, got %d", complete, info.Size())
+	}
+
+	// New writes continue after the last complete command
+	loaded.executeCommand(&Command{Name: "SET", Args: []string{"after", "3"}})
+	again := newAOFTestServer(t, dir, DefaultConfig())
+	if again.data["after"].value != "3" || again.data["kept"].value != "1" {
+		t.Errorf("Expected kept and after, got %v", again.data)
+	}
+}
+
+func TestAOF_BaseAsCommandLog(t *testing.T) {
+	dir := t.TempDir()
+	aofDir := filepath.Join(dir, "appendonlydir")
+	os.MkdirAll(aofDir, 0755)
+	os.WriteFile(filepath.Join(aofDir, "appendonly.aof.1.base.aof"),
+		FormatCommand(&Command{Name: "SET", Args: []string{"k", "v"}}), 0644)
+	os.WriteFile(filepath.Join(aofDir, "appendonly.aof.manifest"),
+		[]byte("file appendonly.aof.1.base.aof seq 1 type b\n"), 0644)
+
+	r := newAOFTestServer(t, dir, DefaultConfig())
+	if r.data["k"].value != "v" {
+		t.Errorf("Expected k=v, got %v", r.data)
+	}
+	if len(r.aof.manifest.incrs) != 1 {
+		t.Errorf("Expected an incr file to be added, got %+v", r.aof.manifest)
+	}
+}
+
+func TestAOF_WriteErrorRefusesWrites(t *testing.T) {
+	dir := t.TempDir()
+	r := newAOFTestServer(t, dir, DefaultConfig())
+
+	// A read-only handle makes every append fail
+	r.aof.file.Close()
+	file, err := os.Open(filepath.Join(dir, "appendonlydir", "appendonly.aof.1.incr.aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.aof.file = file
+
+	r.executeCommand(&Command{Name: "SET", Args: []string{"a", "1"}})
+	response := string(r.executeCommand(&Command{Name: "SET", Args: []string{"b", "2"}}))
+	if len(response) < 9 || response[:9] != "-MISCONF " {
+		t.Errorf("Expected MISCONF error, got %q", response)
+	}
+	if string(r.executeCommand(&Command{Name: "GET", Args: []string{"a"}})) != "$1\r\n1\r\n" {
+		t.Errorf("Expected reads to keep working")
+	}
+}
+
+func TestAOF_RewriteDropsFailedWrites(t *testing.T) {
+	dir := t.TempDir()
+	r := newAOFTestServer(t, dir, DefaultConfig())
+	r.executeCommand(&Command{Name: "INCR", Args: []string{"n"}})
+
+	// The second INCR is applied but can't be written, and stays buffered
+	r.aof.file.Close()
+	file, err := os.Open(filepath.Join(dir, "appendonlydir", "appendonly.aof.1.incr.aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.aof.file = file
+	r.executeCommand(&Command{Name: "INCR", Args: []string{"n"}})
+
+	r.mu.Lock()
+	err = r.bgrewriteaof()
+	r.mu.Unlock()
+	if err != nil {
+		t.Fatalf("Expected rewrite to start, got: %v", err)
+	}
+	waitFor(t, "the rewrite to finish", func() bool {
+		r.aof.mu.Lock()
+		defer r.aof.mu.Unlock()
+		return !r.aof.rewriting
+	})
+	if got := string(r.executeCommand(&Command{Name: "INCR", Args: []string{"n"}})); got != ":3\r\n" {
+		t.Fatalf("Expected :3, got %q", got)
+	}
+
+	restarted := newAOFTestServer(t, dir, DefaultConfig())
+	if restarted.data["n"].value != "3" {
+		t.Errorf("Expected the counter to be 3, got %q", restarted.data["n"].value)
+	}
+}
+
+func TestAOF_RewriteKeepsWritesDuringRewrite(t *testing.T) {
+	for _, preamble := range []bool{true, false} {
+		dir := t.TempDir()
+		config := DefaultConfig()
+		config.AOFUseRDBPreamble = preamble
+		r := newAOFTestServer(t, dir, config)
+
+		for i := 0; i < 10; i++ {
+			r.executeCommand(&Command{Name: "SET", Args: []string{"counter", strconv.Itoa(i)}})
+		}
+
+		// Run the rewrite's two halves by hand so a write lands in between
+		r.mu.Lock()
+		if err := r.aof.beginRewrite(); err != nil {
+			t.Fatalf("Expected rewrite to start, got: %v", err)
+		}
+		snap := r.snapshot()
+		r.mu.Unlock()
+		r.executeCommand(&Command{Name: "SET", Args: []string{"during", "yes"}})
+		if err := r.aof.finishRewrite(snap.copy(r), preamble, true); err != nil {
+			t.Fatalf("Expected rewrite to finish, got: %v", err)
+		}
+		r.executeCommand(&Command{Name: "SET", Args: []string{"after", "yes"}})
+
+		baseName := "appendonly.aof.2.base.aof"
+		if preamble {
+			baseName = "appendonly.aof.2.base.rdb"
+		}
+		expected := "file " + baseName + " seq 2 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n"
+		manifest, _ := os.ReadFile(filepath.Join(dir, "appendonlydir", "appendonly.aof.manifest"))
+		if string(manifest) != expected {
+			t.Errorf("Expected %q, got %q", expected, manifest)
+		}
+
+		entries, _ := os.ReadDir(filepath.Join(dir, "appendonlydir"))
+		if len(entries) != 3 {
+			t.Errorf("Expected old files to be deleted, got %v", entries)
+		}
+
+		restarted := newAOFTestServer(t, dir, config)
+		for key, value := range map[string]string{"counter": "9", "during": "yes", "after": "yes"} {
+			if restarted.data[key].value != value {
+				t.Errorf("Expected %s=%s (preamble %v), got %q", key, value, preamble, restarted.data[key].value)
+			}
+		}
+	}
+}
+
+func TestAOF_RewriteInProgress(t *testing.T) {
+	r := newAOFTestServer(t, t.TempDir(), DefaultConfig())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.aof.beginRewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.bgrewriteaof(); err != errRewriteInProgress {
+		t.Errorf("Expected %v, got %v", errRewriteInProgress, err)
+	}
+}
+
+func TestAOF_ShouldRewrite(t *testing.T) {
+	a := &aof{size: 150, baseSize: 100}
+	if a.shouldRewrite(100, 0) {
+		t.Errorf("Expected no rewrite at 50%% growth")
+	}
+	a.size = 200
+	if !a.shouldRewrite(100, 0) {
+		t.Errorf("Expected a rewrite at 100%% growth")
+	}
+	if a.shouldRewrite(100, 1000) {
+		t.Errorf("Expected no rewrite below the minimum size")
+	}
+	if a.shouldRewrite(0, 0) {
+		t.Errorf("Expected 0%% to disable automatic rewrites")
+	}
+}
@@ -0,0 +1,44 @@
+package radisa
+
+// authRequired reports whether c has to authenticate before anything else.
+func (r *Radisa) authRequired(c *client) bool {
+	return !c.authenticated
+}
+
+// auth implements AUTH [username] password. A password alone is for the
+// default user.
+func (r *Radisa) auth(c *client, args []string) []byte {
+	switch len(args) {
+	case 1:
+		r.mu.Lock()
+		nopass := r.aclUsers()["default"].nopass
+		r.mu.Unlock()
+		if nopass {
+			return FormatError("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+		args = []string{"default", args[0]}
+	case 2:
+	default:
+		return FormatError("wrong number of arguments for 'auth' command")
+	}
+	if reply := r.authenticate(c, args[0], args[1]); reply != nil {
+		return reply
+	}
+	return FormatSimpleString("OK")
+}
+
+// authenticate logs c in as username, for AUTH and HELLO. It returns the
+// error reply if that failed, which is recorded in the ACL LOG too.
+func (r *Radisa) authenticate(c *client, username, password string) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u := r.aclUsers()[username]
+	if u == nil || !u.enabled || !u.checkPassword(password) {
+		r.addACLLogEntry(c, "auth", "toplevel", "AUTH", username)
+		return FormatErrorCode("WRONGPASS", "invalid username-password pair or user is disabled.")
+	}
+	c.user = u
+	c.authenticated = true
+	return nil
+}
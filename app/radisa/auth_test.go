@@ -0,0 +1,82 @@
+package radisa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAuth_RequirePass(t *testing.T) {
+	r := createTestServer()
+	r.config.RequirePass = "s3cret"
+	port := serveForTest(t, r)
+	conn, reader := dialForTest(t, port)
+
+	for _, args := range [][]string{{"GET", "k"}, {"PING"}, {"CLIENT", "ID"}} {
+		if got := commandForTest(t, conn, reader, args...); got != "-NOAUTH Authentication required.\r\n" {
+			t.Errorf("Expected NOAUTH for %v, got %q", args, got)
+		}
+	}
+	for _, args := range [][]string{{"AUTH", "wrong"}, {"AUTH", "default", "wrong"}, {"AUTH", "admin", "s3cret"}} {
+		if got := commandForTest(t, conn, reader, args...); got != "-WRONGPASS invalid username-password pair or user is disabled.\r\n" {
+			t.Errorf("Expected WRONGPASS for %v, got %q", args, got)
+		}
+	}
+	if got := commandForTest(t, conn, reader, "AUTH", "s3cret"); got != "+OK\r\n" {
+		t.Errorf("Expected OK, got %q", got)
+	}
+	if got := commandForTest(t, conn, reader, "GET", "k"); got != "$-1\r\n" {
+		t.Errorf("Expected to be authenticated, got %q", got)
+	}
+
+	// HELLO can authenticate in the same step
+	conn, reader = dialForTest(t, port)
+	if got := commandForTest(t, conn, reader, "HELLO", "3"); !strings.HasPrefix(got, "-NOAUTH HELLO must be called") {
+		t.Errorf("Expected NOAUTH, got %q", got)
+	}
+	if got := commandForTest(t, conn, reader, "HELLO", "3", "AUTH", "default", "s3cret"); !strings.HasPrefix(got, "%7\r\n") {
+		t.Errorf("Expected a RESP3 map, got %q", got)
+	}
+	if got := commandForTest(t, conn, reader, "PING"); got != "+PONG\r\n" {
+		t.Errorf("Expected PONG, got %q", got)
+	}
+}
+
+func TestAuth_WithoutRequirePass(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	if got := commandForTest(t, conn, reader, "AUTH", "anything"); !strings.HasPrefix(got, "-ERR AUTH <password> called without any password configured") {
+		t.Errorf("Expected an error, got %q", got)
+	}
+	if got := commandForTest(t, conn, reader, "AUTH", "default", "anything"); got != "+OK\r\n" {
+		t.Errorf("Expected the default user to take any password, got %q", got)
+	}
+}
+
+func TestACLUser_CheckPassword(t *testing.T) {
+	u := newDefaultUser("s3cret")
+	if !u.checkPassword("s3cret") {
+		t.Errorf("Expected the password to match")
+	}
+	for _, other := range []string{"", "s3cre", "s3cret!", "S3cret"} {
+		if u.checkPassword(other) {
+			t.Errorf("Expected %q not to match", other)
+		}
+	}
+}
+
+func TestReplica_MasterAuth(t *testing.T) {
+	config := DefaultConfig()
+	config.RequirePass = "s3cret"
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, config)
+	master.executeCommand(&Command{Name: "SET", Args: []string{"k", "v"}})
+	port := serveForTest(t, master)
+
+	config = DefaultConfig()
+	config.MasterAuth = "s3cret"
+	replica := startTestReplicaWithConfig(t, port, config)
+	waitFor(t, "authenticated sync", func() bool {
+		value, _ := replicaValue(replica, "k")
+		return value == "v"
+	})
+}
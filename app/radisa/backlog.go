@@ -0,0 +1,47 @@
+package radisa
+
+// replBacklog is a circular buffer holding the tail of the replication
+// stream, so a replica that reconnects can be sent just the bytes it missed.
+// Offsets count like redis' do: offset is the replication offset of the
+// oldest byte kept, so offset+histlen is one past the last byte written.
+type replBacklog struct {
+	buf     []byte
+	idx     int // where the next byte goes
+	histlen int
+	offset  int64
+}
+
+// newReplBacklog starts an empty backlog whose first byte will be at offset.
+func newReplBacklog(size int, offset int64) *replBacklog {
+	return &replBacklog{buf: make([]byte, max(size, 1)), offset: offset}
+}
+
+func (b *replBacklog) write(p []byte) {
+	for len(p) > 0 {
+		n := copy(b.buf[b.idx:], p)
+		b.idx = (b.idx + n) % len(b.buf)
+		b.histlen += n
+		p = p[n:]
+	}
+
+	if b.histlen > len(b.buf) {
+		b.offset += int64(b.histlen - len(b.buf))
+		b.histlen = len(b.buf)
+	}
+}
+
+// readFrom returns the bytes from offset to the end of the stream, false if
+// offset isn't in the backlog anymore (or not yet).
+func (b *replBacklog) readFrom(offset int64) ([]byte, bool) {
+	end := b.offset + int64(b.histlen)
+	if offset < b.offset || offset > end {
+		return nil, false
+	}
+
+	skip := int(offset - b.offset)
+	out := make([]byte, b.histlen-skip)
+	start := (b.idx - b.histlen + skip + len(b.buf)) % len(b.buf)
+	n := copy(out, b.buf[start:])
+	copy(out[n:], b.buf)
+	return out, true
+}
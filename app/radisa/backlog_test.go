@@ -0,0 +1,47 @@
+package radisa
+
+import "testing"
+
+func TestReplBacklog_ReadFrom(t *testing.T) {
+	b := newReplBacklog(8, 1)
+	b.write([]byte("abcde"))
+
+	if got, ok := b.readFrom(1); !ok || string(got) != "abcde" {
+		t.Errorf("Expected abcde, got %q (%v)", got, ok)
+	}
+	if got, ok := b.readFrom(4); !ok || string(got) != "de" {
+		t.Errorf("Expected de, got %q (%v)", got, ok)
+	}
+	if got, ok := b.readFrom(6); !ok || len(got) != 0 {
+		t.Errorf("Expected nothing missing, got %q (%v)", got, ok)
+	}
+	if _, ok := b.readFrom(7); ok {
+		t.Errorf("Expected an offset past the end to be refused")
+	}
+}
+
+func TestReplBacklog_Wraps(t *testing.T) {
+	b := newReplBacklog(8, 1)
+	b.write([]byte("abcde"))
+	b.write([]byte("fghij"))
+
+	// a and b were overwritten
+	if b.offset != 3 || b.histlen != 8 {
+		t.Errorf("Expected offset 3 and histlen 8, got %d and %d", b.offset, b.histlen)
+	}
+	if _, ok := b.readFrom(2); ok {
+		t.Errorf("Expected an overwritten offset to be refused")
+	}
+	if got, ok := b.readFrom(3); !ok || string(got) != "cdefghij" {
+		t.Errorf("Expected cdefghij, got %q (%v)", got, ok)
+	}
+	if got, ok := b.readFrom(9); !ok || string(got) != "ij" {
+		t.Errorf("Expected ij, got %q (%v)", got, ok)
+	}
+
+	// A write larger than the whole backlog keeps its tail
+	b.write([]byte("0123456789"))
+	if got, ok := b.readFrom(b.offset); !ok || string(got) != "23456789" || b.offset != 13 {
+		t.Errorf("Expected 23456789 at 13, got %q at %d", got, b.offset)
+	}
+}
@@ -0,0 +1,359 @@
+package radisa
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// client is the per-connection state handleConnection keeps between commands.
+type client struct {
+	conn net.Conn
+	// id is what CLIENT ID reports, unique for the life of the server.
+	id int64
+	// resp is the protocol version picked with HELLO, 2 or 3. It is set
+	// under Radisa.mu.
+	resp int
+	// user is the ACL user c is logged in as and authenticated is set once
+	// it logged in, see authRequired. Both are set under Radisa.mu.
+	user          *aclUser
+	authenticated bool
+	// closeAfterReply closes the connection once the current reply is
+	// written.
+	closeAfterReply bool
+	// listeningPort is what a replica announced with REPLCONF listening-port.
+	listeningPort int
+	// capaPSync2 is set by REPLCONF capa psync2.
+	capaPSync2 bool
+	// woff is the replication offset after this client's last write, what
+	// WAIT waits for.
+	woff int64
+	// isReplica is set once the connection turned into a replication stream.
+	isReplica bool
+	// inMulti is set between MULTI and EXEC or DISCARD, multiQueue holds the
+	// commands to run then. multiFailed is set when one of them was refused
+	// while queuing, so EXEC aborts the whole transaction.
+	inMulti     bool
+	multiQueue  []*Command
+	multiFailed bool
+	// watchedKeys are the keys this client WATCHes and watchDirty is set
+	// once one of them changed. Both are guarded by Radisa.mu.
+	watchedKeys []watchedKey
+	watchDirty  bool
+	// channels, patterns and shardChannels are what this client is
+	// subscribed to.
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
+	// out is set once the client can get messages it didn't ask for, from
+	// then on every reply is queued through it. Only the connection's own
+	// goroutine sets it, under Radisa.mu. Only what the client didn't ask
+	// for is limited, see updateOutputLimit.
+	out *clientOutput
+
+	// tracking holds the CLIENT TRACKING options while it is on, guarded
+	// by Radisa.mu. trackingCaching is what CLIENT CACHING said about the
+	// next command.
+	tracking        *trackingOptions
+	trackingCaching bool
+	// pendingPushes are invalidations caused by this client's own command,
+	// sent after its reply.
+	pendingPushes [][]byte
+}
+
+func newClient(conn net.Conn) *client {
+	return &client{conn: conn, resp: 2}
+}
+
+// ensureOutput gives c an output buffer, from then on it can be sent data
+// it didn't ask for. The caller holds mu.
+func (c *client) ensureOutput() {
+	if c.out == nil {
+		c.out = newClientOutput(c.conn, 0)
+		c.out.start()
+	}
+}
+
+// updateOutputLimit applies the pub/sub output limit to the messages and
+// pushes c gets while it has subscriptions or speaks RESP3. Replies to its
+// commands are never limited, and without either c is a normal client
+// again, which has no limit, like in redis. The caller holds mu.
+func (c *client) updateOutputLimit() {
+	if c.out == nil {
+		return
+	}
+	limit := 0
+	if c.inSubscribeMode() || c.resp == 3 {
+		limit = pubsubOutputLimit
+	}
+	c.out.setLimit(limit)
+}
+
+// linkClient gives c its ID and makes it reachable through it.
+func (r *Radisa) linkClient(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clients == nil {
+		r.clients = make(map[int64]*client)
+	}
+	r.nextClientID++
+	c.id = r.nextClientID
+	r.clients[c.id] = c
+
+	// Without a password for the default user there is nothing to log in to
+	c.user = r.aclUsers()["default"]
+	c.authenticated = c.user.enabled && c.user.nopass
+}
+
+// serverVersion is the redis version HELLO reports. Client libraries pick
+// the features they use by it.
+const serverVersion = "7.2.0"
+
+// hello implements HELLO [protover [AUTH username password]]. It switches
+// c to the protocol version asked for and describes the server, as a map on
+// RESP3.
+func (r *Radisa) hello(c *client, cmd *Command) []byte {
+	resp := c.resp
+	if len(cmd.Args) > 0 {
+		switch cmd.Args[0] {
+		case "2", "3":
+			resp, _ = strconv.Atoi(cmd.Args[0])
+		default:
+			if _, err := strconv.Atoi(cmd.Args[0]); err != nil {
+				return FormatError("Protocol version is not an integer or out of range")
+			}
+			return FormatErrorCode("NOPROTO", "unsupported protocol version")
+		}
+	}
+
+	for i := 1; i < len(cmd.Args); i++ {
+		if !strings.EqualFold(cmd.Args[i], "AUTH") || i+2 >= len(cmd.Args) {
+			return FormatError("Syntax error in HELLO option '" + cmd.Args[i] + "'")
+		}
+		if reply := r.authenticate(c, cmd.Args[i+1], cmd.Args[i+2]); reply != nil {
+			return reply
+		}
+		i += 2
+	}
+	if r.authRequired(c) {
+		return FormatErrorCode("NOAUTH", "HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c.resp = resp
+	if resp == 3 {
+		// Pushes may arrive between any two replies
+		c.ensureOutput()
+	}
+	c.updateOutputLimit()
+
+	role := "master"
+	if r.replicaOf != nil {
+		role = "replica"
+	}
+	pairs := [][]byte{
+		FormatBulk("server"), FormatBulk("redis"),
+		FormatBulk("version"), FormatBulk(serverVersion),
+		FormatBulk("proto"), FormatInteger(int64(c.resp)),
+		FormatBulk("id"), FormatInteger(c.id),
+		FormatBulk("mode"), FormatBulk("standalone"),
+		FormatBulk("role"), FormatBulk(role),
+		FormatBulk("modules"), FormatArray(nil),
+	}
+	if c.resp == 3 {
+		return FormatMap(pairs)
+	}
+	return FormatReplies(pairs)
+}
+
+// clientCommand implements the CLIENT subcommands about the connection
+// itself.
+func (r *Radisa) clientCommand(c *client, cmd *Command) []byte {
+	if len(cmd.Args) == 0 {
+		return FormatError("wrong number of arguments for 'client' command")
+	}
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "ID":
+		return FormatInteger(c.id)
+	case "TRACKING":
+		return r.clientTracking(c, cmd.Args[1:])
+	case "CACHING":
+		return r.clientCaching(c, cmd.Args[1:])
+	case "GETREDIR":
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		if c.tracking == nil {
+			return FormatInteger(-1)
+		}
+		return FormatInteger(c.tracking.redirect)
+	default:
+		return FormatError("unknown subcommand '" + cmd.Args[0] + "'. Try CLIENT HELP.")
+	}
+}
+
+// write sends a reply, through out once the client has one.
+func (c *client) write(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	if c.out != nil {
+		c.out.writeReply(b)
+		return
+	}
+	c.conn.Write(b)
+}
+
+// push sends data c didn't ask for, which counts against its output limit.
+func (c *client) push(b []byte) {
+	if c.out != nil {
+		c.out.write(b)
+	}
+}
+
+// clientOutput queues bytes for a connection and writes them from its own
+// goroutine, so a slow reader never blocks whoever produces the data. Once
+// more than limit bytes are waiting the connection is closed, like
+// client-output-buffer-limit. A limit of 0 means none.
+type clientOutput struct {
+	conn  net.Conn
+	limit int
+
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+	// closing closes the connection once buf is written
+	closing bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// newClientOutput returns a buffer that collects writes until start is
+// called.
+func newClientOutput(conn net.Conn, limit int) *clientOutput {
+	return &clientOutput{
+		conn:  conn,
+		limit: limit,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+}
+
+func (o *clientOutput) start() {
+	go o.run()
+}
+
+// write queues b and reports false if the connection is gone or was just
+// closed for going over the limit.
+func (o *clientOutput) write(b []byte) bool {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return false
+	}
+	if o.limit > 0 && len(o.buf)+len(b) > o.limit {
+		o.mu.Unlock()
+		o.close()
+		return false
+	}
+	o.buf = append(o.buf, b...)
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// writeReply queues b like write, but never over the limit: a reply was
+// asked for, and is as big as the client asked it to be.
+func (o *clientOutput) writeReply(b []byte) {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return
+	}
+	o.buf = append(o.buf, b...)
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// setLimit changes the limit, for the writes to come.
+func (o *clientOutput) setLimit(limit int) {
+	o.mu.Lock()
+	o.limit = limit
+	o.mu.Unlock()
+}
+
+// pending is the number of bytes not written yet.
+func (o *clientOutput) pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.buf)
+}
+
+// closeWhenFlushed closes the connection once what is queued was written,
+// and waits for that.
+func (o *clientOutput) closeWhenFlushed() {
+	o.mu.Lock()
+	o.closing = true
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	<-o.done
+}
+
+// close drops whatever is still queued and closes the connection.
+func (o *clientOutput) close() {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return
+	}
+	o.closed = true
+	o.buf = nil
+	o.mu.Unlock()
+
+	close(o.done)
+	o.conn.Close()
+}
+
+func (o *clientOutput) run() {
+	for {
+		select {
+		case <-o.wake:
+		case <-o.done:
+			return
+		}
+
+		o.mu.Lock()
+		out := o.buf
+		o.buf = nil
+		o.mu.Unlock()
+
+		if len(out) > 0 {
+			if _, err := o.conn.Write(out); err != nil {
+				o.close()
+				return
+			}
+		}
+
+		o.mu.Lock()
+		finished := o.closing && len(o.buf) == 0
+		o.mu.Unlock()
+		if finished {
+			o.close()
+			return
+		}
+	}
+}
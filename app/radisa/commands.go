@@ -0,0 +1,628 @@
+package radisa
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Command flags, named in COMMAND INFO like redis names them.
+const (
+	// cmdWrite commands change the keyspace
+	cmdWrite = 1 << iota
+	// cmdReadonly commands only read keys
+	cmdReadonly
+	// cmdDenyOOM commands may grow memory usage
+	cmdDenyOOM
+	cmdAdmin
+	cmdPubsub
+	cmdNoscript
+	// cmdLoading commands may run while the dataset loads
+	cmdLoading
+	// cmdStale commands still run on a replica whose master link is down
+	// when replica-serve-stale-data is off
+	cmdStale
+	cmdFast
+	// cmdNoAuth commands may run before the client authenticated
+	cmdNoAuth
+	// cmdNoMulti commands can't be queued in a transaction
+	cmdNoMulti
+)
+
+var commandFlagNames = []struct {
+	flag int
+	name string
+}{
+	{cmdWrite, "write"},
+	{cmdReadonly, "readonly"},
+	{cmdDenyOOM, "denyoom"},
+	{cmdAdmin, "admin"},
+	{cmdPubsub, "pubsub"},
+	{cmdNoscript, "noscript"},
+	{cmdLoading, "loading"},
+	{cmdStale, "stale"},
+	{cmdFast, "fast"},
+	{cmdNoAuth, "no_auth"},
+	{cmdNoMulti, "no_multi"},
+}
+
+// Key spec flags, how a command uses its keys. The first four say what
+// happens to the value, the others to the data in it, like in redis.
+const (
+	keyRO = 1 << iota
+	keyRW
+	keyOW
+	keyRM
+	keyAccess
+	keyUpdate
+	keyInsert
+	keyDelete
+)
+
+var keySpecFlagNames = []struct {
+	flag int
+	name string
+}{
+	{keyRO, "RO"},
+	{keyRW, "RW"},
+	{keyOW, "OW"},
+	{keyRM, "RM"},
+	{keyAccess, "access"},
+	{keyUpdate, "update"},
+	{keyInsert, "insert"},
+	{keyDelete, "delete"},
+}
+
+// keySpec tells where a command's keys are, like redis' first key, last
+// key and step: positions count the command name as 0 and a negative last
+// counts from the end. first is 0 for commands without keys.
+type keySpec struct {
+	first, last, step int
+	flags             int
+}
+
+// keys returns the keys of cmd according to the spec.
+func (ks keySpec) keys(cmd *Command) []string {
+	if ks.first == 0 {
+		return nil
+	}
+	last := ks.last
+	if last < 0 {
+		last += len(cmd.Args) + 1
+	}
+	var keys []string
+	for i := ks.first; i <= last && i <= len(cmd.Args); i += ks.step {
+		keys = append(keys, cmd.Args[i-1])
+	}
+	return keys
+}
+
+// commandSpec is a command's entry in the command table: how it is called,
+// what it touches and the function that runs it.
+type commandSpec struct {
+	// name is lowercase, parent|sub for a subcommand
+	name string
+	// arity counts the command name, negative means at least that many.
+	// A subcommand's counts its parent's name too.
+	arity      int
+	flags      int
+	keys       keySpec
+	categories []string
+	summary    string
+	since      string
+	group      string
+	// proc runs the command with mu held. Commands that act on the
+	// connection have connProc instead, which locks what it needs itself,
+	// and the few the connection loop handles on its own have neither.
+	proc        func(r *Radisa, cmd *Command) []byte
+	connProc    func(r *Radisa, c *client, cmd *Command) []byte
+	subcommands []*commandSpec
+}
+
+// commandTable holds every command by its uppercase name. It is filled in
+// by init, since COMMAND is served from it.
+var commandTable map[string]*commandSpec
+
+// commandList is every command and subcommand, sorted by name.
+var commandList []*commandSpec
+
+func init() {
+	specs := []*commandSpec{
+		{name: "ping", arity: -1, flags: cmdFast | cmdStale, categories: []string{"connection"},
+			summary: "Returns the server's liveliness response.", since: "1.0.0", group: "connection",
+			proc: (*Radisa).pingCommand},
+		{name: "echo", arity: 2, flags: cmdFast, categories: []string{"connection"},
+			summary: "Returns the given string.", since: "1.0.0", group: "connection",
+			proc: (*Radisa).echoCommand},
+		{name: "set", arity: -3, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, 1, 1, keyOW | keyUpdate}, categories: []string{"string"},
+			summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", since: "1.0.0", group: "string",
+			proc: (*Radisa).setCommand},
+		{name: "get", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1, keyRO | keyAccess}, categories: []string{"string"},
+			summary: "Returns the string value of a key.", since: "1.0.0", group: "string",
+			proc: (*Radisa).getCommand},
+		{name: "del", arity: -2, flags: cmdWrite, keys: keySpec{1, -1, 1, keyRM | keyDelete}, categories: []string{"keyspace"},
+			summary: "Deletes one or more keys.", since: "1.0.0", group: "generic",
+			proc: (*Radisa).delCommand},
+		{name: "expire", arity: 3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1, keyRW | keyUpdate}, categories: []string{"keyspace"},
+			summary: "Sets the expiration time of a key in seconds.", since: "1.0.0", group: "generic",
+			proc: (*Radisa).expireCommand},
+		{name: "pexpireat", arity: 3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1, keyRW | keyUpdate}, categories: []string{"keyspace"},
+			summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.", since: "2.6.0", group: "generic",
+			proc: (*Radisa).expireCommand},
+		{name: "incr", arity: 2, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1, keyRW | keyAccess | keyUpdate}, categories: []string{"string"},
+			summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", since: "1.0.0", group: "string",
+			proc: (*Radisa).incrBy},
+		{name: "decr", arity: 2, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1, keyRW | keyAccess | keyUpdate}, categories: []string{"string"},
+			summary: "Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", since: "1.0.0", group: "string",
+			proc: (*Radisa).incrBy},
+		{name: "incrby", arity: 3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1, keyRW | keyAccess | keyUpdate}, categories: []string{"string"},
+			summary: "Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.", since: "1.0.0", group: "string",
+			proc: (*Radisa).incrBy},
+		{name: "decrby", arity: 3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1, keyRW | keyAccess | keyUpdate}, categories: []string{"string"},
+			summary: "Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.", since: "1.0.0", group: "string",
+			proc: (*Radisa).incrBy},
+		{name: "config", arity: -2,
+			summary: "A container for server configuration commands.", since: "2.0.0", group: "server",
+			proc: (*Radisa).configCommand,
+			subcommands: []*commandSpec{
+				{name: "get", arity: -3, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Returns the effective values of configuration parameters.", since: "2.0.0", group: "server"},
+			}},
+		{name: "keys", arity: 2, flags: cmdReadonly, categories: []string{"keyspace", "dangerous"},
+			summary: "Returns all key names that match a pattern.", since: "1.0.0", group: "generic",
+			proc: (*Radisa).keysCommand},
+		{name: "save", arity: 1, flags: cmdAdmin | cmdNoscript | cmdNoMulti,
+			summary: "Synchronously saves the database(s) to disk.", since: "1.0.0", group: "server",
+			proc: (*Radisa).saveCommand},
+		{name: "bgsave", arity: -1, flags: cmdAdmin | cmdNoscript,
+			summary: "Asynchronously saves the database(s) to disk.", since: "1.0.0", group: "server",
+			proc: (*Radisa).bgsaveCommand},
+		{name: "bgrewriteaof", arity: 1, flags: cmdAdmin | cmdNoscript,
+			summary: "Asynchronously rewrites the append-only file to disk.", since: "1.0.0", group: "server",
+			proc: (*Radisa).bgrewriteaofCommand},
+		{name: "select", arity: 2, flags: cmdLoading | cmdStale | cmdFast, categories: []string{"connection"},
+			summary: "Changes the selected database.", since: "1.0.0", group: "connection",
+			proc: (*Radisa).selectCommand},
+		{name: "lastsave", arity: 1, flags: cmdLoading | cmdStale | cmdFast, categories: []string{"admin", "dangerous"},
+			summary: "Returns the Unix timestamp of the last successful save to disk.", since: "1.0.0", group: "server",
+			proc: (*Radisa).lastsaveCommand},
+		{name: "replicaof", arity: 3, flags: cmdAdmin | cmdNoscript | cmdStale,
+			summary: "Configures a server as replica of another, or promotes it to a master.", since: "5.0.0", group: "server",
+			proc: func(r *Radisa, cmd *Command) []byte { return r.replicaof(cmd.Args) }},
+		{name: "slaveof", arity: 3, flags: cmdAdmin | cmdNoscript | cmdStale,
+			summary: "Sets a Redis server as a replica of another, or promotes it to being a master.", since: "1.0.0", group: "server",
+			proc: func(r *Radisa, cmd *Command) []byte { return r.replicaof(cmd.Args) }},
+		{name: "info", arity: -1, flags: cmdLoading | cmdStale, categories: []string{"dangerous"},
+			summary: "Returns information and statistics about the server.", since: "1.0.0", group: "server",
+			proc: (*Radisa).infoCommand},
+		{name: "replconf", arity: -1, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale | cmdNoMulti,
+			summary: "An internal command for configuring the replication stream.", since: "3.0.0", group: "server",
+			connProc: (*Radisa).replconf},
+		{name: "psync", arity: -3, flags: cmdAdmin | cmdNoscript | cmdNoMulti,
+			summary: "An internal command used in replication.", since: "2.8.0", group: "server"},
+		{name: "wait", arity: 3, flags: cmdNoscript | cmdNoMulti, categories: []string{"connection"},
+			summary: "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.", since: "3.0.0", group: "generic",
+			connProc: (*Radisa).wait},
+		{name: "multi", arity: 1, flags: cmdNoscript | cmdLoading | cmdStale | cmdFast, categories: []string{"transaction"},
+			summary: "Starts a transaction.", since: "1.2.0", group: "transactions",
+			connProc: func(r *Radisa, c *client, cmd *Command) []byte { return c.multi() }},
+		{name: "exec", arity: 1, flags: cmdNoscript | cmdLoading | cmdStale, categories: []string{"transaction"},
+			summary: "Executes all commands in a transaction.", since: "1.2.0", group: "transactions",
+			connProc: func(r *Radisa, c *client, cmd *Command) []byte { return r.exec(c) }},
+		{name: "discard", arity: 1, flags: cmdNoscript | cmdLoading | cmdStale | cmdFast, categories: []string{"transaction"},
+			summary: "Discards a transaction.", since: "2.0.0", group: "transactions",
+			connProc: func(r *Radisa, c *client, cmd *Command) []byte { return r.discard(c) }},
+		{name: "watch", arity: -2, flags: cmdNoscript | cmdLoading | cmdStale | cmdFast, keys: keySpec{1, -1, 1, keyRO}, categories: []string{"transaction"},
+			summary: "Monitors changes to keys to determine the execution of a transaction.", since: "2.2.0", group: "transactions",
+			connProc: (*Radisa).watch},
+		{name: "unwatch", arity: 1, flags: cmdNoscript | cmdLoading | cmdStale | cmdFast, categories: []string{"transaction"},
+			summary: "Forgets about watched keys of a transaction.", since: "2.2.0", group: "transactions",
+			connProc: func(r *Radisa, c *client, cmd *Command) []byte { return r.unwatch(c) }},
+		// Subscribing turns the connection into a stream of messages, so
+		// none of these can be queued
+		{name: "subscribe", arity: -2, flags: cmdPubsub | cmdNoscript | cmdLoading | cmdStale | cmdNoMulti,
+			summary: "Listens for messages published to channels.", since: "2.0.0", group: "pubsub",
+			connProc: func(r *Radisa, c *client, cmd *Command) []byte { return r.subscribe(c, cmd, pubsubGlobal) }},
+		{name: "unsubscribe", arity: -1, flags: cmdPubsub | cmdNoscript | cmdLoading | cmdStale | cmdNoMulti,
+			summary: "Stops listening to messages posted to channels.", since: "2.0.0", group: "pubsub",
+			connProc: func(r *Radisa, c *client, cmd *Command) []byte { return r.unsubscribe(c, cmd, pubsubGlobal) }},
+		{name: "psubscribe", arity: -2, flags: cmdPubsub | cmdNoscript | cmdLoading | cmdStale | cmdNoMulti,
+			summary: "Listens for messages published to channels that match one or more patterns.", since: "2.0.0", group: "pubsub",
+			connProc: (*Radisa).psubscribe},
+		{name: "punsubscribe", arity: -1, flags: cmdPubsub | cmdNoscript | cmdLoading | cmdStale | cmdNoMulti,
+			summary: "Stops listening to messages published to channels that match one or more patterns.", since: "2.0.0", group: "pubsub",
+			connProc: (*Radisa).punsubscribe},
+		{name: "ssubscribe", arity: -2, flags: cmdPubsub | cmdNoscript | cmdLoading | cmdStale | cmdNoMulti,
+			summary: "Listens for messages published to shard channels.", since: "7.0.0", group: "pubsub",
+			connProc: func(r *Radisa, c *client, cmd *Command) []byte { return r.subscribe(c, cmd, pubsubShard) }},
+		{name: "sunsubscribe", arity: -1, flags: cmdPubsub | cmdNoscript | cmdLoading | cmdStale | cmdNoMulti,
+			summary: "Stops listening to messages posted to shard channels.", since: "7.0.0", group: "pubsub",
+			connProc: func(r *Radisa, c *client, cmd *Command) []byte { return r.unsubscribe(c, cmd, pubsubShard) }},
+		{name: "publish", arity: 3, flags: cmdPubsub | cmdLoading | cmdStale | cmdFast,
+			summary: "Posts a message to a channel.", since: "2.0.0", group: "pubsub",
+			proc: (*Radisa).publishCommand},
+		{name: "spublish", arity: 3, flags: cmdPubsub | cmdLoading | cmdStale | cmdFast,
+			summary: "Post a message to a shard channel", since: "7.0.0", group: "pubsub",
+			proc: (*Radisa).spublishCommand},
+		{name: "pubsub", arity: -2,
+			summary: "A container for Pub/Sub commands.", since: "2.8.0", group: "pubsub",
+			proc: func(r *Radisa, cmd *Command) []byte { return r.pubsub(cmd.Args) },
+			subcommands: []*commandSpec{
+				{name: "channels", arity: -2, flags: cmdPubsub | cmdLoading | cmdStale,
+					summary: "Returns the active channels.", since: "2.8.0", group: "pubsub"},
+				{name: "numsub", arity: -2, flags: cmdPubsub | cmdLoading | cmdStale,
+					summary: "Returns a count of subscribers to channels.", since: "2.8.0", group: "pubsub"},
+				{name: "numpat", arity: 2, flags: cmdPubsub | cmdLoading | cmdStale,
+					summary: "Returns a count of unique pattern subscriptions.", since: "2.8.0", group: "pubsub"},
+				{name: "shardchannels", arity: -2, flags: cmdPubsub | cmdLoading | cmdStale,
+					summary: "Returns the active shard channels.", since: "7.0.0", group: "pubsub"},
+				{name: "shardnumsub", arity: -2, flags: cmdPubsub | cmdLoading | cmdStale,
+					summary: "Returns the count of subscribers of shard channels.", since: "7.0.0", group: "pubsub"},
+			}},
+		{name: "quit", arity: -1, flags: cmdNoscript | cmdLoading | cmdStale | cmdFast | cmdNoAuth, categories: []string{"connection"},
+			summary: "Closes the connection.", since: "1.0.0", group: "connection"},
+		{name: "hello", arity: -1, flags: cmdNoscript | cmdLoading | cmdStale | cmdFast | cmdNoAuth | cmdNoMulti, categories: []string{"connection"},
+			summary: "Handshakes with the Redis server.", since: "6.0.0", group: "connection",
+			connProc: (*Radisa).hello},
+		{name: "auth", arity: -2, flags: cmdNoscript | cmdLoading | cmdStale | cmdFast | cmdNoAuth | cmdNoMulti, categories: []string{"connection"},
+			summary: "Authenticates the connection.", since: "1.0.0", group: "connection",
+			connProc: func(r *Radisa, c *client, cmd *Command) []byte { return r.auth(c, cmd.Args) }},
+		{name: "client", arity: -2, flags: cmdNoMulti,
+			summary: "A container for client connection commands.", since: "2.4.0", group: "connection",
+			connProc: (*Radisa).clientCommand,
+			subcommands: []*commandSpec{
+				{name: "id", arity: 2, flags: cmdNoscript | cmdLoading | cmdStale, categories: []string{"connection"},
+					summary: "Returns the unique client ID of the connection.", since: "5.0.0", group: "connection"},
+				{name: "tracking", arity: -3, flags: cmdNoscript | cmdLoading | cmdStale, categories: []string{"connection"},
+					summary: "Controls server-assisted client-side caching for the connection.", since: "6.0.0", group: "connection"},
+				{name: "caching", arity: 3, flags: cmdNoscript | cmdLoading | cmdStale, categories: []string{"connection"},
+					summary: "Instructs the server whether to track the keys in the next request.", since: "6.0.0", group: "connection"},
+				{name: "getredir", arity: 2, flags: cmdNoscript | cmdLoading | cmdStale, categories: []string{"connection"},
+					summary: "Returns the client ID to which the connection's tracking notifications are redirected.", since: "6.0.0", group: "connection"},
+			}},
+		{name: "acl", arity: -2, flags: cmdNoMulti,
+			summary: "A container for Access List Control commands.", since: "6.0.0", group: "server",
+			connProc: (*Radisa).aclCommand,
+			subcommands: []*commandSpec{
+				{name: "cat", arity: -2, flags: cmdNoscript | cmdLoading | cmdStale,
+					summary: "Lists the ACL categories, or the commands inside a category.", since: "6.0.0", group: "server"},
+				{name: "whoami", arity: 2, flags: cmdNoscript | cmdLoading | cmdStale,
+					summary: "Returns the authenticated username of the current connection.", since: "6.0.0", group: "server"},
+				{name: "dryrun", arity: -4, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Simulates the execution of a command by a user, without executing the command.", since: "7.0.0", group: "server"},
+				{name: "setuser", arity: -3, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Creates and modifies an ACL user and its rules.", since: "6.0.0", group: "server"},
+				{name: "getuser", arity: 3, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Lists the ACL rules of a user.", since: "6.0.0", group: "server"},
+				{name: "deluser", arity: -3, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Deletes ACL users, and terminates their connections.", since: "6.0.0", group: "server"},
+				{name: "list", arity: 2, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Dumps the effective rules in ACL file format.", since: "6.0.0", group: "server"},
+				{name: "users", arity: 2, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Lists all ACL users.", since: "6.0.0", group: "server"},
+				{name: "log", arity: -2, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Lists recent security events generated due to ACL rules.", since: "6.0.0", group: "server"},
+				{name: "save", arity: 2, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Saves the effective ACL rules in the configured ACL file.", since: "6.0.0", group: "server"},
+				{name: "load", arity: 2, flags: cmdAdmin | cmdNoscript | cmdLoading | cmdStale,
+					summary: "Reloads the rules from the configured ACL file.", since: "6.0.0", group: "server"},
+			}},
+		{name: "command", arity: -1, flags: cmdLoading | cmdStale, categories: []string{"connection"},
+			summary: "Returns detailed information about all commands.", since: "2.8.13", group: "server",
+			proc: (*Radisa).commandCommand,
+			subcommands: []*commandSpec{
+				{name: "count", arity: 2, flags: cmdLoading | cmdStale, categories: []string{"connection"},
+					summary: "Returns a count of commands.", since: "2.8.13", group: "server"},
+				{name: "info", arity: -2, flags: cmdLoading | cmdStale, categories: []string{"connection"},
+					summary: "Returns information about one, multiple or all commands.", since: "2.8.13", group: "server"},
+				{name: "docs", arity: -2, flags: cmdLoading | cmdStale, categories: []string{"connection"},
+					summary: "Returns documentary information about one, multiple or all commands.", since: "7.0.0", group: "server"},
+				{name: "getkeys", arity: -3, flags: cmdLoading | cmdStale, categories: []string{"connection"},
+					summary: "Extracts the key names from an arbitrary command.", since: "2.8.13", group: "server"},
+				{name: "list", arity: -2, flags: cmdLoading | cmdStale, categories: []string{"connection"},
+					summary: "Returns a list of command names.", since: "7.0.0", group: "server"},
+			}},
+	}
+
+	commandTable = make(map[string]*commandSpec, len(specs))
+	for _, spec := range specs {
+		commandTable[strings.ToUpper(spec.name)] = spec
+		commandList = append(commandList, spec)
+		for _, sub := range spec.subcommands {
+			sub.name = spec.name + "|" + sub.name
+			commandList = append(commandList, sub)
+		}
+	}
+	for _, spec := range commandList {
+		spec.categories = implicitCategories(spec)
+	}
+	slices.SortFunc(commandList, func(a, b *commandSpec) int { return cmp.Compare(a.name, b.name) })
+}
+
+// implicitCategories adds the ACL categories spec's flags imply to the
+// ones it lists, like redis: write and readonly commands are @write and
+// @read, admin ones @admin and @dangerous, and anything not fast is @slow.
+// They come in the order ACL CAT lists them.
+func implicitCategories(spec *commandSpec) []string {
+	categories := slices.Clone(spec.categories)
+	implied := []struct {
+		flag     int
+		category []string
+	}{
+		{cmdWrite, []string{"write"}},
+		{cmdReadonly, []string{"read"}},
+		{cmdAdmin, []string{"admin", "dangerous"}},
+		{cmdPubsub, []string{"pubsub"}},
+		{cmdFast, []string{"fast"}},
+	}
+	for _, i := range implied {
+		if spec.flags&i.flag != 0 {
+			categories = append(categories, i.category...)
+		}
+	}
+	if spec.flags&cmdFast == 0 {
+		categories = append(categories, "slow")
+	}
+	slices.SortFunc(categories, func(a, b string) int {
+		return cmp.Compare(slices.Index(aclCategories, a), slices.Index(aclCategories, b))
+	})
+	return slices.Compact(categories)
+}
+
+// lookupCommand returns the spec of cmd's subcommand if it is a known one,
+// of cmd otherwise, and nil for an unknown command.
+func lookupCommand(cmd *Command) *commandSpec {
+	spec := commandTable[cmd.Name]
+	if spec == nil || len(cmd.Args) == 0 {
+		return spec
+	}
+	if sub := spec.subcommand(cmd.Args[0]); sub != nil {
+		return sub
+	}
+	return spec
+}
+
+// lookupCommandName finds a command by name, or a subcommand as
+// parent|sub, in any case.
+func lookupCommandName(name string) *commandSpec {
+	parent, sub, ok := strings.Cut(name, "|")
+	spec := commandTable[strings.ToUpper(parent)]
+	if spec == nil || !ok {
+		return spec
+	}
+	return spec.subcommand(sub)
+}
+
+// subcommand returns spec's subcommand called name, nil if there is none.
+func (spec *commandSpec) subcommand(name string) *commandSpec {
+	for _, sub := range spec.subcommands {
+		if strings.EqualFold(sub.name[len(spec.name)+1:], name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// arityOK reports whether cmd has the number of arguments spec takes.
+func (spec *commandSpec) arityOK(cmd *Command) bool {
+	n := len(cmd.Args) + 1
+	return spec.arity >= 0 && n == spec.arity || spec.arity < 0 && n >= -spec.arity
+}
+
+// checkArity refuses unknown commands and wrong numbers of arguments, to
+// the command or to its subcommand.
+func checkArity(cmd *Command) []byte {
+	spec, ok := commandTable[cmd.Name]
+	if !ok {
+		return FormatError("unknown command")
+	}
+	if !spec.arityOK(cmd) {
+		return FormatError("wrong number of arguments for '" + spec.name + "' command")
+	}
+	if sub := lookupCommand(cmd); !sub.arityOK(cmd) {
+		return FormatError("wrong number of arguments for '" + sub.name + "' command")
+	}
+	return nil
+}
+
+// commandCommand implements COMMAND and its COUNT, INFO, DOCS, GETKEYS and
+// LIST subcommands, all served from the command table.
+func (r *Radisa) commandCommand(cmd *Command) []byte {
+	resp := 2
+	if r.currentClient != nil {
+		resp = r.currentClient.resp
+	}
+	if len(cmd.Args) == 0 {
+		return formatCommandInfos(commandTableSorted(), resp)
+	}
+
+	sub, args := strings.ToUpper(cmd.Args[0]), cmd.Args[1:]
+	switch sub {
+	case "COUNT":
+		return FormatInteger(int64(len(commandTable)))
+
+	case "INFO":
+		if len(args) == 0 {
+			return formatCommandInfos(commandTableSorted(), resp)
+		}
+		replies := make([][]byte, len(args))
+		for i, name := range args {
+			spec := lookupCommandName(name)
+			switch {
+			case spec != nil:
+				replies[i] = spec.info(resp)
+			case resp == 3:
+				replies[i] = FormatNull()
+			default:
+				replies[i] = FormatNullArray()
+			}
+		}
+		return FormatReplies(replies)
+
+	case "DOCS":
+		specs := commandTableSorted()
+		if len(args) > 0 {
+			specs = nil
+			for _, name := range args {
+				if spec := lookupCommandName(name); spec != nil {
+					specs = append(specs, spec)
+				}
+			}
+		}
+		var pairs [][]byte
+		for _, spec := range specs {
+			pairs = append(pairs, FormatBulk(spec.name), spec.docs(resp))
+		}
+		return formatPairs(pairs, resp)
+
+	case "GETKEYS":
+		target := &Command{Name: strings.ToUpper(args[0]), Args: args[1:]}
+		spec := commandTable[target.Name]
+		if spec == nil {
+			return FormatError("Invalid command specified")
+		}
+		if checkArity(target) != nil {
+			return FormatError("Invalid number of arguments specified for command")
+		}
+		keys := lookupCommand(target).keys.keys(target)
+		if len(keys) == 0 {
+			return FormatError("The command has no key arguments")
+		}
+		return FormatArray(keys)
+
+	case "LIST":
+		match := func(*commandSpec) bool { return true }
+		if len(args) > 0 {
+			if len(args) != 3 || !strings.EqualFold(args[0], "FILTERBY") {
+				return FormatError("syntax error")
+			}
+			switch value := args[2]; strings.ToUpper(args[1]) {
+			case "ACLCAT":
+				match = func(spec *commandSpec) bool { return slices.Contains(spec.categories, strings.ToLower(value)) }
+			case "PATTERN":
+				match = func(spec *commandSpec) bool { return matchesGlob(spec.name, strings.ToLower(value)) }
+			case "MODULE":
+				// No modules, so no commands of theirs
+				match = func(*commandSpec) bool { return false }
+			default:
+				return FormatError("syntax error")
+			}
+		}
+		var names []string
+		for _, spec := range commandList {
+			if match(spec) {
+				names = append(names, spec.name)
+			}
+		}
+		return FormatArray(names)
+	}
+	return FormatError("unknown subcommand '" + cmd.Args[0] + "'. Try COMMAND HELP.")
+}
+
+// commandTableSorted returns the top-level commands sorted by name.
+func commandTableSorted() []*commandSpec {
+	return slices.SortedFunc(maps.Values(commandTable), func(a, b *commandSpec) int { return cmp.Compare(a.name, b.name) })
+}
+
+func formatCommandInfos(specs []*commandSpec, resp int) []byte {
+	replies := make([][]byte, len(specs))
+	for i, spec := range specs {
+		replies[i] = spec.info(resp)
+	}
+	return FormatReplies(replies)
+}
+
+// info formats spec as an entry of COMMAND INFO: name, arity, flags, the
+// legacy first key, last key and step, ACL categories, tips, key specs and
+// subcommands.
+func (spec *commandSpec) info(resp int) []byte {
+	var flags [][]byte
+	for _, f := range commandFlagNames {
+		if spec.flags&f.flag != 0 {
+			flags = append(flags, FormatSimpleString(f.name))
+		}
+	}
+	categories := make([][]byte, len(spec.categories))
+	for i, category := range spec.categories {
+		categories[i] = FormatSimpleString("@" + category)
+	}
+	var keySpecs [][]byte
+	if spec.keys.first > 0 {
+		keySpecs = append(keySpecs, spec.keys.info(resp))
+	}
+	subcommands := make([][]byte, len(spec.subcommands))
+	for i, sub := range spec.subcommands {
+		subcommands[i] = sub.info(resp)
+	}
+
+	return FormatReplies([][]byte{
+		FormatBulk(spec.name),
+		FormatInteger(int64(spec.arity)),
+		FormatReplies(flags),
+		FormatInteger(int64(spec.keys.first)),
+		FormatInteger(int64(spec.keys.last)),
+		FormatInteger(int64(spec.keys.step)),
+		FormatReplies(categories),
+		FormatReplies(nil),
+		FormatReplies(keySpecs),
+		FormatReplies(subcommands),
+	})
+}
+
+// info formats ks as a COMMAND INFO key spec: an index begin search and a
+// range find keys, with lastkey relative to the first key unless it counts
+// from the end.
+func (ks keySpec) info(resp int) []byte {
+	var flags [][]byte
+	for _, f := range keySpecFlagNames {
+		if ks.flags&f.flag != 0 {
+			flags = append(flags, FormatSimpleString(f.name))
+		}
+	}
+	lastkey := ks.last
+	if lastkey >= 0 {
+		lastkey -= ks.first
+	}
+	return formatPairs([][]byte{
+		FormatBulk("flags"), FormatReplies(flags),
+		FormatBulk("begin_search"), formatPairs([][]byte{
+			FormatBulk("type"), FormatBulk("index"),
+			FormatBulk("spec"), formatPairs([][]byte{FormatBulk("index"), FormatInteger(int64(ks.first))}, resp),
+		}, resp),
+		FormatBulk("find_keys"), formatPairs([][]byte{
+			FormatBulk("type"), FormatBulk("range"),
+			FormatBulk("spec"), formatPairs([][]byte{
+				FormatBulk("lastkey"), FormatInteger(int64(lastkey)),
+				FormatBulk("keystep"), FormatInteger(int64(ks.step)),
+				FormatBulk("limit"), FormatInteger(0),
+			}, resp),
+		}, resp),
+	}, resp)
+}
+
+// docs formats spec's entry of COMMAND DOCS.
+func (spec *commandSpec) docs(resp int) []byte {
+	pairs := [][]byte{
+		FormatBulk("summary"), FormatBulk(spec.summary),
+		FormatBulk("since"), FormatBulk(spec.since),
+		FormatBulk("group"), FormatBulk(spec.group),
+	}
+	if len(spec.subcommands) > 0 {
+		var subs [][]byte
+		for _, sub := range spec.subcommands {
+			subs = append(subs, FormatBulk(sub.name), sub.docs(resp))
+		}
+		pairs = append(pairs, FormatBulk("subcommands"), formatPairs(subs, resp))
+	}
+	return formatPairs(pairs, resp)
+}
+
+// formatPairs formats key value pairs as a map on RESP3 and a flat array
+// on RESP2.
+func formatPairs(pairs [][]byte, resp int) []byte {
+	if resp == 3 {
+		return FormatMap(pairs)
+	}
+	return FormatReplies(pairs)
+}
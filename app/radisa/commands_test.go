@@ -0,0 +1,110 @@
+package radisa
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCommandTable(t *testing.T) {
+	for _, spec := range commandList {
+		if spec.arity == 0 || spec.summary == "" || spec.since == "" || spec.group == "" {
+			t.Errorf("Expected %s to have an arity and docs", spec.name)
+		}
+		if spec.keys.first > 0 && spec.keys.step <= 0 {
+			t.Errorf("Expected %s to have a key step", spec.name)
+		}
+		if len(spec.categories) == 0 {
+			t.Errorf("Expected %s to have ACL categories", spec.name)
+		}
+	}
+	for name, spec := range commandTable {
+		if name != strings.ToUpper(spec.name) {
+			t.Errorf("Expected %s to be registered as %s", spec.name, name)
+		}
+		// The connection loop handles these on its own
+		if spec.proc == nil && spec.connProc == nil && name != "PSYNC" && name != "QUIT" {
+			t.Errorf("Expected %s to have a handler", name)
+		}
+	}
+}
+
+func TestCommand_InfoAndCount(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	keySpec := "*6\r\n$5\r\nflags\r\n*2\r\n+RO\r\n+access\r\n" +
+		"$12\r\nbegin_search\r\n*4\r\n$4\r\ntype\r\n$5\r\nindex\r\n$4\r\nspec\r\n*2\r\n$5\r\nindex\r\n:1\r\n" +
+		"$9\r\nfind_keys\r\n*4\r\n$4\r\ntype\r\n$5\r\nrange\r\n$4\r\nspec\r\n*6\r\n$7\r\nlastkey\r\n:0\r\n$7\r\nkeystep\r\n:1\r\n$5\r\nlimit\r\n:0\r\n"
+	expected := "*2\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n" +
+		"*3\r\n+@read\r\n+@string\r\n+@fast\r\n*0\r\n*1\r\n" + keySpec + "*0\r\n*-1\r\n"
+	if got := commandForTest(t, conn, reader, "COMMAND", "INFO", "get", "nosuch"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	got := commandForTest(t, conn, reader, "COMMAND", "INFO", "config")
+	if !strings.Contains(got, "$10\r\nconfig|get\r\n:-3\r\n*4\r\n+admin\r\n+noscript\r\n+loading\r\n+stale\r\n") {
+		t.Errorf("Expected CONFIG GET among the subcommands, got %q", got)
+	}
+
+	count := ":" + strconv.Itoa(len(commandTable)) + "\r\n"
+	if got := commandForTest(t, conn, reader, "COMMAND", "COUNT"); got != count {
+		t.Errorf("Expected %q, got %q", count, got)
+	}
+	if got := commandForTest(t, conn, reader, "COMMAND"); !strings.HasPrefix(got, "*"+strconv.Itoa(len(commandTable))+"\r\n") {
+		t.Errorf("Expected every command, got %q", got)
+	}
+}
+
+func TestCommand_GetKeysListAndDocs(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"COMMAND", "GETKEYS", "SET", "k", "v"}, "*1\r\n$1\r\nk\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "del", "a", "b", "c"}, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "NOPE", "k"}, "-ERR Invalid command specified\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "GET", "a", "b"}, "-ERR Invalid number of arguments specified for command\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "PUBLISH", "ch", "m"}, "-ERR The command has no key arguments\r\n"},
+		{[]string{"COMMAND", "LIST", "FILTERBY", "ACLCAT", "transaction"}, "*5\r\n$7\r\ndiscard\r\n$4\r\nexec\r\n$5\r\nmulti\r\n$7\r\nunwatch\r\n$5\r\nwatch\r\n"},
+		{[]string{"COMMAND", "LIST", "FILTERBY", "PATTERN", "config*"}, "*2\r\n$6\r\nconfig\r\n$10\r\nconfig|get\r\n"},
+		{[]string{"COMMAND", "LIST", "FILTERBY", "MODULE", "json"}, "*0\r\n"},
+		{[]string{"COMMAND", "LIST", "FILTERBY", "NOPE", "x"}, "-ERR syntax error\r\n"},
+		{[]string{"COMMAND", "DOCS", "get", "nosuch"}, "*2\r\n$3\r\nget\r\n*6\r\n$7\r\nsummary\r\n$34\r\nReturns the string value of a key.\r\n$5\r\nsince\r\n$5\r\n1.0.0\r\n$5\r\ngroup\r\n$6\r\nstring\r\n"},
+		{[]string{"COMMAND", "NOPE"}, "-ERR unknown subcommand 'NOPE'. Try COMMAND HELP.\r\n"},
+	}
+	for _, tt := range tests {
+		if got := commandForTest(t, conn, reader, tt.args...); got != tt.expected {
+			t.Errorf("Expected %q for %v, got %q", tt.expected, tt.args, got)
+		}
+	}
+}
+
+func TestCommand_Arity(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"ECHO", "a", "b"}, "-ERR wrong number of arguments for 'echo' command\r\n"},
+		{[]string{"CONFIG", "GET"}, "-ERR wrong number of arguments for 'config|get' command\r\n"},
+		{[]string{"CONFIG", "REWRITE"}, "-ERR unknown subcommand 'REWRITE'. Try CONFIG HELP.\r\n"},
+		{[]string{"CLIENT", "ID", "extra"}, "-ERR wrong number of arguments for 'client|id' command\r\n"},
+		{[]string{"WATCH"}, "-ERR wrong number of arguments for 'watch' command\r\n"},
+		// COMMAND can be queued, unlike the commands of the connection
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"COMMAND", "COUNT"}, "+QUEUED\r\n"},
+		{[]string{"HELLO"}, "-ERR Command not allowed inside a transaction\r\n"},
+		{[]string{"DISCARD"}, "+OK\r\n"},
+	}
+	for _, tt := range tests {
+		if got := commandForTest(t, conn, reader, tt.args...); got != tt.expected {
+			t.Errorf("Expected %q for %v, got %q", tt.expected, tt.args, got)
+		}
+	}
+}
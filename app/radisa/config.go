@@ -0,0 +1,167 @@
+package radisa
+
+import "strconv"
+
+// What to do when the RDB file on disk is truncated or corrupt.
+const (
+	RDBLoadRefuse = "refuse"     // don't start at all
+	RDBLoadValid  = "load-valid" // keep the keys read before the damage
+)
+
+// appendfsync policies
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+// Config holds the tunables main.go exposes as flags on top of dir, dbfilename
+// and port.
+type Config struct {
+	// SavePoints trigger a BGSAVE, like `save <seconds> <changes>` in redis.conf.
+	SavePoints []SavePoint
+	// RDBCorruptPolicy is RDBLoadRefuse or RDBLoadValid.
+	RDBCorruptPolicy string
+	// RDBCompression LZF-compresses long strings in snapshots.
+	RDBCompression bool
+
+	// AppendOnly logs every write to the AOF under Dir/AppendDirName.
+	AppendOnly       bool
+	AppendFilename   string
+	AppendDirName    string
+	AppendFsync      string
+	AOFLoadTruncated bool
+	// AOFUseRDBPreamble makes rewrites write the base file as an RDB.
+	AOFUseRDBPreamble bool
+	// A rewrite starts once the AOF grew by AutoAOFRewritePercentage percent
+	// since the last one and is at least AutoAOFRewriteMinSize bytes. 0
+	// percent disables it.
+	AutoAOFRewritePercentage int
+	AutoAOFRewriteMinSize    int64
+
+	// ReplBacklogSize is how many bytes of the replication stream are kept
+	// for replicas that reconnect.
+	ReplBacklogSize int
+	// ReplicaReadOnly refuses writes from clients other than the master.
+	ReplicaReadOnly bool
+	// ReplicaServeStaleData keeps answering from the old data while the
+	// link to the master is down; off, only a few commands are allowed.
+	ReplicaServeStaleData bool
+	// ReplDisklessSync streams snapshots to replicas as they are encoded.
+	// It is off by default, like before redis 7.0.
+	ReplDisklessSync bool
+	// ReplDisklessSyncDelay is how many seconds a diskless transfer waits
+	// for more replicas to share it.
+	ReplDisklessSyncDelay int
+	// ReplDisklessLoad is "disabled", "on-empty-db" or "swapdb": whether a
+	// replica parses its master's snapshot straight from the socket instead
+	// of receiving it whole first.
+	ReplDisklessLoad string
+
+	// NotifyKeyspaceEvents are the keyspace event classes published to
+	// subscribers, see ParseKeyspaceEvents. None by default.
+	NotifyKeyspaceEvents int
+
+	// TrackingTableMaxKeys bounds how many keys client side caching
+	// remembers readers for, 0 for no limit.
+	TrackingTableMaxKeys int
+
+	// RequirePass is the password clients have to AUTH with, none if
+	// empty. MasterAuth is the one a replica sends its master.
+	RequirePass string
+	MasterAuth  string
+
+	// ACLFile is where ACL SAVE and ACL LOAD keep the users, none if empty.
+	ACLFile string
+	// ACLLogMaxLen is how many entries ACL LOG keeps.
+	ACLLogMaxLen int
+}
+
+// ReplDisklessLoadModes are the values repl-diskless-load accepts.
+var ReplDisklessLoadModes = []string{"disabled", "on-empty-db", "swapdb"}
+
+// DefaultConfig returns the settings redis-server starts with when no
+// redis.conf is given.
+func DefaultConfig() Config {
+	return Config{
+		SavePoints:       []SavePoint{{3600, 1}, {300, 100}, {60, 10000}},
+		RDBCorruptPolicy: RDBLoadRefuse,
+		RDBCompression:   true,
+		AppendFilename:   "appendonly.aof",
+		AppendDirName:    "appendonlydir",
+		AppendFsync:      FsyncEverySec,
+		AOFLoadTruncated: true,
+
+		AOFUseRDBPreamble:        true,
+		AutoAOFRewritePercentage: 100,
+		AutoAOFRewriteMinSize:    64 << 20,
+
+		ReplBacklogSize:       1 << 20,
+		ReplicaReadOnly:       true,
+		ReplicaServeStaleData: true,
+		ReplDisklessSyncDelay: 5,
+		ReplDisklessLoad:      "disabled",
+
+		TrackingTableMaxKeys: 1000000,
+
+		ACLLogMaxLen: 128,
+	}
+}
+
+// get returns a parameter for CONFIG GET in redis.conf form.
+func (c Config) get(name string) (string, bool) {
+	switch name {
+	case "save":
+		return FormatSavePoints(c.SavePoints), true
+	case "rdbcompression":
+		return yesNo(c.RDBCompression), true
+	case "appendonly":
+		return yesNo(c.AppendOnly), true
+	case "appendfilename":
+		return c.AppendFilename, true
+	case "appenddirname":
+		return c.AppendDirName, true
+	case "appendfsync":
+		return c.AppendFsync, true
+	case "aof-load-truncated":
+		return yesNo(c.AOFLoadTruncated), true
+	case "aof-use-rdb-preamble":
+		return yesNo(c.AOFUseRDBPreamble), true
+	case "auto-aof-rewrite-percentage":
+		return strconv.Itoa(c.AutoAOFRewritePercentage), true
+	case "auto-aof-rewrite-min-size":
+		return strconv.FormatInt(c.AutoAOFRewriteMinSize, 10), true
+	case "repl-backlog-size":
+		return strconv.Itoa(c.ReplBacklogSize), true
+	case "replica-read-only", "slave-read-only":
+		return yesNo(c.ReplicaReadOnly), true
+	case "replica-serve-stale-data", "slave-serve-stale-data":
+		return yesNo(c.ReplicaServeStaleData), true
+	case "repl-diskless-sync":
+		return yesNo(c.ReplDisklessSync), true
+	case "repl-diskless-sync-delay":
+		return strconv.Itoa(c.ReplDisklessSyncDelay), true
+	case "repl-diskless-load":
+		return c.ReplDisklessLoad, true
+	case "notify-keyspace-events":
+		return FormatKeyspaceEvents(c.NotifyKeyspaceEvents), true
+	case "tracking-table-max-keys":
+		return strconv.Itoa(c.TrackingTableMaxKeys), true
+	case "requirepass":
+		return c.RequirePass, true
+	case "masterauth":
+		return c.MasterAuth, true
+	case "aclfile":
+		return c.ACLFile, true
+	case "acllog-max-len":
+		return strconv.Itoa(c.ACLLogMaxLen), true
+	}
+	return "", false
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,46 @@
+package radisa
+
+import "strings"
+
+// clusterSlots is the number of hash slots keys and shard channels map to.
+const clusterSlots = 16384
+
+// Redis cluster hashes keys with CRC-16/XMODEM: polynomial 0x1021, zero
+// initial value, not reflected.
+var crc16Table = makeCRC16Table()
+
+func makeCRC16Table() *[256]uint16 {
+	table := new([256]uint16)
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// keyHashSlot returns the slot of key. Only a non-empty hash tag, the part
+// between the first { and the next }, is hashed when there is one, so
+// related keys can be kept in the same slot.
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) & (clusterSlots - 1)
+}
@@ -0,0 +1,32 @@
+package radisa
+
+// Redis checksums RDB files with CRC-64/Jones: reflected, polynomial
+// 0xad93d23594c935a9, zero initial value and no final xor. hash/crc64 always
+// inverts the register, so it can't be reused here.
+const crc64JonesPoly = 0x95ac9329ac4bc9b5 // 0xad93d23594c935a9 bit-reversed
+
+var crc64JonesTable = makeCRC64JonesTable()
+
+func makeCRC64JonesTable() *[256]uint64 {
+	table := new([256]uint64)
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = (crc >> 1) ^ crc64JonesPoly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// crc64Jones continues crc over data.
+func crc64Jones(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = crc64JonesTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
@@ -0,0 +1,179 @@
+package radisa
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expireIfNeeded reports whether key is past its deadline and deletes it if
+// so. The deletion is propagated as an explicit DEL, so the AOF and replicas
+// drop the key at the same point in the stream instead of relying on their
+// own clocks. A replica only expires keys logically: clients see them as
+// missing, but they stay until the master's DEL arrives, and commands from
+// the master still see them. The caller holds mu.
+func (r *Radisa) expireIfNeeded(key string) bool {
+	if !r.keyIsExpired(key) {
+		return false
+	}
+
+	if r.replicaOf != nil {
+		return !r.applyingMaster
+	}
+
+	r.deleteExpiredKey(key)
+	return true
+}
+
+// deleteExpiredKey removes key once it is past its deadline, wherever that
+// is noticed. The caller holds mu.
+func (r *Radisa) deleteExpiredKey(key string) {
+	r.deleteKey(key)
+	r.signalModifiedKey(key)
+	r.dirty++
+	r.propagate(&Command{Name: "DEL", Args: []string{key}})
+	r.notifyKeyspaceEvent(notifyExpired, "expired", key)
+}
+
+// keyIsExpired reports whether key exists but is past its deadline. The
+// caller holds mu.
+func (r *Radisa) keyIsExpired(key string) bool {
+	value, ok := r.data[key]
+	return ok && !value.expire.IsZero() && time.Now().After(value.expire)
+}
+
+// setKey stores value under key. The caller holds mu.
+func (r *Radisa) setKey(key string, value Data) {
+	r.keepForSnapshots(key)
+	r.data[key] = value
+}
+
+// deleteKey removes key. The caller holds mu.
+func (r *Radisa) deleteKey(key string) {
+	r.keepForSnapshots(key)
+	delete(r.data, key)
+}
+
+// lookupKey returns the value of key unless it is missing or expired. The
+// caller holds mu.
+func (r *Radisa) lookupKey(key string) (Data, bool) {
+	if r.expireIfNeeded(key) {
+		return Data{}, false
+	}
+	value, ok := r.data[key]
+	return value, ok
+}
+
+// incrBy implements INCR, DECR, INCRBY and DECRBY. A missing key counts as
+// 0 and an existing one keeps its expiry. The caller holds mu.
+func (r *Radisa) incrBy(cmd *Command) []byte {
+	name := strings.ToLower(cmd.Name)
+	delta := int64(1)
+	switch cmd.Name {
+	case "INCR", "DECR":
+		if len(cmd.Args) != 1 {
+			return FormatError(fmt.Sprintf("wrong number of arguments for '%s' command", name))
+		}
+	default:
+		if len(cmd.Args) != 2 {
+			return FormatError(fmt.Sprintf("wrong number of arguments for '%s' command", name))
+		}
+		n, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+		if err != nil {
+			return FormatError("value is not an integer or out of range")
+		}
+		delta = n
+	}
+	if cmd.Name == "DECR" || cmd.Name == "DECRBY" {
+		if delta == math.MinInt64 {
+			return FormatError("decrement would overflow")
+		}
+		delta = -delta
+	}
+
+	key := cmd.Args[0]
+	value, exists := r.lookupKey(key)
+	current := int64(0)
+	if exists {
+		if value.kind != kindString {
+			return FormatWrongType()
+		}
+		n, err := strconv.ParseInt(value.value, 10, 64)
+		if err != nil {
+			return FormatError("value is not an integer or out of range")
+		}
+		current = n
+	}
+	if delta > 0 && current > math.MaxInt64-delta || delta < 0 && current < math.MinInt64-delta {
+		return FormatError("increment or decrement would overflow")
+	}
+
+	current += delta
+	value.value = strconv.FormatInt(current, 10)
+	r.setKey(key, value)
+	r.signalModifiedKey(key)
+	r.dirty++
+	r.propagate(cmd)
+	if !exists {
+		r.notifyKeyspaceEvent(notifyNew, "new", key)
+	}
+	r.notifyKeyspaceEvent(notifyString, "incrby", key)
+	return FormatInteger(current)
+}
+
+// The expire cycle samples activeExpireCycleKeys keys with a deadline every
+// activeExpireCyclePeriod and samples again while more than a quarter of them
+// had expired, for at most activeExpireCycleBudget. That bounds the memory
+// held by expired keys nobody reads, without stalling clients.
+const (
+	activeExpireCycleKeys   = 20
+	activeExpireCyclePeriod = 100 * time.Millisecond
+	activeExpireCycleBudget = 25 * time.Millisecond
+)
+
+// activeExpireCron runs the expire cycle, like serverCron does at hz 10.
+func (r *Radisa) activeExpireCron() {
+	ticker := time.NewTicker(activeExpireCyclePeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.mu.Lock()
+		r.activeExpireCycle()
+		r.mu.Unlock()
+	}
+}
+
+// activeExpireCycle deletes expired keys that nobody looks up. Map iteration
+// starts at a random key, so ranging over data is the sample. A replica
+// leaves this to its master, whose DELs it applies. The caller holds mu.
+func (r *Radisa) activeExpireCycle() {
+	if r.replicaOf != nil {
+		return
+	}
+
+	start := time.Now()
+	for {
+		visited, sampled, expired := 0, 0, 0
+		for key, value := range r.data {
+			// Don't scan a big keyspace with few volatile keys end to end
+			if visited++; visited > activeExpireCycleKeys*20 {
+				break
+			}
+			if value.expire.IsZero() {
+				continue
+			}
+			if r.keyIsExpired(key) {
+				r.deleteExpiredKey(key)
+				expired++
+			}
+			if sampled++; sampled == activeExpireCycleKeys {
+				break
+			}
+		}
+		if expired*4 <= sampled || time.Since(start) > activeExpireCycleBudget {
+			return
+		}
+	}
+}
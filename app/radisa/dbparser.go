@@ -2,334 +2,1239 @@ package radisa
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
 	"time"
 )
 
+// RDB opcodes, value types and special string encodings, see
+// https://rdb.fnordig.de/file_format.html
+const (
+	rdbOpModuleAux    = 0xF7
+	rdbOpFunction2    = 0xF5
+	rdbOpIdle         = 0xF8
+	rdbOpFreq         = 0xF9
+	rdbOpAux          = 0xFA
+	rdbOpResizeDB     = 0xFB
+	rdbOpExpireTimeMS = 0xFC
+	rdbOpExpireTime   = 0xFD
+	rdbOpSelectDB     = 0xFE
+	rdbOpEOF          = 0xFF
+
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZSet             = 3
+	rdbTypeHash             = 4
+	rdbTypeZSet2            = 5
+	rdbTypeModule           = 6
+	rdbTypeModule2          = 7
+	rdbTypeHashZipmap       = 9
+	rdbTypeListZiplist      = 10
+	rdbTypeSetIntset        = 11
+	rdbTypeZSetZiplist      = 12
+	rdbTypeHashZiplist      = 13
+	rdbTypeListQuicklist    = 14
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZSetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+
+	// module values are a sequence of these, see RedisModule_Save*
+	rdbModuleOpEOF    = 0
+	rdbModuleOpSInt   = 1
+	rdbModuleOpUInt   = 2
+	rdbModuleOpFloat  = 3
+	rdbModuleOpDouble = 4
+	rdbModuleOpString = 5
+
+	// quicklist 2 node containers
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+
+	streamItemFlagDeleted    = 1
+	streamItemFlagSameFields = 2
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+// RDBError describes why parsing stopped and at which byte offset.
+type RDBError struct {
+	Offset int
+	Reason string
+}
+
+func (e *RDBError) Error() string {
+	return fmt.Sprintf("corrupt RDB at offset %d: %s", e.Offset, e.Reason)
+}
+
+// rdbStreamCompact is how many consumed bytes a streaming parser keeps
+// before dropping them from its buffer.
+const rdbStreamCompact = 64 << 10
+
 type RDBParser struct {
 	keyVals map[string]Data
-	data []byte
-	pos  int
+	data    []byte
+	pos     int
+	version int
+
+	// src is where a streaming parser reads more of data from. base is the
+	// offset of data[0] in the whole file and crc the checksum of what was
+	// dropped before it.
+	src  io.Reader
+	base int
+	crc  uint64
 }
 
 func NewRDBParser(data []byte) *RDBParser {
 	return &RDBParser{
 		keyVals: make(map[string]Data),
-		data: data,
-		pos:  0,
+		data:    data,
+		pos:     0,
+	}
+}
+
+// NewRDBStreamParser parses an RDB as it arrives from src, like a replica
+// loading its master's snapshot straight from the socket. It reads exactly
+// up to the end of the RDB, so whatever follows stays in src.
+func NewRDBStreamParser(src io.Reader) *RDBParser {
+	return &RDBParser{
+		keyVals: make(map[string]Data),
+		src:     src,
 	}
 }
 
-func (p *RDBParser) Parse() map[string]Data {
+// Parse decodes the whole file. On failure it returns the keys read before
+// the corrupt spot together with an *RDBError, so callers can decide whether
+// a partial load is acceptable.
+func (p *RDBParser) Parse() (map[string]Data, error) {
 	// Parse header
-	if !p.parseHeader() {
-		return p.keyVals
+	if err := p.parseHeader(); err != nil {
+		return p.keyVals, err
 	}
 
 	// Parse metadata section
-	p.parseMetadata()
+	if err := p.parseMetadata(); err != nil {
+		return p.keyVals, err
+	}
 
 	// Parse database section
-	p.parseDatabase()
+	if err := p.parseDatabase(); err != nil {
+		return p.keyVals, err
+	}
 
 	// Parse end of file
-	p.parseEOF()
+	if err := p.parseEOF(); err != nil {
+		return p.keyVals, err
+	}
 
-	return p.keyVals
+	return p.keyVals, nil
 }
 
-func (p *RDBParser) parseHeader() bool {
-	if len(p.data) < 9 {
-		fmt.Println("File too small")
+func (p *RDBParser) errorf(format string, args ...any) error {
+	return &RDBError{Offset: p.offset(), Reason: fmt.Sprintf(format, args...)}
+}
+
+// offset is the position in the whole file, which differs from pos once a
+// streaming parser dropped what it consumed.
+func (p *RDBParser) offset() int {
+	return p.base + p.pos
+}
+
+// left is the number of bytes buffered past pos.
+func (p *RDBParser) left() int {
+	return len(p.data) - p.pos
+}
+
+// ensure reports whether n more bytes are available, reading them from src
+// when streaming. Reads go in bounded chunks, so a corrupt length doesn't
+// allocate more than the stream actually holds.
+func (p *RDBParser) ensure(n int) bool {
+	if n < 0 || p.pos+n < p.pos {
 		return false
 	}
+	if p.pos+n <= len(p.data) {
+		return true
+	}
+	if p.src == nil {
+		return false
+	}
+
+	if p.pos >= rdbStreamCompact {
+		// Slices handed out earlier keep pointing into the old buffer
+		p.crc = crc64Jones(p.crc, p.data[:p.pos])
+		p.base += p.pos
+		p.data = append([]byte(nil), p.data[p.pos:]...)
+		p.pos = 0
+	}
+
+	for len(p.data) < p.pos+n {
+		start := len(p.data)
+		chunk := min(p.pos+n-start, rdbStreamCompact)
+		p.data = slices.Grow(p.data, chunk)[:start+chunk]
+		read, err := io.ReadFull(p.src, p.data[start:])
+		p.data = p.data[:start+read]
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// remains is ensure for lengths read from the file.
+func (p *RDBParser) remains(n uint64) bool {
+	return n <= math.MaxInt && p.ensure(int(n))
+}
+
+// need makes sure n more bytes are available before they are sliced out
+func (p *RDBParser) need(n int, what string) error {
+	if !p.ensure(n) {
+		return p.errorf("unexpected end of file reading %s: need %d bytes, %d left", what, n, p.left())
+	}
+	return nil
+}
+
+func (p *RDBParser) readByte(what string) (byte, error) {
+	if err := p.need(1, what); err != nil {
+		return 0, err
+	}
+	b := p.data[p.pos]
+	p.pos++
+	return b, nil
+}
+
+func (p *RDBParser) readBytes(n int, what string) ([]byte, error) {
+	if err := p.need(n, what); err != nil {
+		return nil, err
+	}
+	b := p.data[p.pos : p.pos+n]
+	p.pos += n
+	return b, nil
+}
+
+func (p *RDBParser) peek() (byte, error) {
+	if err := p.need(1, "opcode"); err != nil {
+		return 0, err
+	}
+	return p.data[p.pos], nil
+}
+
+func (p *RDBParser) parseHeader() error {
+	if !p.ensure(9) {
+		return p.errorf("file too small for header")
+	}
 
 	magic := string(p.data[0:5])
 	version := string(p.data[5:9])
-	
+
 	if magic != "REDIS" {
-		fmt.Printf("Invalid magic string: %s\n", magic)
-		return false
+		return p.errorf("invalid magic string %q", magic)
+	}
+
+	v, err := strconv.Atoi(version)
+	if err != nil || v < 1 {
+		return &RDBError{Offset: 5, Reason: fmt.Sprintf("invalid version %q", version)}
 	}
+	p.version = v
 
 	fmt.Printf("Magic: %s\n", magic)
 	fmt.Printf("Version: %s\n", version)
-	
+
 	p.pos = 9
-	return true
+	return nil
 }
 
-func (p *RDBParser) parseMetadata() {
+func (p *RDBParser) parseMetadata() error {
 	fmt.Println("\n=== Metadata Section ===")
-	
-	for p.pos < len(p.data) {
-		if p.data[p.pos] != 0xFA {
-			break // Not a metadata subsection
+
+	for p.ensure(1) {
+		switch p.data[p.pos] {
+		case rdbOpAux:
+		case rdbOpFunction2:
+			// Function libraries are source code we can't run, skip them
+			p.pos++
+			if _, err := p.readString(); err != nil {
+				return err
+			}
+			fmt.Println("Skipping function library")
+			continue
+		case rdbOpModuleAux:
+			p.pos++
+			if err := p.skipModuleAux(); err != nil {
+				return err
+			}
+			continue
+		default:
+			return nil // Not a metadata subsection
 		}
-		
+
 		p.pos++ // Skip 0xFA
-		
+
 		// Read metadata name
-		name := p.readString()
+		name, err := p.readString()
+		if err != nil {
+			return err
+		}
 		// Read metadata value
-		value := p.readString()
-		
+		value, err := p.readString()
+		if err != nil {
+			return err
+		}
+
 		fmt.Printf("Metadata: %s = %s\n", name, value)
 	}
+	return nil
 }
 
-func (p *RDBParser) parseDatabase() {
+func (p *RDBParser) parseDatabase() error {
 	fmt.Println("\n=== Database Section ===")
-	
-	for p.pos < len(p.data) {
-		if p.data[p.pos] == 0xFF {
-			break // End of file marker
-		}
-		
-		if p.data[p.pos] == 0xFE {
-			// Database subsection
-			p.pos++ // Skip 0xFE
-			
-			dbIndex := p.readSize()
-			fmt.Printf("Database index: %d\n", dbIndex)
-			
-			// Check for hash table size info
-			if p.pos < len(p.data) && p.data[p.pos] == 0xFB {
-				p.pos++ // Skip 0xFB
-				
-				hashTableSize := p.readSize()
-				expireTableSize := p.readSize()
-				
-				fmt.Printf("Hash table size: %d\n", hashTableSize)
-				fmt.Printf("Expire table size: %d\n", expireTableSize)
+
+	for {
+		opcode, err := p.peek()
+		if err != nil {
+			return err
+		}
+
+		if opcode == rdbOpEOF {
+			return nil // End of file marker
+		}
+
+		if opcode != rdbOpSelectDB {
+			return p.errorf("expected database selector, got 0x%02X", opcode)
+		}
+
+		// Database subsection
+		p.pos++ // Skip 0xFE
+
+		dbIndex, err := p.readSize()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Database index: %d\n", dbIndex)
+
+		// Check for hash table size info
+		if p.ensure(1) && p.data[p.pos] == rdbOpResizeDB {
+			p.pos++ // Skip 0xFB
+
+			hashTableSize, err := p.readSize()
+			if err != nil {
+				return err
 			}
-			
-			// Parse key-value pairs
-			p.parseKeyValuePairs()
-		} else {
-			break
+			expireTableSize, err := p.readSize()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Hash table size: %d\n", hashTableSize)
+			fmt.Printf("Expire table size: %d\n", expireTableSize)
+		}
+
+		// Parse key-value pairs
+		if err := p.parseKeyValuePairs(); err != nil {
+			return err
 		}
 	}
 }
 
-func (p *RDBParser) parseKeyValuePairs() {
+func (p *RDBParser) parseKeyValuePairs() error {
 	fmt.Println("\n--- Key-Value Pairs ---")
-	
-	for p.pos < len(p.data) {
-		if p.data[p.pos] == 0xFF {
-			break // End of file
-		}
-		
-		var expireTime int64 = -1
-		var expireType string = ""
-		
+
+	for {
+		opcode, err := p.peek()
+		if err != nil {
+			return err
+		}
+
+		if opcode == rdbOpEOF || opcode == rdbOpSelectDB {
+			return nil // End of file or next database
+		}
+
+		var expire time.Time
+
 		// Check for expire information
-		if p.data[p.pos] == 0xFD {
+		if opcode == rdbOpExpireTime {
 			// Expire in seconds
 			p.pos++
-			expireTime = int64(binary.LittleEndian.Uint32(p.data[p.pos:p.pos+4]))
-			expireType = "seconds"
-			p.pos += 4
-		} else if p.data[p.pos] == 0xFC {
+			b, err := p.readBytes(4, "expire time")
+			if err != nil {
+				return err
+			}
+			expire = time.Unix(int64(int32(binary.LittleEndian.Uint32(b))), 0)
+		} else if opcode == rdbOpExpireTimeMS {
 			// Expire in milliseconds
 			p.pos++
-			expireTime = int64(binary.LittleEndian.Uint64(p.data[p.pos:p.pos+8]))
-			expireType = "milliseconds"
-			p.pos += 8
+			b, err := p.readBytes(8, "expire time")
+			if err != nil {
+				return err
+			}
+			expire = time.UnixMilli(int64(binary.LittleEndian.Uint64(b)))
+		}
+
+		// LRU idle time and LFU frequency hints carry nothing we keep
+		if err := p.skipEvictionHints(); err != nil {
+			return err
 		}
-		
+
 		// Read value type
-		valueType := p.data[p.pos]
-		p.pos++
-		
+		typeOffset := p.offset()
+		valueType, err := p.readByte("value type")
+		if err != nil {
+			return err
+		}
+
 		// Read key
-		key := p.readString()
-		
-		// Read value based on type
-		var value interface{}
-		switch valueType {
-		case 0x00: // String
-			value = p.readString()
-		case 0x01: // List
-			value = p.readList()
-		case 0x02: // Set
-			value = p.readSet()
-		case 0x04: // Hash
-			value = p.readHash()
-		default:
-			fmt.Printf("Unknown value type: 0x%02X\n", valueType)
-			return
+		key, err := p.readString()
+		if err != nil {
+			return err
 		}
 
-		var expire time.Time
-		if expireTime != -1 {
-			if expireType == "seconds" {
-				expire = time.Unix(expireTime, 0)
-			} else {
-				expire = time.UnixMilli(expireTime)
+		// Module values are opaque without the module, drop the key
+		if valueType == rdbTypeModule2 {
+			if err := p.skipModuleValue(); err != nil {
+				return err
 			}
+			fmt.Printf("Skipping module value for key %s\n", key)
+			continue
 		}
 
-		p.keyVals[key] = Data{
-			value: fmt.Sprintf("%v", value),
-			expire: expire,
+		// Read value based on type
+		entry, err := p.readValue(valueType, typeOffset, key)
+		if err != nil {
+			return err
 		}
-		
+		entry.expire = expire
+
+		p.keyVals[key] = entry
+
 		fmt.Printf("Key: %s\n", key)
-		fmt.Printf("Value: %v\n", value)
 		fmt.Printf("Type: %s\n", p.getValueTypeName(valueType))
-		
-		if expireTime != -1 {
-			fmt.Printf("Expires: %d (%s)\n", expireTime, expireType)
+
+		if !expire.IsZero() {
+			fmt.Printf("Expires: %s\n", expire)
 		}
 		fmt.Println()
 	}
 }
 
-func (p *RDBParser) readSize() uint64 {
-	if p.pos >= len(p.data) {
-		return 0
+func (p *RDBParser) readValue(valueType byte, typeOffset int, key string) (Data, error) {
+	var entry Data
+	var err error
+
+	switch valueType {
+	case rdbTypeString:
+		entry.value, err = p.readString()
+	case rdbTypeList:
+		entry.kind = kindList
+		entry.list, err = p.readList()
+	case rdbTypeSet:
+		entry.kind = kindSet
+		entry.list, err = p.readSet()
+	case rdbTypeHash:
+		entry.kind = kindHash
+		entry.hash, err = p.readHash()
+	case rdbTypeZSet, rdbTypeZSet2:
+		entry.kind = kindZSet
+		entry.zset, err = p.readZSet(valueType == rdbTypeZSet2)
+	case rdbTypeHashZipmap:
+		entry.kind = kindHash
+		entry.hash, err = readBlob(p, "zipmap", decodeZipmap)
+	case rdbTypeListZiplist:
+		entry.kind = kindList
+		entry.list, err = readBlob(p, "ziplist", decodeZiplist)
+	case rdbTypeSetIntset:
+		entry.kind = kindSet
+		entry.list, err = readBlob(p, "intset", decodeIntset)
+	case rdbTypeSetListpack:
+		entry.kind = kindSet
+		entry.list, err = readBlob(p, "listpack", decodeListpack)
+	case rdbTypeZSetZiplist, rdbTypeZSetListpack:
+		decode := decodeListpack
+		if valueType == rdbTypeZSetZiplist {
+			decode = decodeZiplist
+		}
+		entry.kind = kindZSet
+		entry.zset, err = p.readPackedZSet(decode)
+	case rdbTypeHashZiplist, rdbTypeHashListpack:
+		decode := decodeListpack
+		if valueType == rdbTypeHashZiplist {
+			decode = decodeZiplist
+		}
+		entry.kind = kindHash
+		entry.hash, err = p.readPackedHash(decode)
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		entry.kind = kindList
+		entry.list, err = p.readQuicklist(valueType == rdbTypeListQuicklist2)
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		entry.kind = kindStream
+		entry.stream, err = p.readStream(valueType)
+	default:
+		return entry, &RDBError{Offset: typeOffset, Reason: fmt.Sprintf("unknown value type 0x%02X for key %q", valueType, key)}
 	}
-	
-	first := p.data[p.pos]
-	p.pos++
-	
+
+	return entry, err
+}
+
+func (p *RDBParser) skipEvictionHints() error {
+	for p.ensure(1) {
+		switch p.data[p.pos] {
+		case rdbOpIdle:
+			p.pos++
+			if _, err := p.readSize(); err != nil {
+				return err
+			}
+		case rdbOpFreq:
+			p.pos++
+			if _, err := p.readByte("LFU frequency"); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// readLength decodes a length prefix. encoded reports the 11xxxxxx form, in
+// which case length is the special string encoding instead of a size.
+func (p *RDBParser) readLength() (length uint64, encoded bool, err error) {
+	first, err := p.readByte("length")
+	if err != nil {
+		return 0, false, err
+	}
+
 	switch (first & 0xC0) >> 6 {
 	case 0: // 00: 6-bit size
-		return uint64(first & 0x3F)
+		return uint64(first & 0x3F), false, nil
 	case 1: // 01: 14-bit size
-		if p.pos >= len(p.data) {
-			return 0
+		second, err := p.readByte("length")
+		if err != nil {
+			return 0, false, err
 		}
-		second := p.data[p.pos]
-		p.pos++
-		return uint64(((first & 0x3F) << 8) | second)
-	case 2: // 10: 32-bit size
-		if p.pos+4 > len(p.data) {
-			return 0
+		return uint64(first&0x3F)<<8 | uint64(second), false, nil
+	case 2: // 10: 32-bit or 64-bit size
+		switch first {
+		case 0x80:
+			b, err := p.readBytes(4, "length")
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(b)), false, nil
+		case 0x81:
+			b, err := p.readBytes(8, "length")
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(b), false, nil
 		}
-		size := binary.BigEndian.Uint32(p.data[p.pos : p.pos+4])
-		p.pos += 4
-		return uint64(size)
-	case 3: // 11: Special string encoding
-		return uint64(first & 0x3F)
+		return 0, false, &RDBError{Offset: p.offset() - 1, Reason: fmt.Sprintf("invalid length prefix 0x%02X", first)}
+	default: // 11: Special string encoding
+		return uint64(first & 0x3F), true, nil
 	}
-	return 0
 }
 
-func (p *RDBParser) readString() string {
-	if p.pos >= len(p.data) {
-		return ""
+func (p *RDBParser) readSize() (uint64, error) {
+	size, encoded, err := p.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, &RDBError{Offset: p.offset() - 1, Reason: "expected a length, got a string encoding"}
 	}
-	
-	first := p.data[p.pos]
-	
+	return size, nil
+}
+
+// readCount reads an element count, rejecting counts that can't possibly fit
+// in what is left of the file so a corrupt header can't trigger a huge
+// allocation.
+func (p *RDBParser) readCount(what string) (int, error) {
+	count, err := p.readSize()
+	if err != nil {
+		return 0, err
+	}
+	if !p.remains(count) {
+		return 0, p.errorf("%s length %d exceeds remaining %d bytes", what, count, p.left())
+	}
+	return int(count), nil
+}
+
+func (p *RDBParser) readString() (string, error) {
+	length, encoded, err := p.readLength()
+	if err != nil {
+		return "", err
+	}
+
 	// Check if it's special string encoding (first two bits are 11)
-	if (first & 0xC0) == 0xC0 {
-		p.pos++
-		return p.readSpecialString(first & 0x3F)
+	if encoded {
+		return p.readSpecialString(byte(length))
 	}
-	
+
 	// Regular string encoding
-	length := p.readSize()
-	if p.pos+int(length) > len(p.data) {
-		return ""
+	if !p.remains(length) {
+		return "", p.errorf("string length %d exceeds remaining %d bytes", length, p.left())
 	}
-	
+
 	str := string(p.data[p.pos : p.pos+int(length)])
 	p.pos += int(length)
-	return str
+	return str, nil
 }
 
-func (p *RDBParser) readSpecialString(encoding byte) string {
+func (p *RDBParser) readSpecialString(encoding byte) (string, error) {
 	switch encoding {
-	case 0: // 8-bit integer
-		if p.pos >= len(p.data) {
-			return ""
-		}
-		val := p.data[p.pos]
-		p.pos++
-		return fmt.Sprintf("%d", val)
-	case 1: // 16-bit integer
-		if p.pos+2 > len(p.data) {
-			return ""
-		}
-		val := binary.LittleEndian.Uint16(p.data[p.pos : p.pos+2])
-		p.pos += 2
-		return fmt.Sprintf("%d", val)
-	case 2: // 32-bit integer
-		if p.pos+4 > len(p.data) {
-			return ""
-		}
-		val := binary.LittleEndian.Uint32(p.data[p.pos : p.pos+4])
-		p.pos += 4
-		return fmt.Sprintf("%d", val)
-	case 3: // LZF compressed (not implemented)
-		return "[LZF compressed - not implemented]"
+	case rdbEncInt8: // 8-bit integer
+		val, err := p.readByte("8-bit integer")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d", int8(val)), nil
+	case rdbEncInt16: // 16-bit integer
+		b, err := p.readBytes(2, "16-bit integer")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d", int16(binary.LittleEndian.Uint16(b))), nil
+	case rdbEncInt32: // 32-bit integer
+		b, err := p.readBytes(4, "32-bit integer")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d", int32(binary.LittleEndian.Uint32(b))), nil
+	case rdbEncLZF: // LZF compressed
+		return p.readLZFString()
 	default:
-		return fmt.Sprintf("[Unknown encoding: %d]", encoding)
+		return "", &RDBError{Offset: p.offset() - 1, Reason: fmt.Sprintf("unknown string encoding %d", encoding)}
 	}
 }
 
-func (p *RDBParser) readList() []string {
-	length := p.readSize()
+// readLZFString reads the compressed length, the uncompressed length and then
+// the LZF payload.
+func (p *RDBParser) readLZFString() (string, error) {
+	compressedLen, err := p.readSize()
+	if err != nil {
+		return "", err
+	}
+	length, err := p.readSize()
+	if err != nil {
+		return "", err
+	}
+
+	start := p.offset()
+	if !p.remains(compressedLen) {
+		return "", p.errorf("LZF payload length %d exceeds remaining %d bytes", compressedLen, p.left())
+	}
+	if length > compressedLen*lzfMaxRatio {
+		return "", p.errorf("LZF length %d can't come from %d compressed bytes", length, compressedLen)
+	}
+
+	payload, _ := p.readBytes(int(compressedLen), "LZF payload")
+	str, err := lzfDecompress(payload, int(length))
+	if err != nil {
+		return "", &RDBError{Offset: start, Reason: err.Error()}
+	}
+	return string(str), nil
+}
+
+func (p *RDBParser) readList() ([]string, error) {
+	length, err := p.readCount("list")
+	if err != nil {
+		return nil, err
+	}
 	list := make([]string, length)
-	
-	for i := uint64(0); i < length; i++ {
-		list[i] = p.readString()
+
+	for i := range list {
+		if list[i], err = p.readString(); err != nil {
+			return nil, err
+		}
 	}
-	
-	return list
+
+	return list, nil
 }
 
-func (p *RDBParser) readSet() []string {
-	length := p.readSize()
+func (p *RDBParser) readSet() ([]string, error) {
+	length, err := p.readCount("set")
+	if err != nil {
+		return nil, err
+	}
 	set := make([]string, length)
-	
-	for i := uint64(0); i < length; i++ {
-		set[i] = p.readString()
+
+	for i := range set {
+		if set[i], err = p.readString(); err != nil {
+			return nil, err
+		}
 	}
-	
-	return set
+
+	return set, nil
 }
 
-func (p *RDBParser) readHash() map[string]string {
-	length := p.readSize()
-	hash := make(map[string]string)
-	
-	for i := uint64(0); i < length; i++ {
-		key := p.readString()
-		value := p.readString()
+func (p *RDBParser) readHash() (map[string]string, error) {
+	length, err := p.readCount("hash")
+	if err != nil {
+		return nil, err
+	}
+	hash := make(map[string]string, length)
+
+	for i := 0; i < length; i++ {
+		key, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
 		hash[key] = value
 	}
-	
-	return hash
+
+	return hash, nil
 }
 
 func (p *RDBParser) getValueTypeName(valueType byte) string {
 	switch valueType {
-	case 0x00:
+	case rdbTypeString:
 		return "String"
-	case 0x01:
+	case rdbTypeList, rdbTypeListZiplist, rdbTypeListQuicklist, rdbTypeListQuicklist2:
 		return "List"
-	case 0x02:
+	case rdbTypeSet, rdbTypeSetIntset, rdbTypeSetListpack:
 		return "Set"
-	case 0x04:
+	case rdbTypeZSet, rdbTypeZSet2, rdbTypeZSetZiplist, rdbTypeZSetListpack:
+		return "Sorted Set"
+	case rdbTypeHash, rdbTypeHashZipmap, rdbTypeHashZiplist, rdbTypeHashListpack:
 		return "Hash"
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return "Stream"
 	default:
 		return fmt.Sprintf("Unknown (0x%02X)", valueType)
 	}
 }
 
-func (p *RDBParser) parseEOF() {
+// parseEOF checks the end marker and, from version 5 on, the CRC64 of
+// everything before the checksum. A zero checksum means the writer had
+// rdbchecksum disabled and is accepted as is.
+func (p *RDBParser) parseEOF() error {
 	fmt.Println("\n=== End of File ===")
-	
-	if p.pos < len(p.data) && p.data[p.pos] == 0xFF {
-		p.pos++ // Skip 0xFF
-		
-		if p.pos+8 <= len(p.data) {
-			checksum := p.data[p.pos : p.pos+8]
-			fmt.Printf("Checksum: %02X\n", checksum)
+
+	marker, err := p.readByte("EOF marker")
+	if err != nil {
+		return err
+	}
+	if marker != rdbOpEOF {
+		return &RDBError{Offset: p.offset() - 1, Reason: fmt.Sprintf("expected EOF marker, got 0x%02X", marker)}
+	}
+
+	if p.version < 5 {
+		return nil
+	}
+
+	body := p.offset()
+	actual := crc64Jones(p.crc, p.data[:p.pos])
+	checksum, err := p.readBytes(8, "checksum")
+	if err != nil {
+		return err
+	}
+
+	expected := binary.LittleEndian.Uint64(checksum)
+	fmt.Printf("Checksum: %016X\n", expected)
+	if expected == 0 {
+		return nil
+	}
+
+	if actual != expected {
+		return &RDBError{Offset: body, Reason: fmt.Sprintf("checksum mismatch: file has %016X, computed %016X", expected, actual)}
+	}
+	return nil
+}
+
+// readBlob reads a string holding one of the compact encodings and decodes it.
+func readBlob[T any](p *RDBParser, what string, decode func([]byte) (T, error)) (T, error) {
+	start := p.offset()
+	blob, err := p.readString()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	value, err := decode([]byte(blob))
+	if err != nil {
+		var zero T
+		return zero, &RDBError{Offset: start, Reason: fmt.Sprintf("bad %s: %v", what, err)}
+	}
+	return value, nil
+}
+
+// readZSet reads member/score pairs, with binary doubles for ZSET_2 and the
+// older length-prefixed text form otherwise.
+func (p *RDBParser) readZSet(binaryScores bool) (map[string]float64, error) {
+	length, err := p.readCount("sorted set")
+	if err != nil {
+		return nil, err
+	}
+	zset := make(map[string]float64, length)
+
+	for i := 0; i < length; i++ {
+		member, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		var score float64
+		if binaryScores {
+			b, err := p.readBytes(8, "score")
+			if err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		} else if score, err = p.readTextDouble(); err != nil {
+			return nil, err
+		}
+		zset[member] = score
+	}
+
+	return zset, nil
+}
+
+func (p *RDBParser) readTextDouble() (float64, error) {
+	n, err := p.readByte("score length")
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	b, err := p.readBytes(int(n), "score")
+	if err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, &RDBError{Offset: p.offset() - int(n), Reason: fmt.Sprintf("invalid score %q", b)}
+	}
+	return score, nil
+}
+
+func (p *RDBParser) readPackedZSet(decode func([]byte) ([]string, error)) (map[string]float64, error) {
+	start := p.offset()
+	entries, err := readBlob(p, "sorted set", decode)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries)%2 != 0 {
+		return nil, &RDBError{Offset: start, Reason: "sorted set with a member but no score"}
+	}
+
+	zset := make(map[string]float64, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		score, err := strconv.ParseFloat(entries[i+1], 64)
+		if err != nil {
+			return nil, &RDBError{Offset: start, Reason: fmt.Sprintf("invalid score %q", entries[i+1])}
+		}
+		zset[entries[i]] = score
+	}
+	return zset, nil
+}
+
+func (p *RDBParser) readPackedHash(decode func([]byte) ([]string, error)) (map[string]string, error) {
+	start := p.offset()
+	entries, err := readBlob(p, "hash", decode)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries)%2 != 0 {
+		return nil, &RDBError{Offset: start, Reason: "hash with a field but no value"}
+	}
+
+	hash := make(map[string]string, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		hash[entries[i]] = entries[i+1]
+	}
+	return hash, nil
+}
+
+// readQuicklist reads a list stored as ziplist nodes, or for quicklist 2 as
+// nodes that are either a listpack or a single plain element.
+func (p *RDBParser) readQuicklist(v2 bool) ([]string, error) {
+	nodes, err := p.readCount("quicklist")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []string
+	for i := 0; i < nodes; i++ {
+		container := uint64(quicklistNodePacked)
+		if v2 {
+			if container, err = p.readSize(); err != nil {
+				return nil, err
+			}
+		}
+
+		switch container {
+		case quicklistNodePlain:
+			element, err := p.readString()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, element)
+		case quicklistNodePacked:
+			decode := decodeZiplist
+			if v2 {
+				decode = decodeListpack
+			}
+			entries, err := readBlob(p, "quicklist node", decode)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, entries...)
+		default:
+			return nil, p.errorf("unknown quicklist container %d", container)
+		}
+	}
+
+	return list, nil
+}
+
+func (p *RDBParser) readRawStreamID() (streamID, error) {
+	b, err := p.readBytes(16, "stream id")
+	if err != nil {
+		return streamID{}, err
+	}
+	return streamID{ms: binary.BigEndian.Uint64(b[:8]), seq: binary.BigEndian.Uint64(b[8:])}, nil
+}
+
+func (p *RDBParser) readStreamID() (streamID, error) {
+	ms, err := p.readSize()
+	if err != nil {
+		return streamID{}, err
+	}
+	seq, err := p.readSize()
+	if err != nil {
+		return streamID{}, err
+	}
+	return streamID{ms: ms, seq: seq}, nil
+}
+
+func (p *RDBParser) readMillis(what string) (int64, error) {
+	b, err := p.readBytes(8, what)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+// readStream reads the listpack nodes of a stream followed by its metadata
+// and consumer groups. STREAM_LISTPACKS_2 added the first/max-deleted ids,
+// entries_added and entries_read; STREAM_LISTPACKS_3 the consumer active time.
+func (p *RDBParser) readStream(valueType byte) (*stream, error) {
+	nodes, err := p.readCount("stream")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stream{}
+	for i := 0; i < nodes; i++ {
+		keyOffset := p.offset()
+		key, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, &RDBError{Offset: keyOffset, Reason: fmt.Sprintf("stream node key is %d bytes, expected 16", len(key))}
+		}
+		master := streamID{ms: binary.BigEndian.Uint64([]byte(key[:8])), seq: binary.BigEndian.Uint64([]byte(key[8:]))}
+
+		nodeOffset := p.offset()
+		items, err := readBlob(p, "stream node", decodeListpack)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := decodeStreamNode(master, items)
+		if err != nil {
+			return nil, &RDBError{Offset: nodeOffset, Reason: err.Error()}
+		}
+		s.entries = append(s.entries, entries...)
+	}
+
+	if _, err := p.readSize(); err != nil { // length, implied by the entries
+		return nil, err
+	}
+	if s.lastID, err = p.readStreamID(); err != nil {
+		return nil, err
+	}
+
+	if valueType >= rdbTypeStreamListpacks2 {
+		if s.firstID, err = p.readStreamID(); err != nil {
+			return nil, err
+		}
+		if s.maxDeletedID, err = p.readStreamID(); err != nil {
+			return nil, err
+		}
+		if s.entriesAdded, err = p.readSize(); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(s.entries) > 0 {
+			s.firstID = s.entries[0].id
+		}
+		s.entriesAdded = uint64(len(s.entries))
+	}
+
+	groups, err := p.readCount("consumer groups")
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < groups; i++ {
+		group, err := p.readStreamGroup(valueType)
+		if err != nil {
+			return nil, err
+		}
+		s.groups = append(s.groups, group)
+	}
+
+	return s, nil
+}
+
+func (p *RDBParser) readStreamGroup(valueType byte) (streamGroup, error) {
+	var g streamGroup
+	var err error
+
+	if g.name, err = p.readString(); err != nil {
+		return g, err
+	}
+	if g.lastID, err = p.readStreamID(); err != nil {
+		return g, err
+	}
+	g.entriesRead = -1
+	if valueType >= rdbTypeStreamListpacks2 {
+		entriesRead, err := p.readSize()
+		if err != nil {
+			return g, err
+		}
+		g.entriesRead = int64(entriesRead)
+	}
+
+	pending, err := p.readCount("pending entries")
+	if err != nil {
+		return g, err
+	}
+	for i := 0; i < pending; i++ {
+		var pe streamPendingEntry
+		if pe.id, err = p.readRawStreamID(); err != nil {
+			return g, err
+		}
+		if pe.deliveryTime, err = p.readMillis("delivery time"); err != nil {
+			return g, err
+		}
+		if pe.deliveryCount, err = p.readSize(); err != nil {
+			return g, err
+		}
+		g.pending = append(g.pending, pe)
+	}
+
+	consumers, err := p.readCount("consumers")
+	if err != nil {
+		return g, err
+	}
+	for i := 0; i < consumers; i++ {
+		var c streamConsumer
+		if c.name, err = p.readString(); err != nil {
+			return g, err
+		}
+		if c.seenTime, err = p.readMillis("seen time"); err != nil {
+			return g, err
+		}
+		c.activeTime = c.seenTime
+		if valueType >= rdbTypeStreamListpacks3 {
+			if c.activeTime, err = p.readMillis("active time"); err != nil {
+				return g, err
+			}
+		}
+
+		// The consumer PEL points into the group PEL
+		owned, err := p.readCount("consumer pending entries")
+		if err != nil {
+			return g, err
+		}
+		for j := 0; j < owned; j++ {
+			idOffset := p.offset()
+			id, err := p.readRawStreamID()
+			if err != nil {
+				return g, err
+			}
+			k := slices.IndexFunc(g.pending, func(pe streamPendingEntry) bool { return pe.id == id })
+			if k < 0 {
+				return g, &RDBError{Offset: idOffset, Reason: fmt.Sprintf("consumer %q owns %s which is not pending", c.name, id)}
+			}
+			g.pending[k].consumer = c.name
+		}
+		g.consumers = append(g.consumers, c)
+	}
+
+	return g, nil
+}
+
+// decodeStreamNode turns the items of one stream listpack into entries. The
+// node starts with a master entry listing the fields most entries share:
+//
+//	count deleted num-fields field_1 ... field_N 0
+//
+// followed by entries, each ending with the number of items it spans:
+//
+//	flags ms-diff seq-diff [num-fields field_1 value_1 ...|value_1 ...] lp-count
+func decodeStreamNode(master streamID, items []string) ([]streamEntry, error) {
+	pos := 0
+	next := func() (string, error) {
+		if pos >= len(items) {
+			return "", errors.New("stream node ends mid-entry")
+		}
+		pos++
+		return items[pos-1], nil
+	}
+	nextInt := func() (int64, error) {
+		item, err := next()
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected integer in stream node, got %q", item)
 		}
+		return n, nil
 	}
-}
\ No newline at end of file
+
+	// count and deleted
+	if _, err := nextInt(); err != nil {
+		return nil, err
+	}
+	if _, err := nextInt(); err != nil {
+		return nil, err
+	}
+	numFields, err := nextInt()
+	if err != nil {
+		return nil, err
+	}
+	if numFields < 0 || numFields > int64(len(items)) {
+		return nil, fmt.Errorf("invalid master field count %d", numFields)
+	}
+	masterFields := make([]string, numFields)
+	for i := range masterFields {
+		if masterFields[i], err = next(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := next(); err != nil { // master entry terminator
+		return nil, err
+	}
+
+	var entries []streamEntry
+	for pos < len(items) {
+		flags, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := streamEntry{id: streamID{ms: master.ms + uint64(msDiff), seq: master.seq + uint64(seqDiff)}}
+		if flags&streamItemFlagSameFields != 0 {
+			for _, field := range masterFields {
+				value, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.fields = append(entry.fields, field, value)
+			}
+		} else {
+			n, err := nextInt()
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 || n > int64(len(items)) {
+				return nil, fmt.Errorf("invalid field count %d", n)
+			}
+			for i := int64(0); i < n*2; i++ {
+				item, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.fields = append(entry.fields, item)
+			}
+		}
+
+		if _, err := nextInt(); err != nil { // lp-count
+			return nil, err
+		}
+
+		if flags&streamItemFlagDeleted == 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// skipModuleValue skips a MODULE_2 value: the module id and then typed
+// fields up to the EOF opcode.
+func (p *RDBParser) skipModuleValue() error {
+	if _, err := p.readSize(); err != nil { // module id
+		return err
+	}
+	return p.skipModuleFields()
+}
+
+// skipModuleAux skips module aux data: module id, when-opcode, when and the
+// module's fields.
+func (p *RDBParser) skipModuleAux() error {
+	for i := 0; i < 3; i++ {
+		if _, err := p.readSize(); err != nil {
+			return err
+		}
+	}
+	return p.skipModuleFields()
+}
+
+func (p *RDBParser) skipModuleFields() error {
+	for {
+		opcode, err := p.readSize()
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case rdbModuleOpEOF:
+			return nil
+		case rdbModuleOpSInt, rdbModuleOpUInt:
+			_, err = p.readSize()
+		case rdbModuleOpFloat:
+			_, err = p.readBytes(4, "module float")
+		case rdbModuleOpDouble:
+			_, err = p.readBytes(8, "module double")
+		case rdbModuleOpString:
+			_, err = p.readString()
+		default:
+			return &RDBError{Offset: p.offset() - 1, Reason: fmt.Sprintf("unknown module opcode %d", opcode)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package radisa
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestRDB(t testing.TB, data map[string]Data) []byte {
+	var buf bytes.Buffer
+	if err := NewRDBWriter(&buf, true).Write(data); err != nil {
+		t.Fatalf("Failed to write RDB: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// codecrafters-dump.rdb is a copy of the dump.rdb at the root of the repo.
+// Its AUX fields name redis 7.2.6, but it was never checked against a
+// redis-server, and neither is what RDBWriter writes. abc's expire reads as
+// the year 57000 or so, most likely microseconds stored as milliseconds; it
+// is pinned as stored, which is what redis would load too.
+func TestRDBParser_CodecraftersDump(t *testing.T) {
+	file, err := os.ReadFile("testdata/codecrafters-dump.rdb")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	result, err := NewRDBParser(file).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if result["abc"].value != "foo" || !result["abc"].expire.Equal(time.UnixMilli(1752789600000000)) {
+		t.Errorf("Unexpected abc: %+v", result["abc"])
+	}
+	if result["apple"].value != "banana" || !result["apple"].expire.IsZero() {
+		t.Errorf("Unexpected apple: %+v", result["apple"])
+	}
+}
+
+func TestRDBParser_Truncated(t *testing.T) {
+	file := writeTestRDB(t, map[string]Data{"a": {value: "1"}, "b": {value: "hello"}})
+
+	for i := 0; i < len(file); i++ {
+		_, err := NewRDBParser(file[:i]).Parse()
+
+		var rdbErr *RDBError
+		if !errors.As(err, &rdbErr) {
+			t.Fatalf("Expected RDBError for %d bytes, got %v", i, err)
+		}
+		if rdbErr.Offset > i {
+			t.Errorf("Error offset %d is past the end of %d bytes", rdbErr.Offset, i)
+		}
+	}
+}
+
+func TestRDBParser_ChecksumMismatch(t *testing.T) {
+	file := writeTestRDB(t, map[string]Data{"foo": {value: "bar"}})
+	file[len(file)-12] ^= 0xFF // flip a byte in the value
+
+	_, err := NewRDBParser(file).Parse()
+
+	var rdbErr *RDBError
+	if !errors.As(err, &rdbErr) {
+		t.Fatalf("Expected RDBError, got %v", err)
+	}
+	if rdbErr.Offset != len(file)-8 {
+		t.Errorf("Expected offset %d, got %d", len(file)-8, rdbErr.Offset)
+	}
+}
+
+func TestRDBParser_ZeroChecksumSkipsVerification(t *testing.T) {
+	file := writeTestRDB(t, map[string]Data{"foo": {value: "bar"}})
+	copy(file[len(file)-8:], make([]byte, 8))
+
+	if _, err := NewRDBParser(file).Parse(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+}
+
+func TestRDBParser_KeepsKeysBeforeCorruption(t *testing.T) {
+	file := writeTestRDB(t, map[string]Data{"a": {value: "1"}, "b": {value: "2"}})
+	// Cut the file inside the last key-value pair
+	result, err := NewRDBParser(file[:len(file)-11]).Parse()
+
+	if err == nil {
+		t.Fatal("Expected error for truncated file")
+	}
+	if len(result) != 1 || result["a"].value != "1" {
+		t.Errorf("Expected only a=1, got %v", result)
+	}
+}
+
+func TestNewRadisa_CorruptPolicy(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestRDB(t, map[string]Data{"a": {value: "1"}, "b": {value: "2"}})
+	os.WriteFile(dir+"/dump.rdb", file[:len(file)-11], 0644)
+
+	config := DefaultConfig()
+	refused := NewRadisa(dir, "dump.rdb", 0, config)
+	if err := refused.Start(); err == nil {
+		t.Error("Expected Start to refuse a corrupt RDB")
+	}
+
+	config.RDBCorruptPolicy = RDBLoadValid
+	partial := NewRadisa(dir, "dump.rdb", 0, config)
+	if partial.loadErr != nil || partial.data["a"].value != "1" {
+		t.Errorf("Expected a=1 to be loaded, got %v (%v)", partial.data, partial.loadErr)
+	}
+}
+
+func FuzzRDBParser(f *testing.F) {
+	if file, err := os.ReadFile("testdata/codecrafters-dump.rdb"); err == nil {
+		f.Add(file)
+	}
+	f.Add(writeTestRDB(f, map[string]Data{
+		"str":  {value: "value", expire: time.Now().Add(time.Hour)},
+		"int":  {value: "-5000"},
+		"list": {kind: kindList, list: []string{"a", "b"}},
+		"set":  {kind: kindSet, list: []string{"x"}},
+		"hash": {kind: kindHash, hash: map[string]string{"f": "v"}},
+	}))
+	f.Add([]byte("REDIS0011\xff"))
+	f.Add(rdbWithValue(rdbTypeHashZiplist, "h", rdbString(testZiplist)))
+	f.Add(rdbWithValue(rdbTypeSetListpack, "s", rdbString(testListpack)))
+	f.Add(rdbWithValue(rdbTypeSetIntset, "i", rdbString(testIntset)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		result, err := NewRDBParser(data).Parse()
+		if result == nil {
+			t.Fatal("Expected a non-nil map")
+		}
+
+		var rdbErr *RDBError
+		if err != nil && !errors.As(err, &rdbErr) {
+			t.Fatalf("Expected RDBError, got %T: %v", err, err)
+		}
+		if rdbErr != nil && (rdbErr.Offset < 0 || rdbErr.Offset > len(data)) {
+			t.Fatalf("Offset %d out of range for %d bytes", rdbErr.Offset, len(data))
+		}
+	})
+}
+
+func TestRDBStreamParser_MatchesParser(t *testing.T) {
+	data := make(map[string]Data)
+	for i := range 5000 {
+		data[fmt.Sprintf("key:%d", i)] = Data{value: strings.Repeat(strconv.Itoa(i), 10)}
+	}
+	var buf bytes.Buffer
+	if err := NewRDBWriter(&buf, false).Write(data); err != nil {
+		t.Fatalf("Failed to write RDB: %v", err)
+	}
+	file := buf.Bytes()
+	if len(file) <= 2*rdbStreamCompact {
+		t.Fatalf("Expected an RDB larger than the stream buffer, got %d bytes", len(file))
+	}
+
+	// The parser stops right after the checksum, leaving what follows
+	src := bytes.NewReader(append(bytes.Clone(file), "tail"...))
+	result, err := NewRDBStreamParser(src).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(result) != len(data) || result["key:4999"].value != data["key:4999"].value {
+		t.Errorf("Expected %d keys, got %d", len(data), len(result))
+	}
+	if rest, _ := io.ReadAll(src); string(rest) != "tail" {
+		t.Errorf("Expected tail to be left unread, got %q", rest)
+	}
+
+	// The checksum still covers the bytes dropped from the buffer
+	i := bytes.Index(file, []byte(data["key:7"].value))
+	file[i] ^= 0xFF
+	_, err = NewRDBStreamParser(bytes.NewReader(file)).Parse()
+	var rdbErr *RDBError
+	if !errors.As(err, &rdbErr) || !strings.Contains(rdbErr.Reason, "checksum mismatch") {
+		t.Errorf("Expected checksum mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package radisa
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// infoSections lists the INFO sections in the order redis prints them.
+var infoSections = []struct {
+	name  string
+	lines func(r *Radisa) []string
+}{
+	{"persistence", (*Radisa).infoPersistence},
+	{"stats", (*Radisa).infoStats},
+	{"replication", (*Radisa).infoReplication},
+}
+
+// info builds the INFO reply. Without arguments, or with "default", "all"
+// or "everything", every section is included, otherwise only the named ones.
+// The caller holds mu.
+func (r *Radisa) info(args []string) string {
+	all := len(args) == 0
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		switch section := strings.ToLower(arg); section {
+		case "default", "all", "everything":
+			all = true
+		default:
+			wanted[section] = true
+		}
+	}
+
+	var sections []string
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		title := strings.ToUpper(section.name[:1]) + section.name[1:]
+		lines := append([]string{"# " + title}, section.lines(r)...)
+		sections = append(sections, strings.Join(lines, CRLF)+CRLF)
+	}
+	return strings.Join(sections, CRLF)
+}
+
+func (r *Radisa) infoPersistence() []string {
+	r.saveMu.Lock()
+	saving := r.saving
+	lastSave := r.lastSave
+	lastSaveErr := r.lastSaveErr
+	r.saveMu.Unlock()
+
+	aofRewriting := false
+	aofWriteErr := error(nil)
+	if r.aof != nil {
+		r.aof.mu.Lock()
+		aofRewriting = r.aof.rewriting
+		aofWriteErr = r.aof.err
+		r.aof.mu.Unlock()
+	}
+
+	return []string{
+		fmt.Sprintf("loading:%d", boolToInt(r.loading)),
+		fmt.Sprintf("rdb_changes_since_last_save:%d", r.dirty),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", boolToInt(saving)),
+		fmt.Sprintf("rdb_last_save_time:%d", lastSave.Unix()),
+		"rdb_last_bgsave_status:" + okOrErr(lastSaveErr),
+		fmt.Sprintf("aof_enabled:%d", boolToInt(r.aof != nil)),
+		fmt.Sprintf("aof_rewrite_in_progress:%d", boolToInt(aofRewriting)),
+		"aof_last_write_status:" + okOrErr(aofWriteErr),
+	}
+}
+
+func (r *Radisa) infoStats() []string {
+	return []string{
+		fmt.Sprintf("sync_full:%d", r.stats.syncFull),
+		fmt.Sprintf("sync_partial_ok:%d", r.stats.syncPartialOK),
+		fmt.Sprintf("sync_partial_err:%d", r.stats.syncPartialErr),
+	}
+}
+
+// infoReplication reports the fields failover tooling parses, in redis'
+// order and format.
+func (r *Radisa) infoReplication() []string {
+	var lines []string
+	if link := r.replicaOf; link != nil {
+		lastIO := int64(-1)
+		if nanos := link.lastIO.Load(); nanos != 0 {
+			lastIO = int64(time.Since(time.Unix(0, nanos)) / time.Second)
+		}
+		lines = append(lines,
+			"role:slave",
+			fmt.Sprintf("master_host:%s", link.masterHost),
+			fmt.Sprintf("master_port:%d", link.masterPort),
+			"master_link_status:"+linkStatus(link.linkUp),
+			fmt.Sprintf("master_last_io_seconds_ago:%d", lastIO),
+			fmt.Sprintf("master_sync_in_progress:%d", boolToInt(link.syncing)),
+			fmt.Sprintf("slave_read_repl_offset:%d", r.replOffset),
+			fmt.Sprintf("slave_repl_offset:%d", r.replOffset),
+		)
+	} else {
+		lines = append(lines, "role:master")
+	}
+
+	lines = append(lines, fmt.Sprintf("connected_slaves:%d", len(r.replicas)))
+	for i, replica := range r.replicas {
+		ip, _, _ := net.SplitHostPort(replica.client.conn.RemoteAddr().String())
+		lag := int64(time.Since(replica.ackTime) / time.Second)
+		lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d",
+			i, ip, replica.client.listeningPort, replica.state, replica.ackOffset, lag))
+	}
+
+	lines = append(lines,
+		"master_replid:"+r.replID,
+		"master_replid2:"+r.replID2,
+		fmt.Sprintf("master_repl_offset:%d", r.replOffset),
+		fmt.Sprintf("second_repl_offset:%d", r.secondReplOffset),
+		fmt.Sprintf("repl_backlog_active:%d", boolToInt(r.backlog != nil)),
+		fmt.Sprintf("repl_backlog_size:%d", r.config.ReplBacklogSize),
+	)
+	if r.backlog != nil {
+		return append(lines,
+			fmt.Sprintf("repl_backlog_first_byte_offset:%d", r.backlog.offset),
+			fmt.Sprintf("repl_backlog_histlen:%d", r.backlog.histlen),
+		)
+	}
+	return append(lines, "repl_backlog_first_byte_offset:0", "repl_backlog_histlen:0")
+}
+
+func linkStatus(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
+
+func okOrErr(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
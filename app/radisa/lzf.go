@@ -0,0 +1,203 @@
+package radisa
+
+import "errors"
+
+// LZF as used by redis for RDB strings, ported from liblzf's lzf_c.c and
+// lzf_d.c with redis's settings (HLOG 16, VERY_FAST).
+const (
+	lzfHashLog = 16
+	lzfMaxLit  = 1 << 5
+	lzfMaxOff  = 1 << 13
+	lzfMaxRef  = (1 << 8) + (1 << 3)
+
+	// lzfMaxRatio bounds how much a stream can expand: a 3 byte back
+	// reference produces at most lzfMaxRef+1 bytes.
+	lzfMaxRatio = (lzfMaxRef + 1 + 2) / 3
+)
+
+var errLZFCorrupt = errors.New("corrupt LZF data")
+
+// lzfDecompress expands in into exactly outLen bytes.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	ip := 0
+
+	for ip < len(in) {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < 1<<5 { // literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if ip+n > len(in) || len(out)+n > outLen {
+				return nil, errLZFCorrupt
+			}
+			out = append(out, in[ip:ip+n]...)
+			ip += n
+			continue
+		}
+
+		// back reference
+		length := ctrl >> 5
+		ref := len(out) - ((ctrl & 0x1f) << 8) - 1
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errLZFCorrupt
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errLZFCorrupt
+		}
+		ref -= int(in[ip])
+		ip++
+		length += 2
+
+		if ref < 0 || len(out)+length > outLen {
+			return nil, errLZFCorrupt
+		}
+		// byte by byte, the reference may overlap what it produces
+		for i := 0; i < length; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, errLZFCorrupt
+	}
+	return out, nil
+}
+
+// lzfCompress returns the compressed form of in, or nil if it doesn't fit in
+// maxLen bytes.
+func lzfCompress(in []byte, maxLen int) []byte {
+	if len(in) == 0 || maxLen <= 0 {
+		return nil
+	}
+
+	var htab [1 << lzfHashLog]int32
+	out := make([]byte, 1, maxLen+1) // out[0] is the first literal run header
+	lit := 0
+	ip := 0
+	inEnd := len(in)
+
+	first := func(p int) uint32 { return uint32(in[p])<<8 | uint32(in[p+1]) }
+	next := func(v uint32, p int) uint32 { return v<<8 | uint32(in[p+2]) }
+	idx := func(h uint32) uint32 { return ((h >> (3*8 - lzfHashLog)) - h*5) & (1<<lzfHashLog - 1) }
+
+	// startRun reserves the header byte of a new literal run, endRun fills
+	// it in or drops it again if the run stayed empty.
+	endRun := func() {
+		if lit == 0 {
+			out = out[:len(out)-1]
+		} else {
+			out[len(out)-lit-1] = byte(lit - 1)
+		}
+	}
+	startRun := func() {
+		lit = 0
+		out = append(out, 0)
+	}
+
+	var hval uint32
+	if inEnd > 2 {
+		hval = first(ip)
+	}
+	for ip < inEnd-2 {
+		hval = next(hval, ip)
+		slot := idx(hval)
+		ref := int(htab[slot])
+		htab[slot] = int32(ip)
+
+		off := ip - ref - 1
+		if ref > 0 && off < lzfMaxOff && in[ref+2] == in[ip+2] && in[ref] == in[ip] && in[ref+1] == in[ip+1] {
+			length := 2
+			maxRef := inEnd - ip - length
+			if maxRef > lzfMaxRef {
+				maxRef = lzfMaxRef
+			}
+
+			if len(out)+3+1 >= maxLen && len(out)-boolToInt(lit == 0)+3+1 >= maxLen {
+				return nil
+			}
+
+			endRun()
+
+			for {
+				length++
+				if length >= maxRef || in[ref+length] != in[ip+length] {
+					break
+				}
+			}
+
+			length -= 2 // length is now #octets - 1
+			ip++
+
+			if length < 7 {
+				out = append(out, byte(off>>8+length<<5))
+			} else {
+				out = append(out, byte(off>>8+7<<5), byte(length-7))
+			}
+			out = append(out, byte(off))
+
+			startRun()
+
+			ip += length + 1
+			if ip >= inEnd-2 {
+				break
+			}
+
+			ip -= 2
+			hval = first(ip)
+			hval = next(hval, ip)
+			htab[idx(hval)] = int32(ip)
+			ip++
+			hval = next(hval, ip)
+			htab[idx(hval)] = int32(ip)
+			ip++
+		} else {
+			// one more literal byte we must copy
+			if len(out) >= maxLen {
+				return nil
+			}
+
+			lit++
+			out = append(out, in[ip])
+			ip++
+
+			if lit == lzfMaxLit {
+				endRun()
+				startRun()
+			}
+		}
+	}
+
+	if len(out)+3 > maxLen {
+		return nil
+	}
+
+	for ip < inEnd {
+		lit++
+		out = append(out, in[ip])
+		ip++
+
+		if lit == lzfMaxLit {
+			endRun()
+			startRun()
+		}
+	}
+
+	endRun()
+
+	if len(out) > maxLen {
+		return nil
+	}
+	return out
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
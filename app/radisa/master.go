@@ -0,0 +1,459 @@
+package radisa
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// replicaOutputLimit is client-output-buffer-limit's hard limit for
+	// replicas. A replica that falls this far behind is disconnected.
+	replicaOutputLimit = 256 << 20
+	// replPingPeriod matches repl-ping-replica-period.
+	replPingPeriod = 10 * time.Second
+	// zeroReplID stands for no replication history.
+	zeroReplID = "0000000000000000000000000000000000000000"
+	// rdbEOFMarkLen is the length of the mark around a diskless snapshot.
+	rdbEOFMarkLen = 40
+)
+
+var errNoReplicasLeft = errors.New("every replica of the transfer is gone")
+
+// newReplID returns 40 random hex characters, like a master picks at boot.
+func newReplID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// replicaClient is a replica that asked for PSYNC and gets every write.
+type replicaClient struct {
+	client *client
+	// out queues the writes made after the replica's snapshot was taken. It
+	// is nil while the replica waits for a diskless transfer to start.
+	out *clientOutput
+	// state is "wait_bgsave" while the snapshot is sent, then "online"
+	state string
+	// ackOffset and ackTime come from the replica's REPLCONF ACK
+	ackOffset int64
+	ackTime   time.Time
+}
+
+// replconf records what a replica tells about itself before PSYNC and the
+// offsets it acknowledges afterwards.
+func (r *Radisa) replconf(c *client, cmd *Command) []byte {
+	if len(cmd.Args) == 0 || len(cmd.Args)%2 != 0 {
+		return FormatError("syntax error")
+	}
+
+	for i := 0; i < len(cmd.Args); i += 2 {
+		value := cmd.Args[i+1]
+		switch strings.ToLower(cmd.Args[i]) {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return FormatError("value is not an integer or out of range")
+			}
+			c.listeningPort = port
+		case "ack":
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil
+			}
+			r.mu.Lock()
+			if replica := r.replicaFor(c); replica != nil {
+				replica.ackOffset = offset
+				replica.ackTime = time.Now()
+				r.notifyAck()
+			}
+			r.mu.Unlock()
+			// Acks are never answered
+			return nil
+		case "capa":
+			if strings.EqualFold(value, "psync2") {
+				c.capaPSync2 = true
+			}
+		case "ip-address", "fack":
+			// Nothing to negotiate yet
+		default:
+			return FormatError(fmt.Sprintf("Unrecognized REPLCONF option: %s", cmd.Args[i]))
+		}
+	}
+	return FormatSimpleString("OK")
+}
+
+// close drops the connection of the replica.
+func (replica *replicaClient) close() {
+	if replica.out != nil {
+		replica.out.close()
+	} else {
+		replica.client.conn.Close()
+	}
+}
+
+// syncReplica answers PSYNC. A replica that asks to continue a history this
+// server knows, from an offset still in the backlog, gets +CONTINUE and just
+// the missing bytes. Everyone else gets a full resync: +FULLRESYNC, then the
+// snapshot as "$<length>\r\n<rdb>" without a trailing CRLF, then the stream
+// of writes. The replica is registered in the same critical section the
+// snapshot is taken in, so every write after the snapshot is queued for it
+// while the RDB is still being sent. With repl-diskless-sync the snapshot is
+// streamed instead, see disklessSync.
+func (r *Radisa) syncReplica(c *client, cmd *Command) {
+	if len(cmd.Args) != 2 {
+		c.conn.Write(FormatError("wrong number of arguments for 'psync' command"))
+		return
+	}
+
+	r.mu.Lock()
+	// A replica serves its own replicas the stream it gets from its master,
+	// which it can't while disconnected
+	if link := r.replicaOf; link != nil && !link.linkUp {
+		r.mu.Unlock()
+		c.conn.Write(FormatErrorCode("NOMASTERLINK", "Can't SYNC while not connected with my master"))
+		return
+	}
+
+	if r.tryPartialResync(c, cmd.Args[0], cmd.Args[1]) {
+		r.stats.syncPartialOK++
+		r.mu.Unlock()
+		fmt.Printf("Partial resynchronization request from %s accepted\n", c.conn.RemoteAddr())
+		return
+	}
+
+	// The offset only counted what went into a backlog, so a history without
+	// one can't be continued by anyone: start a new one
+	if r.backlog == nil {
+		r.replID = newReplID()
+		r.replID2 = zeroReplID
+		r.secondReplOffset = -1
+		r.backlog = newReplBacklog(r.config.ReplBacklogSize, r.replOffset+1)
+	}
+
+	if cmd.Args[0] != "?" {
+		r.stats.syncPartialErr++
+	}
+	r.stats.syncFull++
+
+	replica := &replicaClient{
+		client:  c,
+		state:   "wait_bgsave",
+		ackTime: time.Now(),
+	}
+	r.replicas = append(r.replicas, replica)
+	if r.config.ReplDisklessSync {
+		r.scheduleDisklessSync(replica)
+		r.mu.Unlock()
+		fmt.Printf("Replica %s asks for synchronization, waiting for a diskless transfer\n", c.conn.RemoteAddr())
+		return
+	}
+
+	snap := r.snapshot()
+	replica.out = newClientOutput(c.conn, replicaOutputLimit)
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n", r.replID, r.replOffset)
+	compress := r.config.RDBCompression
+	r.mu.Unlock()
+
+	fmt.Printf("Replica %s asks for synchronization, starting full resync\n", c.conn.RemoteAddr())
+	if _, err := c.conn.Write([]byte(header)); err != nil {
+		r.removeReplica(c)
+		return
+	}
+
+	var rdb bytes.Buffer
+	if err := NewRDBWriter(&rdb, compress).Write(snap.copy(r)); err != nil {
+		fmt.Printf("Failed to encode RDB for replica: %v\n", err)
+		r.removeReplica(c)
+		c.conn.Close()
+		return
+	}
+	payload := append([]byte("$"+strconv.Itoa(rdb.Len())+CRLF), rdb.Bytes()...)
+	if _, err := c.conn.Write(payload); err != nil {
+		r.removeReplica(c)
+		return
+	}
+
+	r.mu.Lock()
+	replica.state = "online"
+	r.mu.Unlock()
+	replica.out.start()
+	fmt.Printf("Synchronization with replica %s succeeded\n", c.conn.RemoteAddr())
+}
+
+// scheduleDisklessSync queues replica for the next diskless transfer. The
+// first replica to wait starts the repl-diskless-sync-delay window, so the
+// replicas that show up during it are served by the same transfer. The
+// caller holds mu.
+func (r *Radisa) scheduleDisklessSync(replica *replicaClient) {
+	if len(r.disklessWaiting) == 0 {
+		time.AfterFunc(time.Duration(r.config.ReplDisklessSyncDelay)*time.Second, r.disklessSync)
+	}
+	r.disklessWaiting = append(r.disklessWaiting, replica)
+}
+
+// disklessSync takes one snapshot for every waiting replica and encodes it
+// straight into their sockets, as "$EOF:<mark>\r\n<rdb><mark>" since the
+// length isn't known up front. The mark is 40 random characters a replica
+// watches for to find the end.
+func (r *Radisa) disklessSync() {
+	r.mu.Lock()
+	replicas := slices.DeleteFunc(r.disklessWaiting, func(replica *replicaClient) bool {
+		return !slices.Contains(r.replicas, replica)
+	})
+	r.disklessWaiting = nil
+	if len(replicas) == 0 {
+		r.mu.Unlock()
+		return
+	}
+
+	snap := r.snapshot()
+	for _, replica := range replicas {
+		replica.out = newClientOutput(replica.client.conn, replicaOutputLimit)
+	}
+	mark := newReplID()
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n$EOF:%s\r\n", r.replID, r.replOffset, mark)
+	compress := r.config.RDBCompression
+	r.mu.Unlock()
+
+	fmt.Printf("Starting diskless transfer to %d replicas\n", len(replicas))
+	fanout := &replicaFanout{replicas: replicas}
+	w := bufio.NewWriter(fanout)
+	w.WriteString(header)
+	err := NewRDBWriter(w, compress).Write(snap.copy(r))
+	if err == nil {
+		w.WriteString(mark)
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Printf("Diskless transfer failed: %v\n", err)
+		fanout.replicas = nil
+	}
+
+	for _, replica := range replicas {
+		if !slices.Contains(fanout.replicas, replica) {
+			r.removeReplica(replica.client)
+			continue
+		}
+		r.mu.Lock()
+		replica.state = "online"
+		r.mu.Unlock()
+		replica.out.start()
+		fmt.Printf("Synchronization with replica %s succeeded\n", replica.client.conn.RemoteAddr())
+	}
+}
+
+// replicaFanout writes a diskless transfer to every replica in turn and
+// leaves out those whose connection failed, so they don't stop the rest.
+type replicaFanout struct {
+	replicas []*replicaClient
+}
+
+func (f *replicaFanout) Write(p []byte) (int, error) {
+	f.replicas = slices.DeleteFunc(f.replicas, func(replica *replicaClient) bool {
+		_, err := replica.client.conn.Write(p)
+		return err != nil
+	})
+	if len(f.replicas) == 0 {
+		return 0, errNoReplicasLeft
+	}
+	return len(p), nil
+}
+
+// tryPartialResync serves PSYNC <replid> <offset> from the backlog when
+// replid is our history, or the one we took over from a former master up to
+// where we diverged, and offset is still in the backlog. The caller holds mu.
+func (r *Radisa) tryPartialResync(c *client, replID string, offsetArg string) bool {
+	offset, err := strconv.ParseInt(offsetArg, 10, 64)
+	if err != nil || r.backlog == nil {
+		return false
+	}
+	if replID != r.replID && (replID != r.replID2 || offset > r.secondReplOffset) {
+		return false
+	}
+
+	missing, ok := r.backlog.readFrom(offset)
+	if !ok {
+		return false
+	}
+
+	// Replicas that know psync2 learn the current replid, which changes after
+	// a failover
+	reply := "+CONTINUE" + CRLF
+	if c.capaPSync2 {
+		reply = "+CONTINUE " + r.replID + CRLF
+	}
+
+	replica := &replicaClient{
+		client:  c,
+		out:     newClientOutput(c.conn, replicaOutputLimit),
+		state:   "online",
+		ackTime: time.Now(),
+	}
+	replica.out.write([]byte(reply))
+	replica.out.write(missing)
+	replica.out.start()
+	r.replicas = append(r.replicas, replica)
+	return true
+}
+
+// replicaFor returns the replicaClient of c, nil if c isn't a replica. The
+// caller holds mu.
+func (r *Radisa) replicaFor(c *client) *replicaClient {
+	for _, replica := range r.replicas {
+		if replica.client == c {
+			return replica
+		}
+	}
+	return nil
+}
+
+// disconnectReplicas drops every replica, so they reconnect and find out
+// about a change of replid or master. The caller holds mu.
+func (r *Radisa) disconnectReplicas() {
+	for _, replica := range r.replicas {
+		replica.close()
+	}
+	r.replicas = nil
+}
+
+func (r *Radisa) removeReplica(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.replicas = slices.DeleteFunc(r.replicas, func(replica *replicaClient) bool {
+		if replica.client != c {
+			return false
+		}
+		replica.close()
+		return true
+	})
+}
+
+// feedReplicas sends cmd down the replication stream. Like redis, the offset
+// only starts moving once the first replica created the backlog. A
+// replica's stream is its master's instead, see applyMasterStream. The
+// caller holds mu.
+func (r *Radisa) feedReplicas(cmd *Command) {
+	if r.backlog == nil || r.replicaOf != nil {
+		return
+	}
+	r.feedReplicationStream(FormatCommand(cmd))
+}
+
+// feedReplicationStream appends b to the backlog, advances the offset and
+// queues b for every replica. The caller holds mu.
+func (r *Radisa) feedReplicationStream(b []byte) {
+	r.backlog.write(b)
+	r.replOffset += int64(len(b))
+	for _, replica := range r.replicas {
+		// Replicas waiting for a diskless transfer get these in its snapshot
+		if replica.out != nil {
+			replica.out.write(b)
+		}
+	}
+}
+
+// notifyAck wakes the clients blocked in WAIT. The caller holds mu.
+func (r *Radisa) notifyAck() {
+	if r.ackSignal != nil {
+		close(r.ackSignal)
+		r.ackSignal = nil
+	}
+}
+
+// ackedReplicas counts the replicas that acknowledged offset. The caller
+// holds mu.
+func (r *Radisa) ackedReplicas(offset int64) int {
+	n := 0
+	for _, replica := range r.replicas {
+		if replica.state == "online" && replica.ackOffset >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// wait implements WAIT numreplicas timeout: it blocks until numreplicas
+// replicas acknowledged every write c made, or timeout milliseconds passed
+// (0 waits forever), and returns how many did. The replicas are asked for an
+// ack right away instead of waiting for their next periodic one.
+func (r *Radisa) wait(c *client, cmd *Command) []byte {
+	if len(cmd.Args) != 2 {
+		return FormatError("wrong number of arguments for 'wait' command")
+	}
+	numReplicas, err := strconv.Atoi(cmd.Args[0])
+	if err != nil {
+		return FormatError("value is not an integer or out of range")
+	}
+	timeoutMs, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		return FormatError("timeout is not an integer or out of range")
+	}
+	if timeoutMs < 0 {
+		return FormatError("timeout is negative")
+	}
+
+	var timeout <-chan time.Time
+	if timeoutMs > 0 {
+		timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.replicaOf != nil {
+		return FormatError("WAIT cannot be used with replica instances")
+	}
+
+	askedForAck := false
+	for {
+		acked := r.ackedReplicas(c.woff)
+		if acked >= numReplicas {
+			return FormatInteger(int64(acked))
+		}
+		if !askedForAck {
+			r.feedReplicas(&Command{Name: "REPLCONF", Args: []string{"GETACK", "*"}})
+			askedForAck = true
+		}
+
+		if r.ackSignal == nil {
+			r.ackSignal = make(chan struct{})
+		}
+		signal := r.ackSignal
+
+		r.mu.Unlock()
+		select {
+		case <-signal:
+			r.mu.Lock()
+		case <-timeout:
+			r.mu.Lock()
+			return FormatInteger(int64(r.ackedReplicas(c.woff)))
+		}
+	}
+}
+
+// replicationCron PINGs replicas so they can tell an idle master from a
+// dead one.
+func (r *Radisa) replicationCron() {
+	ticker := time.NewTicker(replPingPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.mu.Lock()
+		if len(r.replicas) > 0 {
+			r.feedReplicas(&Command{Name: "PING"})
+		}
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,498 @@
+package radisa
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveForTest accepts connections for r on an ephemeral port until the test
+// ends and returns the port.
+func serveForTest(t *testing.T, r *Radisa) int {
+	t.Helper()
+	l := listenForTest(t)
+	go acceptForTest(l, r)
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func listenForTest(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func acceptForTest(l net.Listener, r *Radisa) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go r.handleConnection(conn)
+	}
+}
+
+// startTestReplica follows the master on masterPort until the test ends.
+func startTestReplica(t *testing.T, masterPort int) *Radisa {
+	t.Helper()
+	return startTestReplicaWithConfig(t, masterPort, DefaultConfig())
+}
+
+func startTestReplicaWithConfig(t *testing.T, masterPort int, config Config) *Radisa {
+	t.Helper()
+	l := listenForTest(t)
+	replica := NewReplica(t.TempDir(), "dump.rdb", l.Addr().(*net.TCPAddr).Port, fmt.Sprintf("127.0.0.1 %d", masterPort), config)
+	go acceptForTest(l, replica)
+	go replica.replicate(replica.replicaOf)
+	t.Cleanup(func() {
+		replica.mu.Lock()
+		if replica.replicaOf != nil && replica.replicaOf.conn != nil {
+			replica.replicaOf.conn.Close()
+		}
+		replica.replicaOf = nil
+		replica.mu.Unlock()
+	})
+	return replica
+}
+
+func replicaValue(r *Radisa, key string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	value, ok := r.data[key]
+	return value.value, ok
+}
+
+func TestMaster_FullResyncAndPropagation(t *testing.T) {
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	master.executeCommand(&Command{Name: "SET", Args: []string{"before", "sync"}})
+	port := serveForTest(t, master)
+
+	replica := startTestReplica(t, port)
+	waitFor(t, "full resync", func() bool {
+		value, _ := replicaValue(replica, "before")
+		return value == "sync"
+	})
+
+	master.executeCommand(&Command{Name: "SET", Args: []string{"after", "sync"}})
+	master.executeCommand(&Command{Name: "SET", Args: []string{"gone", "soon"}})
+	master.executeCommand(&Command{Name: "DEL", Args: []string{"gone"}})
+	master.executeCommand(&Command{Name: "EXPIRE", Args: []string{"after", "100"}})
+	master.executeCommand(&Command{Name: "SET", Args: []string{"last", "write"}})
+
+	waitFor(t, "propagated writes", func() bool {
+		value, _ := replicaValue(replica, "last")
+		return value == "write"
+	})
+	if _, ok := replicaValue(replica, "gone"); ok {
+		t.Errorf("Expected DEL to reach the replica")
+	}
+
+	replica.mu.RLock()
+	masterDeadline := master.data["after"].expire.UnixMilli()
+	if replica.data["after"].expire.UnixMilli() != masterDeadline {
+		t.Errorf("Expected expiry %d, got %d", masterDeadline, replica.data["after"].expire.UnixMilli())
+	}
+	replica.mu.RUnlock()
+
+	master.mu.RLock()
+	if len(master.replicas) != 1 || master.replicas[0].client.listeningPort != replica.Port {
+		t.Errorf("Expected one replica listening on %d, got %v", replica.Port, master.replicas)
+	}
+	master.mu.RUnlock()
+}
+
+func TestMaster_ExpirationPropagatesAsDEL(t *testing.T) {
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	replica := startTestReplica(t, serveForTest(t, master))
+	waitFor(t, "replica to attach", func() bool {
+		master.mu.RLock()
+		defer master.mu.RUnlock()
+		return len(master.replicas) == 1
+	})
+
+	master.executeCommand(&Command{Name: "SET", Args: []string{"short", "lived", "PX", "20"}})
+	waitFor(t, "SET to reach the replica", func() bool {
+		_, ok := replicaValue(replica, "short")
+		return ok
+	})
+
+	time.Sleep(30 * time.Millisecond)
+	master.executeCommand(&Command{Name: "GET", Args: []string{"short"}})
+	waitFor(t, "DEL to reach the replica", func() bool {
+		_, ok := replicaValue(replica, "short")
+		return !ok
+	})
+}
+
+func TestMaster_PSYNCReply(t *testing.T) {
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	master.executeCommand(&Command{Name: "SET", Args: []string{"k", "v"}})
+	port := serveForTest(t, master)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	conn.Write(FormatCommand(&Command{Name: "REPLCONF", Args: []string{"listening-port", "6380"}}))
+	if line, _ := reader.ReadString('\n'); line != "+OK\r\n" {
+		t.Errorf("Expected +OK, got %q", line)
+	}
+
+	conn.Write(FormatCommand(&Command{Name: "PSYNC", Args: []string{"?", "-1"}}))
+	line, _ := reader.ReadString('\n')
+	if line != "+FULLRESYNC "+master.replID+" 0\r\n" {
+		t.Errorf("Expected FULLRESYNC, got %q", line)
+	}
+
+	payload, err := readRDBPayload(reader)
+	if err != nil {
+		t.Fatalf("Expected RDB payload, got: %v", err)
+	}
+	data, err := NewRDBParser(payload).Parse()
+	if err != nil || data["k"].value != "v" {
+		t.Errorf("Expected snapshot with k=v, got %v (%v)", data, err)
+	}
+
+	master.executeCommand(&Command{Name: "SET", Args: []string{"k", "w"}})
+	cmd, err := NewRESPReader(reader).ReadCommand()
+	if err != nil || !reflect.DeepEqual(append([]string{cmd.Name}, cmd.Args...), []string{"SET", "k", "w"}) {
+		t.Errorf("Expected SET k w in the stream, got %v (%v)", cmd, err)
+	}
+}
+
+func TestServer_DEL_EXPIRE_Commands(t *testing.T) {
+	server := createTestServer()
+	server.executeCommand(&Command{Name: "SET", Args: []string{"a", "1"}})
+	server.executeCommand(&Command{Name: "SET", Args: []string{"b", "2"}})
+
+	if got := string(server.executeCommand(&Command{Name: "DEL", Args: []string{"a", "b", "c"}})); got != ":2\r\n" {
+		t.Errorf("Expected :2, got %q", got)
+	}
+
+	server.executeCommand(&Command{Name: "SET", Args: []string{"a", "1"}})
+	if got := string(server.executeCommand(&Command{Name: "EXPIRE", Args: []string{"a", "100"}})); got != ":1\r\n" {
+		t.Errorf("Expected :1, got %q", got)
+	}
+	if got := string(server.executeCommand(&Command{Name: "EXPIRE", Args: []string{"missing", "100"}})); got != ":0\r\n" {
+		t.Errorf("Expected :0, got %q", got)
+	}
+	if got := string(server.executeCommand(&Command{Name: "EXPIRE", Args: []string{"a", "x"}})); !strings.HasPrefix(got, "-ERR") {
+		t.Errorf("Expected error, got %q", got)
+	}
+	if got := string(server.executeCommand(&Command{Name: "EXPIRE", Args: []string{"a", "-1"}})); got != ":1\r\n" {
+		t.Errorf("Expected :1, got %q", got)
+	}
+	if got := string(server.executeCommand(&Command{Name: "GET", Args: []string{"a"}})); got != "$-1\r\n" {
+		t.Errorf("Expected key to be gone, got %q", got)
+	}
+}
+
+func TestServer_INFO_Replication(t *testing.T) {
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	info := string(master.executeCommand(&Command{Name: "INFO", Args: []string{"replication"}}))
+
+	if !regexp.MustCompile(`\r\nmaster_replid:[0-9a-f]{40}\r\n`).MatchString(info) {
+		t.Errorf("Expected a 40 character replid, got %q", info)
+	}
+	for _, line := range []string{"# Replication", "role:master", "connected_slaves:0", "master_repl_offset:0", "second_repl_offset:-1"} {
+		if !strings.Contains(info, line+"\r\n") {
+			t.Errorf("Expected %q in %q", line, info)
+		}
+	}
+	if strings.Contains(info, "# Persistence") {
+		t.Errorf("Expected only the replication section, got %q", info)
+	}
+
+	other := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	if other.replID == master.replID {
+		t.Errorf("Expected every boot to pick a new replid")
+	}
+
+	all := string(master.executeCommand(&Command{Name: "INFO"}))
+	if !strings.Contains(all, "# Persistence\r\n") || !strings.Contains(all, "# Replication\r\n") {
+		t.Errorf("Expected every section, got %q", all)
+	}
+}
+
+func TestServer_INFO_ReplicaFields(t *testing.T) {
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	replica := startTestReplica(t, serveForTest(t, master))
+	waitFor(t, "replica to sync", func() bool {
+		return strings.Contains(string(replica.executeCommand(&Command{Name: "INFO", Args: []string{"replication"}})), "master_link_status:up")
+	})
+
+	master.executeCommand(&Command{Name: "SET", Args: []string{"k", "v"}})
+	master.mu.RLock()
+	offset := master.replOffset
+	master.mu.RUnlock()
+
+	// The replica acks once a second
+	expected := fmt.Sprintf("slave0:ip=127.0.0.1,port=%d,state=online,offset=%d,lag=", replica.Port, offset)
+	waitFor(t, "replica ack", func() bool {
+		return strings.Contains(string(master.executeCommand(&Command{Name: "INFO", Args: []string{"replication"}})), expected)
+	})
+
+	info := string(replica.executeCommand(&Command{Name: "INFO", Args: []string{"replication"}}))
+	for _, line := range []string{
+		"role:slave",
+		"master_host:127.0.0.1",
+		"master_sync_in_progress:0",
+		fmt.Sprintf("slave_repl_offset:%d", offset),
+		"master_replid:" + master.replID,
+		fmt.Sprintf("master_repl_offset:%d", offset),
+	} {
+		if !strings.Contains(info, line+"\r\n") {
+			t.Errorf("Expected %q in %q", line, info)
+		}
+	}
+}
+
+func TestMaster_PartialResyncAfterDisconnect(t *testing.T) {
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	replica := startTestReplica(t, serveForTest(t, master))
+	waitFor(t, "full resync", func() bool {
+		master.mu.RLock()
+		defer master.mu.RUnlock()
+		return len(master.replicas) == 1 && master.replicas[0].state == "online"
+	})
+	master.executeCommand(&Command{Name: "SET", Args: []string{"before", "blip"}})
+	waitFor(t, "first write", func() bool {
+		value, _ := replicaValue(replica, "before")
+		return value == "blip"
+	})
+
+	// Drop the link from the master's side and write while it is down
+	master.mu.Lock()
+	master.replicas[0].out.close()
+	master.mu.Unlock()
+	waitFor(t, "master to notice", func() bool {
+		master.mu.RLock()
+		defer master.mu.RUnlock()
+		return len(master.replicas) == 0
+	})
+	master.executeCommand(&Command{Name: "SET", Args: []string{"during", "blip"}})
+
+	waitFor(t, "partial resync", func() bool {
+		value, _ := replicaValue(replica, "during")
+		return value == "blip"
+	})
+
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	if master.stats.syncFull != 1 || master.stats.syncPartialOK != 1 {
+		t.Errorf("Expected one full and one partial sync, got %+v", master.stats)
+	}
+	replica.mu.RLock()
+	defer replica.mu.RUnlock()
+	if replica.replOffset != master.replOffset {
+		t.Errorf("Expected offset %d, got %d", master.replOffset, replica.replOffset)
+	}
+}
+
+func TestMaster_PartialResyncRules(t *testing.T) {
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	master.config.ReplBacklogSize = 64
+	master.backlog = newReplBacklog(64, 1)
+	master.replID2 = "old"
+	for i := 0; i < 10; i++ {
+		master.feedReplicas(&Command{Name: "SET", Args: []string{"k", "v"}})
+	}
+	last := master.replOffset + 1
+	firstKept := master.backlog.offset
+
+	cases := []struct {
+		replID string
+		offset int64
+		ok     bool
+	}{
+		{master.replID, last, true},
+		{master.replID, firstKept, true},
+		{master.replID, firstKept - 1, false},
+		{master.replID, last + 1, false},
+		{"unknown", last, false},
+		{"old", last, false},
+	}
+	for _, tc := range cases {
+		c := &client{conn: &net.TCPConn{}}
+		if got := master.tryPartialResync(c, tc.replID, strconv.FormatInt(tc.offset, 10)); got != tc.ok {
+			t.Errorf("Expected %v for %s at %d, got %v", tc.ok, tc.replID, tc.offset, got)
+		}
+	}
+
+	// The previous history is accepted up to where it ended
+	master.secondReplOffset = last
+	if !master.tryPartialResync(&client{conn: &net.TCPConn{}}, "old", strconv.FormatInt(last, 10)) {
+		t.Errorf("Expected replid2 to be continued up to second_repl_offset")
+	}
+}
+
+// dialForTest connects a plain client to port.
+func dialForTest(t *testing.T, port int) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+// roundTrip sends a command and returns the first line of the reply.
+func roundTrip(t *testing.T, conn net.Conn, reader *bufio.Reader, args ...string) string {
+	t.Helper()
+	conn.Write(FormatCommand(&Command{Name: args[0], Args: args[1:]}))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Expected a reply to %v, got: %v", args, err)
+	}
+	return line
+}
+
+func TestMaster_WAIT(t *testing.T) {
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	port := serveForTest(t, master)
+	var replicas []*Radisa
+	for i := 0; i < 3; i++ {
+		replicas = append(replicas, startTestReplica(t, port))
+	}
+	waitFor(t, "replicas to sync", func() bool {
+		master.mu.RLock()
+		defer master.mu.RUnlock()
+		return master.ackedReplicas(0) == 3
+	})
+
+	conn, reader := dialForTest(t, port)
+
+	// Without writes of its own a client has nothing to wait for
+	if got := roundTrip(t, conn, reader, "WAIT", "0", "0"); got != ":3\r\n" {
+		t.Errorf("Expected :3, got %q", got)
+	}
+
+	if got := roundTrip(t, conn, reader, "SET", "payment", "42"); got != "+OK\r\n" {
+		t.Fatalf("Expected +OK, got %q", got)
+	}
+	start := time.Now()
+	if got := roundTrip(t, conn, reader, "WAIT", "3", "5000"); got != ":3\r\n" {
+		t.Errorf("Expected :3, got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Expected GETACK to answer before the periodic ack, took %v", elapsed)
+	}
+	for i, replica := range replicas {
+		if value, _ := replicaValue(replica, "payment"); value != "42" {
+			t.Errorf("Expected replica %d to have the write, got %q", i, value)
+		}
+	}
+
+	start = time.Now()
+	if got := roundTrip(t, conn, reader, "WAIT", "4", "300"); got != ":3\r\n" {
+		t.Errorf("Expected :3 after the timeout, got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("Expected WAIT to block until the timeout, took %v", elapsed)
+	}
+
+	if got := roundTrip(t, conn, reader, "WAIT", "1", "-1"); !strings.HasPrefix(got, "-ERR") {
+		t.Errorf("Expected an error for a negative timeout, got %q", got)
+	}
+
+	replicaConn, replicaReader := dialForTest(t, replicas[0].Port)
+	if got := roundTrip(t, replicaConn, replicaReader, "WAIT", "1", "10"); !strings.HasPrefix(got, "-ERR") {
+		t.Errorf("Expected WAIT to be refused on a replica, got %q", got)
+	}
+}
+
+func TestMaster_DisklessSyncSharesTransfer(t *testing.T) {
+	config := DefaultConfig()
+	config.ReplDisklessSync = true
+	config.ReplDisklessSyncDelay = 1
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, config)
+	master.executeCommand(&Command{Name: "SET", Args: []string{"before", "1"}})
+	port := serveForTest(t, master)
+
+	// Both replicas ask within the delay window and get the same stream
+	var readers []*bufio.Reader
+	for range 2 {
+		conn, reader := dialForTest(t, port)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		conn.Write(FormatCommand(&Command{Name: "PSYNC", Args: []string{"?", "-1"}}))
+		readers = append(readers, reader)
+	}
+	waitFor(t, "replicas to register", func() bool {
+		master.mu.RLock()
+		defer master.mu.RUnlock()
+		return len(master.replicas) == 2
+	})
+
+	var marks []string
+	for _, reader := range readers {
+		if reply, _ := reader.ReadString('\n'); !strings.HasPrefix(reply, "+FULLRESYNC ") {
+			t.Fatalf("Expected +FULLRESYNC, got %q", reply)
+		}
+		header, _ := reader.ReadString('\n')
+		mark, ok := strings.CutPrefix(strings.TrimSuffix(header, "\r\n"), "$EOF:")
+		if !ok || len(mark) != rdbEOFMarkLen {
+			t.Fatalf("Expected $EOF:<mark>, got %q", header)
+		}
+		marks = append(marks, mark)
+
+		data, err := NewRDBStreamParser(reader).Parse()
+		if err != nil || data["before"].value != "1" {
+			t.Errorf("Expected the snapshot to hold before, got %v (%v)", data, err)
+		}
+		tail := make([]byte, rdbEOFMarkLen)
+		if _, err := io.ReadFull(reader, tail); err != nil || string(tail) != mark {
+			t.Errorf("Expected the RDB to end with %q, got %q", mark, tail)
+		}
+	}
+	if marks[0] != marks[1] {
+		t.Errorf("Expected one transfer for both replicas, got marks %v", marks)
+	}
+
+	// Writes after the transfer started follow the mark
+	master.executeCommand(&Command{Name: "SET", Args: []string{"after", "2"}})
+	for _, reader := range readers {
+		cmd, err := NewRESPReader(reader).ReadCommand()
+		if err != nil || cmd.Name != "SET" || cmd.Args[0] != "after" {
+			t.Errorf("Expected SET after, got %v (%v)", cmd, err)
+		}
+	}
+}
+
+func TestReplica_DisklessLoad(t *testing.T) {
+	config := DefaultConfig()
+	config.ReplDisklessSync = true
+	config.ReplDisklessSyncDelay = 0
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, config)
+	master.executeCommand(&Command{Name: "SET", Args: []string{"before", "1"}})
+	port := serveForTest(t, master)
+
+	for _, mode := range ReplDisklessLoadModes {
+		replicaConfig := DefaultConfig()
+		replicaConfig.ReplDisklessLoad = mode
+		replica := startTestReplicaWithConfig(t, port, replicaConfig)
+		waitFor(t, mode+" load", func() bool {
+			value, _ := replicaValue(replica, "before")
+			return value == "1"
+		})
+
+		master.executeCommand(&Command{Name: "SET", Args: []string{"after", mode}})
+		waitFor(t, mode+" stream", func() bool {
+			value, _ := replicaValue(replica, "after")
+			return value == mode
+		})
+	}
+}
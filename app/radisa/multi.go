@@ -0,0 +1,207 @@
+package radisa
+
+import (
+	"slices"
+)
+
+// transactionCommands run right away even inside MULTI.
+var transactionCommands = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+	"QUIT":    true,
+}
+
+func (c *client) multi() []byte {
+	if c.inMulti {
+		return FormatError("MULTI calls can not be nested")
+	}
+	c.inMulti = true
+	return FormatSimpleString("OK")
+}
+
+func (r *Radisa) discard(c *client) []byte {
+	if !c.inMulti {
+		return FormatError("DISCARD without MULTI")
+	}
+	c.resetMulti()
+
+	r.mu.Lock()
+	r.unwatchAllKeys(c)
+	r.mu.Unlock()
+	return FormatSimpleString("OK")
+}
+
+func (c *client) resetMulti() {
+	c.inMulti = false
+	c.multiQueue = nil
+	c.multiFailed = false
+}
+
+// queueCommand adds cmd to c's transaction. A command that can never run,
+// because it is unknown, has the wrong number of arguments or is refused in
+// the server's current state, fails the whole transaction instead, like in
+// redis.
+func (r *Radisa) queueCommand(c *client, cmd *Command) []byte {
+	reply := checkArity(cmd)
+	if reply == nil && commandTable[cmd.Name].flags&cmdNoMulti != 0 {
+		reply = FormatError("Command not allowed inside a transaction")
+	}
+	if reply == nil {
+		r.mu.Lock()
+		if reply = r.aclDenied(c, cmd, "multi"); reply == nil {
+			reply = r.rejectCommand(cmd)
+		}
+		r.mu.Unlock()
+	}
+	if reply != nil {
+		c.multiFailed = true
+		return reply
+	}
+
+	c.multiQueue = append(c.multiQueue, cmd)
+	return FormatSimpleString("QUEUED")
+}
+
+// exec runs c's queued commands under a single hold of mu, so no other
+// client sees or interleaves with part of the transaction, and the writes
+// reach the AOF and the replicas back to back. A command failing at runtime
+// only puts its error in the reply array, the others still run. If a key c
+// watches changed or expired since WATCH, nothing runs and the reply is a
+// null array.
+func (r *Radisa) exec(c *client) []byte {
+	if !c.inMulti {
+		return FormatError("EXEC without MULTI")
+	}
+	queue, failed := c.multiQueue, c.multiFailed
+	c.resetMulti()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dirty := c.watchDirty || r.watchedKeyExpired(c)
+	r.unwatchAllKeys(c)
+	if failed {
+		return FormatErrorCode("EXECABORT", "Transaction discarded because of previous errors.")
+	}
+	if dirty {
+		return FormatNullArray()
+	}
+
+	// Several writes reach the AOF and the replicas wrapped in MULTI and
+	// EXEC, so neither replays only part of them
+	writes := 0
+	for _, cmd := range queue {
+		if spec := lookupCommand(cmd); spec != nil && spec.flags&cmdWrite != 0 {
+			writes++
+		}
+	}
+	r.multiPending = writes > 1
+
+	r.currentClient = c
+	defer func() { r.currentClient = nil }()
+	replies := make([][]byte, len(queue))
+	for i, cmd := range queue {
+		if cmd.Name == "UNWATCH" {
+			// EXEC already unwatched everything
+			replies[i] = FormatSimpleString("OK")
+			continue
+		}
+		// The user's rules may have changed since the command was queued
+		if replies[i] = r.aclDenied(c, cmd, "multi"); replies[i] != nil {
+			continue
+		}
+		if replies[i] = r.rejectCommand(cmd); replies[i] == nil {
+			replies[i] = r.execute(cmd)
+			r.rememberTrackedKeys(c, cmd)
+		}
+	}
+	if r.multiPropagated {
+		r.propagate(&Command{Name: "EXEC"})
+	}
+	r.multiPending, r.multiPropagated = false, false
+	c.woff = r.replOffset
+	return FormatReplies(replies)
+}
+
+// watchedKey is a key a client WATCHes. expired records whether it had
+// already expired then, so only expiring afterwards fails the transaction.
+type watchedKey struct {
+	key     string
+	expired bool
+}
+
+// watch registers c for each key, so a change to any of them before EXEC
+// aborts c's transaction.
+func (r *Radisa) watch(c *client, cmd *Command) []byte {
+	if c.inMulti {
+		return FormatError("WATCH inside MULTI is not allowed")
+	}
+	if len(cmd.Args) == 0 {
+		return FormatError("wrong number of arguments for 'watch' command")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watchedKeys == nil {
+		r.watchedKeys = make(map[string][]*client)
+	}
+	for _, key := range cmd.Args {
+		if slices.ContainsFunc(c.watchedKeys, func(wk watchedKey) bool { return wk.key == key }) {
+			continue
+		}
+		r.watchedKeys[key] = append(r.watchedKeys[key], c)
+		c.watchedKeys = append(c.watchedKeys, watchedKey{key: key, expired: r.keyIsExpired(key)})
+	}
+	return FormatSimpleString("OK")
+}
+
+func (r *Radisa) unwatch(c *client) []byte {
+	r.mu.Lock()
+	r.unwatchAllKeys(c)
+	r.mu.Unlock()
+	return FormatSimpleString("OK")
+}
+
+// unwatchAllKeys forgets every key c watches. The caller holds mu.
+func (r *Radisa) unwatchAllKeys(c *client) {
+	for _, wk := range c.watchedKeys {
+		clients := slices.DeleteFunc(r.watchedKeys[wk.key], func(other *client) bool { return other == c })
+		if len(clients) == 0 {
+			delete(r.watchedKeys, wk.key)
+		} else {
+			r.watchedKeys[wk.key] = clients
+		}
+	}
+	c.watchedKeys = nil
+	c.watchDirty = false
+}
+
+// watchedKeyExpired reports whether a key c watches expired since WATCH
+// without anyone noticing yet. The caller holds mu.
+func (r *Radisa) watchedKeyExpired(c *client) bool {
+	return slices.ContainsFunc(c.watchedKeys, func(wk watchedKey) bool {
+		return !wk.expired && r.keyIsExpired(wk.key)
+	})
+}
+
+// signalModifiedKey fails the transactions of the clients watching key and
+// invalidates it in the caches of the clients tracking it. The caller holds
+// mu.
+func (r *Radisa) signalModifiedKey(key string) {
+	for _, c := range r.watchedKeys[key] {
+		c.watchDirty = true
+	}
+	r.trackingInvalidateKey(key)
+}
+
+// touchAllWatchedKeys fails every transaction watching a key, for when the
+// whole keyspace is replaced. The caller holds mu.
+func (r *Radisa) touchAllWatchedKeys() {
+	for _, clients := range r.watchedKeys {
+		for _, c := range clients {
+			c.watchDirty = true
+		}
+	}
+}
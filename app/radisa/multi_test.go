@@ -0,0 +1,315 @@
+package radisa
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readReply reads one complete RESP reply, nested arrays included.
+func readReply(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Expected a reply, got: %v", err)
+	}
+
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	switch line[0] {
+	case '*', '>':
+		for range n {
+			line += readReply(t, reader)
+		}
+	case '%':
+		for range 2 * n {
+			line += readReply(t, reader)
+		}
+	case '$':
+		if n >= 0 {
+			body := make([]byte, n+2)
+			if _, err := io.ReadFull(reader, body); err != nil {
+				t.Fatalf("Expected a bulk string, got: %v", err)
+			}
+			line += string(body)
+		}
+	}
+	return line
+}
+
+func TestMulti_ExecRunsQueue(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+	r.executeCommand(&Command{Name: "SET", Args: []string{"stock", "10"}})
+	r.executeCommand(&Command{Name: "SET", Args: []string{"name", "widget"}})
+
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"MULTI"}, "-ERR MULTI calls can not be nested\r\n"},
+		{[]string{"DECRBY", "stock", "3"}, "+QUEUED\r\n"},
+		{[]string{"INCR", "name"}, "+QUEUED\r\n"},
+		{[]string{"DECR", "stock"}, "+QUEUED\r\n"},
+		{[]string{"GET", "stock"}, "+QUEUED\r\n"},
+	}
+	for _, step := range steps {
+		if got := roundTrip(t, conn, reader, step.args...); got != step.expected {
+			t.Errorf("Expected %q for %v, got %q", step.expected, step.args, got)
+		}
+	}
+
+	// The runtime error of INCR doesn't stop the rest
+	conn.Write(FormatCommand(&Command{Name: "EXEC"}))
+	expected := "*4\r\n:7\r\n-ERR value is not an integer or out of range\r\n:6\r\n$1\r\n6\r\n"
+	if got := readReply(t, reader); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if got := roundTrip(t, conn, reader, "EXEC"); got != "-ERR EXEC without MULTI\r\n" {
+		t.Errorf("Expected EXEC without MULTI, got %q", got)
+	}
+}
+
+func TestMulti_ExecAbort(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"SET", "k", "v"}, "+QUEUED\r\n"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"NOPE"}, "-ERR unknown command\r\n"},
+		{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{[]string{"GET", "k"}, "$-1\r\n"},
+
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"SET", "k", "v"}, "+QUEUED\r\n"},
+		{[]string{"DISCARD"}, "+OK\r\n"},
+		{[]string{"GET", "k"}, "$-1\r\n"},
+		{[]string{"DISCARD"}, "-ERR DISCARD without MULTI\r\n"},
+	}
+	for _, step := range steps {
+		if got := roundTrip(t, conn, reader, step.args...); got != step.expected {
+			t.Errorf("Expected %q for %v, got %q", step.expected, step.args, got)
+		}
+	}
+}
+
+func TestIncrBy(t *testing.T) {
+	r := createTestServer()
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"INCR", "n"}, ":1\r\n"},
+		{[]string{"INCRBY", "n", "41"}, ":42\r\n"},
+		{[]string{"DECRBY", "n", "50"}, ":-8\r\n"},
+		{[]string{"INCRBY", "n", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"SET", "max", "9223372036854775807"}, "+OK\r\n"},
+		{[]string{"INCR", "max"}, "-ERR increment or decrement would overflow\r\n"},
+		{[]string{"DECRBY", "n", "-9223372036854775808"}, "-ERR decrement would overflow\r\n"},
+	}
+	for _, test := range tests {
+		got := string(r.executeCommand(&Command{Name: test.args[0], Args: test.args[1:]}))
+		if got != test.expected {
+			t.Errorf("Expected %q for %v, got %q", test.expected, test.args, got)
+		}
+	}
+}
+
+func TestWatch_ChangedKeyAbortsExec(t *testing.T) {
+	r := createTestServer()
+	port := serveForTest(t, r)
+	conn, reader := dialForTest(t, port)
+	other, otherReader := dialForTest(t, port)
+
+	roundTrip(t, conn, reader, "SET", "stock", "5")
+	roundTrip(t, conn, reader, "WATCH", "stock")
+	roundTrip(t, other, otherReader, "DECR", "stock")
+	roundTrip(t, conn, reader, "MULTI")
+	if got := roundTrip(t, conn, reader, "WATCH", "stock"); got != "-ERR WATCH inside MULTI is not allowed\r\n" {
+		t.Errorf("Expected WATCH inside MULTI error, got %q", got)
+	}
+	roundTrip(t, conn, reader, "DECR", "stock")
+	if got := roundTrip(t, conn, reader, "EXEC"); got != "*-1\r\n" {
+		t.Errorf("Expected null array, got %q", got)
+	}
+
+	// EXEC unwatched the key, the retry goes through
+	roundTrip(t, conn, reader, "WATCH", "stock")
+	roundTrip(t, conn, reader, "MULTI")
+	roundTrip(t, conn, reader, "DECR", "stock")
+	conn.Write(FormatCommand(&Command{Name: "EXEC"}))
+	if got := readReply(t, reader); got != "*1\r\n:3\r\n" {
+		t.Errorf("Expected the DECR to run, got %q", got)
+	}
+
+	// UNWATCH forgets the change
+	roundTrip(t, conn, reader, "WATCH", "stock")
+	roundTrip(t, other, otherReader, "SET", "stock", "9")
+	roundTrip(t, conn, reader, "UNWATCH")
+	roundTrip(t, conn, reader, "MULTI")
+	roundTrip(t, conn, reader, "GET", "stock")
+	conn.Write(FormatCommand(&Command{Name: "EXEC"}))
+	if got := readReply(t, reader); got != "*1\r\n$1\r\n9\r\n" {
+		t.Errorf("Expected the GET to run, got %q", got)
+	}
+}
+
+func TestWatch_ExpiredKeyAbortsExec(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	roundTrip(t, conn, reader, "SET", "lock", "1", "PX", "50")
+	roundTrip(t, conn, reader, "WATCH", "lock")
+	time.Sleep(100 * time.Millisecond)
+	roundTrip(t, conn, reader, "MULTI")
+	roundTrip(t, conn, reader, "SET", "lock", "2")
+	if got := roundTrip(t, conn, reader, "EXEC"); got != "*-1\r\n" {
+		t.Errorf("Expected null array, got %q", got)
+	}
+}
+
+func TestWatch_CleanedUpOnDisconnect(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	roundTrip(t, conn, reader, "WATCH", "a", "b")
+	r.mu.RLock()
+	watched := len(r.watchedKeys)
+	r.mu.RUnlock()
+	if watched != 2 {
+		t.Errorf("Expected 2 watched keys, got %d", watched)
+	}
+
+	conn.Close()
+	waitFor(t, "watches to go away", func() bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return len(r.watchedKeys) == 0
+	})
+}
+
+func TestMulti_PropagatesWrappedToAOF(t *testing.T) {
+	dir := t.TempDir()
+	r := newAOFTestServer(t, dir, DefaultConfig())
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	for _, args := range [][]string{
+		{"MULTI"}, {"SET", "a", "1"}, {"GET", "a"}, {"INCR", "n"}, {"EXEC"},
+		// A single write needs no wrapping
+		{"MULTI"}, {"SET", "b", "2"}, {"GET", "b"}, {"EXEC"},
+	} {
+		commandForTest(t, conn, reader, args...)
+	}
+
+	incrPath := filepath.Join(dir, "appendonlydir", "appendonly.aof.1.incr.aof")
+	incr, _ := os.ReadFile(incrPath)
+	transaction := string(FormatCommand(&Command{Name: "MULTI"})) +
+		string(FormatCommand(&Command{Name: "SET", Args: []string{"a", "1"}})) +
+		string(FormatCommand(&Command{Name: "INCR", Args: []string{"n"}})) +
+		string(FormatCommand(&Command{Name: "EXEC"}))
+	expected := transaction + string(FormatCommand(&Command{Name: "SET", Args: []string{"b", "2"}}))
+	if string(incr) != expected {
+		t.Fatalf("Expected %q, got %q", expected, incr)
+	}
+
+	// A crash before EXEC reached the file loses the whole transaction
+	exec := len(FormatCommand(&Command{Name: "EXEC"}))
+	os.WriteFile(incrPath, []byte(transaction[:len(transaction)-exec]), 0o644)
+
+	config := DefaultConfig()
+	config.AppendOnly = true
+	config.AOFLoadTruncated = false
+	if refused := NewRadisa(dir, "dump.rdb", 0, config); refused.loadErr == nil {
+		t.Fatal("Expected the incomplete transaction to be refused")
+	}
+
+	loaded := newAOFTestServer(t, dir, DefaultConfig())
+	if len(loaded.data) != 0 {
+		t.Errorf("Expected nothing of the transaction to load, got %v", loaded.data)
+	}
+	if info, _ := os.Stat(incrPath); info.Size() != 0 {
+		t.Errorf("Expected the file truncated before MULTI, got %d bytes", info.Size())
+	}
+}
+
+func TestMulti_PropagatesWrappedToReplicas(t *testing.T) {
+	master := NewRadisa(t.TempDir(), "dump.rdb", 0, DefaultConfig())
+	port := serveForTest(t, master)
+	replica := startTestReplica(t, port)
+	waitFor(t, "full resync", func() bool { return linkUp(replica) })
+
+	conn, reader := dialForTest(t, port)
+	master.mu.RLock()
+	offset := master.replOffset
+	master.mu.RUnlock()
+	for _, args := range [][]string{{"MULTI"}, {"SET", "x", "1"}, {"SET", "y", "2"}, {"EXEC"}} {
+		commandForTest(t, conn, reader, args...)
+	}
+
+	master.mu.RLock()
+	stream, _ := master.backlog.readFrom(offset + 1)
+	master.mu.RUnlock()
+	expected := string(FormatCommand(&Command{Name: "MULTI"})) +
+		string(FormatCommand(&Command{Name: "SET", Args: []string{"x", "1"}})) +
+		string(FormatCommand(&Command{Name: "SET", Args: []string{"y", "2"}})) +
+		string(FormatCommand(&Command{Name: "EXEC"}))
+	// A replication PING may come before it, nothing goes in between
+	if !strings.HasSuffix(string(stream), expected) {
+		t.Errorf("Expected the stream to end with %q, got %q", expected, stream)
+	}
+	waitFor(t, "the transaction", func() bool {
+		value, _ := replicaValue(replica, "y")
+		return value == "2"
+	})
+	if value, _ := replicaValue(replica, "x"); value != "1" {
+		t.Errorf("Expected x=1, got %q", value)
+	}
+}
+
+func TestReplica_AppliesTransactionOnExec(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	r := NewReplica(t.TempDir(), "dump.rdb", 6380, fmt.Sprintf("127.0.0.1 %d", l.Addr().(*net.TCPAddr).Port), DefaultConfig())
+	go r.replicate(r.replicaOf)
+	defer func() {
+		r.mu.Lock()
+		r.replicaOf = nil
+		r.mu.Unlock()
+	}()
+
+	conn, _ := fakeMasterSession(t, l, map[string]Data{})
+	defer conn.Close()
+	conn.Write(FormatCommand(&Command{Name: "MULTI"}))
+	conn.Write(FormatCommand(&Command{Name: "SET", Args: []string{"x", "1"}}))
+	conn.Write(FormatCommand(&Command{Name: "SET", Args: []string{"marker", "seen"}}))
+
+	// Nothing of the transaction shows before its EXEC arrives
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := replicaValue(r, "x"); ok {
+		t.Fatalf("Expected x to wait for EXEC")
+	}
+	conn.Write(FormatCommand(&Command{Name: "EXEC"}))
+	waitFor(t, "the transaction", func() bool {
+		value, _ := replicaValue(r, "marker")
+		return value == "seen"
+	})
+	if value, _ := replicaValue(r, "x"); value != "1" {
+		t.Errorf("Expected x=1, got %q", value)
+	}
+}
@@ -0,0 +1,110 @@
+package radisa
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keyspace event classes, one per notify-keyspace-events flag character.
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+	notifyStream               // t
+	notifyKeyMiss              // m
+	notifyModule               // d
+	notifyNew                  // n
+
+	// notifyAll is what A stands for. Like in redis it leaves out key
+	// misses and new keys, which have to be asked for explicitly.
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZSet | notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+// keyspaceEventFlags pairs each flag character with its class, in the order
+// CONFIG GET prints them.
+var keyspaceEventFlags = []struct {
+	char  byte
+	class int
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'h', notifyHash},
+	{'z', notifyZSet},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'t', notifyStream},
+	{'m', notifyKeyMiss},
+	{'d', notifyModule},
+	{'n', notifyNew},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+}
+
+// ParseKeyspaceEvents turns a notify-keyspace-events string such as "Kx" or
+// "AKE" into the classes it enables. The empty string disables them all.
+func ParseKeyspaceEvents(s string) (int, error) {
+	flags := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		class := 0
+		for _, f := range keyspaceEventFlags {
+			if f.char == s[i] {
+				class = f.class
+				break
+			}
+		}
+		if class == 0 {
+			return 0, fmt.Errorf("unknown flag %q", s[i])
+		}
+		flags |= class
+	}
+	return flags, nil
+}
+
+// FormatKeyspaceEvents is the inverse of ParseKeyspaceEvents, using A
+// wherever all of its classes are set.
+func FormatKeyspaceEvents(flags int) string {
+	var sb strings.Builder
+	if flags&notifyAll == notifyAll {
+		sb.WriteByte('A')
+	}
+	for _, f := range keyspaceEventFlags {
+		if flags&notifyAll == notifyAll && f.class&notifyAll != 0 {
+			continue
+		}
+		if flags&f.class != 0 {
+			sb.WriteByte(f.char)
+		}
+	}
+	return sb.String()
+}
+
+// notifyKeyspaceEvent publishes event on key to __keyspace@0__:<key> and
+// key to __keyevent@0__:<event>, as far as notify-keyspace-events asks for
+// class. Only database 0 exists. Notifications stay on this server, each
+// replica sends its own as it applies the stream. The caller holds mu.
+func (r *Radisa) notifyKeyspaceEvent(class int, event string, key string) {
+	flags := r.config.NotifyKeyspaceEvents
+	if flags&class == 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		r.publish("__keyspace@0__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		r.publish("__keyevent@0__:"+event, key)
+	}
+}
@@ -0,0 +1,100 @@
+package radisa
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseKeyspaceEvents(t *testing.T) {
+	tests := map[string]string{
+		"":            "",
+		"KEA":         "AKE",
+		"Ex":          "xE",
+		"Kg$lshzxetd": "AK",
+		"AKEmn":       "AmnKE",
+	}
+	for s, expected := range tests {
+		flags, err := ParseKeyspaceEvents(s)
+		if err != nil {
+			t.Errorf("Expected %q to parse, got: %v", s, err)
+			continue
+		}
+		if got := FormatKeyspaceEvents(flags); got != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, s, got)
+		}
+	}
+
+	if _, err := ParseKeyspaceEvents("KEq"); err == nil {
+		t.Errorf("Expected an error for an unknown flag")
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	r := createTestServer()
+	r.config.NotifyKeyspaceEvents, _ = ParseKeyspaceEvents("Eg$xn")
+	sub, reader := dialForTest(t, serveForTest(t, r))
+
+	sub.Write(FormatCommand(&Command{Name: "PSUBSCRIBE", Args: []string{"__keyevent@0__:*"}}))
+	readReply(t, reader)
+
+	r.executeCommand(&Command{Name: "SET", Args: []string{"a", "1"}})
+	r.executeCommand(&Command{Name: "SET", Args: []string{"a", "2"}})
+	r.executeCommand(&Command{Name: "INCR", Args: []string{"c"}})
+	r.executeCommand(&Command{Name: "EXPIRE", Args: []string{"a", "100"}})
+	r.executeCommand(&Command{Name: "DEL", Args: []string{"c"}})
+	// Key misses are not asked for
+	r.executeCommand(&Command{Name: "GET", Args: []string{"missing"}})
+
+	// Expired on access
+	r.executeCommand(&Command{Name: "SET", Args: []string{"t", "1", "PX", "1"}})
+	time.Sleep(5 * time.Millisecond)
+	r.executeCommand(&Command{Name: "GET", Args: []string{"t"}})
+
+	// Expired by the expire cycle without anyone reading it
+	r.executeCommand(&Command{Name: "SET", Args: []string{"u", "1", "PX", "1"}})
+	time.Sleep(5 * time.Millisecond)
+	r.mu.Lock()
+	r.activeExpireCycle()
+	r.mu.Unlock()
+	if _, exists := r.data["u"]; exists {
+		t.Errorf("Expected the expire cycle to delete u")
+	}
+
+	expected := [][2]string{
+		{"new", "a"}, {"set", "a"}, {"set", "a"},
+		{"new", "c"}, {"incrby", "c"},
+		{"expire", "a"}, {"del", "c"},
+		{"new", "t"}, {"set", "t"}, {"expired", "t"},
+		{"new", "u"}, {"set", "u"}, {"expired", "u"},
+	}
+	for _, e := range expected {
+		want := string(FormatArray([]string{"pmessage", "__keyevent@0__:*", "__keyevent@0__:" + e[0], e[1]}))
+		if got := readReply(t, reader); got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestKeyspaceNotifications_KeyspaceChannel(t *testing.T) {
+	r := createTestServer()
+	r.config.NotifyKeyspaceEvents, _ = ParseKeyspaceEvents("KEm")
+	sub, reader := dialForTest(t, serveForTest(t, r))
+
+	sub.Write(FormatCommand(&Command{Name: "SUBSCRIBE", Args: []string{"__keyspace@0__:missing", "__keyevent@0__:keymiss"}}))
+	readReply(t, reader)
+	readReply(t, reader)
+
+	// Only key misses are asked for
+	r.executeCommand(&Command{Name: "SET", Args: []string{"missing", "1"}})
+	r.executeCommand(&Command{Name: "DEL", Args: []string{"missing"}})
+	r.executeCommand(&Command{Name: "GET", Args: []string{"missing"}})
+
+	for _, want := range [][]string{
+		{"message", "__keyspace@0__:missing", "keymiss"},
+		{"message", "__keyevent@0__:keymiss", "missing"},
+	} {
+		if got, expected := readReply(t, reader), string(FormatArray(want)); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package radisa
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bgsaveRetryDelay is how long saveCron waits after a failed save before it
+// tries again, so a full disk doesn't turn into a busy loop.
+const bgsaveRetryDelay = 5 * time.Second
+
+var errSaveInProgress = errors.New("Background save already in progress")
+
+// snapshotBatchKeys is how many keys a snapshot copies before it lets other
+// clients in again.
+const snapshotBatchKeys = 1024
+
+// SavePoint asks for a background save once at least Changes writes happened
+// and Seconds seconds passed since the last successful save.
+type SavePoint struct {
+	Seconds int
+	Changes int
+}
+
+// ParseSavePoints reads the redis.conf form "3600 1 300 100". An empty string
+// disables automatic saving.
+func ParseSavePoints(spec string) ([]SavePoint, error) {
+	fields := strings.Fields(spec)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save points %q: expected <seconds> <changes> pairs", spec)
+	}
+
+	points := make([]SavePoint, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid save seconds %q", fields[i])
+		}
+		changes, err := strconv.Atoi(fields[i+1])
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save changes %q", fields[i+1])
+		}
+		points = append(points, SavePoint{Seconds: seconds, Changes: changes})
+	}
+	return points, nil
+}
+
+// FormatSavePoints is the inverse of ParseSavePoints, used by CONFIG GET save.
+func FormatSavePoints(points []SavePoint) string {
+	parts := make([]string, 0, len(points)*2)
+	for _, sp := range points {
+		parts = append(parts, strconv.Itoa(sp.Seconds), strconv.Itoa(sp.Changes))
+	}
+	return strings.Join(parts, " ")
+}
+
+// save writes a snapshot in the calling goroutine. The caller holds mu, so
+// like SAVE in redis it blocks every other client until the file is written.
+func (r *Radisa) save() error {
+	if !r.beginSave() {
+		return errSaveInProgress
+	}
+
+	err := r.writeRDBFile(r.data)
+	if err == nil {
+		r.dirty = 0
+	}
+	r.finishSave(err)
+	return err
+}
+
+// bgsave copies the keyspace and writes it out in the background. The
+// caller holds mu.
+func (r *Radisa) bgsave() error {
+	if !r.beginSave() {
+		return errSaveInProgress
+	}
+
+	snap := r.snapshot()
+	go func() {
+		err := r.writeRDBFile(snap.copy(r))
+		if err != nil {
+			fmt.Printf("Background saving error: %v\n", err)
+		} else {
+			r.mu.Lock()
+			r.dirty -= snap.dirty
+			r.mu.Unlock()
+		}
+		r.finishSave(err)
+	}()
+	return nil
+}
+
+// LastSave returns the time of the last successful save.
+func (r *Radisa) LastSave() time.Time {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+	return r.lastSave
+}
+
+func (r *Radisa) beginSave() bool {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	if r.saving {
+		return false
+	}
+	r.saving = true
+	r.lastSaveTry = time.Now()
+	return true
+}
+
+func (r *Radisa) finishSave(err error) {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	r.saving = false
+	r.lastSaveErr = err
+	if err == nil {
+		r.lastSave = time.Now()
+	}
+}
+
+// keyspaceSnapshot is the keyspace as of the moment snapshot was called,
+// copied a batch at a time so clients aren't blocked for the whole copy -
+// our stand-in for fork()'s copy-on-write. Values are replaced rather than
+// mutated in place, so a shallow copy of each is enough. A key written
+// before the copy reached it has its old value kept in before first.
+type keyspaceSnapshot struct {
+	source map[string]Data
+	data   map[string]Data
+	before map[string]snapshotValue
+	// dirty is what r.dirty was, the writes a save of this snapshot covers
+	dirty int
+}
+
+// snapshotValue is a key as it was when the snapshot was taken.
+type snapshotValue struct {
+	value  Data
+	exists bool
+}
+
+// snapshot starts a snapshot of the keyspace, to be copied with copy once
+// mu is released. The caller holds mu.
+func (r *Radisa) snapshot() *keyspaceSnapshot {
+	snap := &keyspaceSnapshot{
+		source: r.data,
+		data:   make(map[string]Data, len(r.data)),
+		before: make(map[string]snapshotValue),
+		dirty:  r.dirty,
+	}
+	r.snapshots = append(r.snapshots, snap)
+	return snap
+}
+
+// copy walks the keyspace in batches of snapshotBatchKeys, releasing mu in
+// between, and returns it as it was when the snapshot was taken. Keys
+// written since were skipped by the walk and are taken from before. The
+// caller doesn't hold mu.
+func (s *keyspaceSnapshot) copy(r *Radisa) map[string]Data {
+	r.mu.RLock()
+	n := 0
+	// A map may be ranged over while it changes between iterations, mu
+	// keeps those changes from overlapping one
+	for key, value := range s.source {
+		if _, written := s.before[key]; !written {
+			s.data[key] = value
+		}
+		if n++; n%snapshotBatchKeys == 0 {
+			r.mu.RUnlock()
+			r.mu.RLock()
+		}
+	}
+	r.mu.RUnlock()
+
+	// Nothing is recorded for this snapshot once it is off the list
+	r.mu.Lock()
+	r.snapshots = slices.DeleteFunc(r.snapshots, func(other *keyspaceSnapshot) bool { return other == s })
+	r.mu.Unlock()
+
+	for key, old := range s.before {
+		if old.exists {
+			s.data[key] = old.value
+		}
+	}
+	return s.data
+}
+
+// keepForSnapshots records key's current value for every snapshot still
+// being copied that hasn't reached it yet, before key is written. The
+// caller holds mu.
+func (r *Radisa) keepForSnapshots(key string) {
+	for _, snap := range r.snapshots {
+		if _, copied := snap.data[key]; copied {
+			continue
+		}
+		if _, kept := snap.before[key]; kept {
+			continue
+		}
+		value, exists := r.data[key]
+		snap.before[key] = snapshotValue{value: value, exists: exists}
+	}
+}
+
+// replaceData swaps in a whole new keyspace. The snapshots being copied keep
+// walking the old one, which nothing writes to anymore. The caller holds mu.
+func (r *Radisa) replaceData(data map[string]Data) {
+	r.data = data
+	r.snapshots = nil
+}
+
+// writeRDBFile encodes data into a temp file next to the target and renames it
+// into place, so a crash mid-save never leaves a truncated dump behind.
+func (r *Radisa) writeRDBFile(data map[string]Data) error {
+	return writeRDBAtomically(filepath.Join(r.dir, r.dbfilename), data, r.config.RDBCompression)
+}
+
+func writeRDBAtomically(path string, data map[string]Data, compress bool) error {
+	return writeFileAtomically(path, func(w io.Writer) error {
+		return NewRDBWriter(w, compress).Write(data)
+	})
+}
+
+// writeFileAtomically fills a temp file next to path through a buffer, fsyncs
+// it and renames it over path.
+func writeFileAtomically(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*"+filepath.Ext(path))
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	buf := bufio.NewWriter(tmp)
+	if err := write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveCron checks the save points once a second, like serverCron does.
+func (r *Radisa) saveCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.mu.RLock()
+		dirty := r.dirty
+		r.mu.RUnlock()
+
+		r.saveMu.Lock()
+		sinceSave := time.Since(r.lastSave)
+		backingOff := r.lastSaveErr != nil && time.Since(r.lastSaveTry) < bgsaveRetryDelay
+		r.saveMu.Unlock()
+
+		if backingOff {
+			continue
+		}
+
+		for _, sp := range r.config.SavePoints {
+			if dirty >= sp.Changes && dirty > 0 && sinceSave >= time.Duration(sp.Seconds)*time.Second {
+				fmt.Printf("%d changes in %d seconds. Saving...\n", sp.Changes, sp.Seconds)
+				r.mu.Lock()
+				r.bgsave()
+				r.mu.Unlock()
+				break
+			}
+		}
+	}
+}
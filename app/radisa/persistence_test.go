@@ -0,0 +1,129 @@
+package radisa
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseSavePoints(t *testing.T) {
+	result, err := ParseSavePoints("3600 1 300 100")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []SavePoint{{3600, 1}, {300, 100}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	if FormatSavePoints(result) != "3600 1 300 100" {
+		t.Errorf("Expected round trip, got %q", FormatSavePoints(result))
+	}
+}
+
+func TestParseSavePoints_Empty(t *testing.T) {
+	result, err := ParseSavePoints("")
+	if err != nil || len(result) != 0 {
+		t.Errorf("Expected no save points, got %v (%v)", result, err)
+	}
+}
+
+func TestParseSavePoints_Invalid(t *testing.T) {
+	for _, spec := range []string{"3600", "x 1", "60 -1", "0 1"} {
+		if _, err := ParseSavePoints(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestServer_SAVE_Then_Load(t *testing.T) {
+	server := createTestServer()
+	server.dir = t.TempDir()
+	server.data["foo"] = Data{value: "bar"}
+	server.dirty = 1
+
+	response := server.executeCommand(&Command{Name: "SAVE"})
+	if string(response) != "+OK\r\n" {
+		t.Fatalf("Expected +OK, got %q", response)
+	}
+	if server.dirty != 0 {
+		t.Errorf("Expected dirty to be reset, got %d", server.dirty)
+	}
+
+	loaded := NewRadisa(server.dir, server.dbfilename, 0, DefaultConfig())
+	if loaded.data["foo"].value != "bar" {
+		t.Errorf("Expected foo=bar after reload, got %v", loaded.data)
+	}
+
+	entries, _ := os.ReadDir(server.dir)
+	if len(entries) != 1 || entries[0].Name() != server.dbfilename {
+		t.Errorf("Expected only %s in dir, got %v", server.dbfilename, entries)
+	}
+}
+
+func TestServer_BGSAVE_LASTSAVE(t *testing.T) {
+	server := createTestServer()
+	server.dir = t.TempDir()
+	server.data["foo"] = Data{value: "bar"}
+
+	response := server.executeCommand(&Command{Name: "BGSAVE"})
+	if string(response) != "+Background saving started\r\n" {
+		t.Fatalf("Expected background save to start, got %q", response)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for server.LastSave().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := os.Stat(filepath.Join(server.dir, server.dbfilename)); err != nil {
+		t.Fatalf("Expected RDB file to exist: %v", err)
+	}
+
+	expected := ":" + strconv.FormatInt(server.LastSave().Unix(), 10) + "\r\n"
+	if response := string(server.executeCommand(&Command{Name: "LASTSAVE"})); response != expected {
+		t.Errorf("Expected %q, got %q", expected, response)
+	}
+}
+
+func TestSnapshot_PointInTimeWhileWritten(t *testing.T) {
+	r := createTestServer()
+	for i := range 4 * snapshotBatchKeys {
+		r.executeCommand(&Command{Name: "SET", Args: []string{"k" + strconv.Itoa(i), "old"}})
+	}
+
+	r.mu.Lock()
+	expected := maps.Clone(r.data)
+	snap := r.snapshot()
+	r.mu.Unlock()
+
+	// Overwrite, delete and add keys while the copy walks the keyspace
+	done := make(chan map[string]Data)
+	go func() { done <- snap.copy(r) }()
+	for i := range 4 * snapshotBatchKeys {
+		key := "k" + strconv.Itoa(i)
+		switch i % 3 {
+		case 0:
+			r.executeCommand(&Command{Name: "SET", Args: []string{key, "new"}})
+		case 1:
+			r.executeCommand(&Command{Name: "DEL", Args: []string{key}})
+		default:
+			r.executeCommand(&Command{Name: "SET", Args: []string{"added" + key, "new"}})
+		}
+	}
+	data := <-done
+
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected the %d keys as of the snapshot, got %d keys", len(expected), len(data))
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.snapshots) != 0 {
+		t.Errorf("Expected the snapshot to be done, got %d", len(r.snapshots))
+	}
+}
@@ -0,0 +1,380 @@
+package radisa
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// pubsubOutputLimit is client-output-buffer-limit's hard limit for pub/sub
+// clients. A subscriber that falls this far behind is disconnected instead of
+// holding up publishers.
+const pubsubOutputLimit = 32 << 20
+
+// subscribeModeCommands are all a RESP2 client with subscriptions may run.
+// RESP3 tells messages and replies apart, so there it can run anything.
+var subscribeModeCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"SSUBSCRIBE":   true,
+	"SUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+}
+
+// pubsubType is what tells global channels and shard channels apart, like
+// redis' pubsubtype. Shard channels live in a registry of their own, are
+// confined to one slot per subscription and never match patterns.
+type pubsubType struct {
+	shard          bool
+	subscribeMsg   string
+	unsubscribeMsg string
+	messageMsg     string
+	// clientChannels and serverChannels return the subscriptions of a client
+	// and of the whole server. subscriptions counts those reported back to
+	// the client.
+	clientChannels func(c *client) map[string]struct{}
+	serverChannels func(r *Radisa) map[string]map[*client]struct{}
+	subscriptions  func(c *client) int
+}
+
+var pubsubGlobal = pubsubType{
+	subscribeMsg:   "subscribe",
+	unsubscribeMsg: "unsubscribe",
+	messageMsg:     "message",
+	clientChannels: func(c *client) map[string]struct{} { return c.channels },
+	serverChannels: func(r *Radisa) map[string]map[*client]struct{} { return r.pubsubChannels },
+	subscriptions:  (*client).subscriptionCount,
+}
+
+var pubsubShard = pubsubType{
+	shard:          true,
+	subscribeMsg:   "ssubscribe",
+	unsubscribeMsg: "sunsubscribe",
+	messageMsg:     "smessage",
+	clientChannels: func(c *client) map[string]struct{} { return c.shardChannels },
+	serverChannels: func(r *Radisa) map[string]map[*client]struct{} { return r.pubsubShardChannels },
+	subscriptions:  func(c *client) int { return len(c.shardChannels) },
+}
+
+// subscriptionCount counts channels and patterns, what SUBSCRIBE and
+// PSUBSCRIBE report. Shard channels are counted apart.
+func (c *client) subscriptionCount() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// inSubscribeMode reports whether c has any subscription.
+func (c *client) inSubscribeMode() bool {
+	return c.subscriptionCount()+len(c.shardChannels) > 0
+}
+
+// ensurePubsub prepares c to be sent messages. The caller holds mu.
+func (r *Radisa) ensurePubsub(c *client) {
+	c.ensureOutput()
+	if c.channels == nil {
+		c.channels = make(map[string]struct{})
+		c.patterns = make(map[string]struct{})
+		c.shardChannels = make(map[string]struct{})
+	}
+	if r.pubsubChannels == nil {
+		r.pubsubChannels = make(map[string]map[*client]struct{})
+		r.pubsubPatterns = make(map[string]map[*client]struct{})
+		r.patternsByPrefix = make(map[string]map[string]struct{})
+		r.pubsubShardChannels = make(map[string]map[*client]struct{})
+	}
+}
+
+// subscribe adds c to each channel. The confirmations are queued in the
+// same critical section, so they reach c before any message does. Shard
+// channels subscribed together have to share a slot.
+func (r *Radisa) subscribe(c *client, cmd *Command, t pubsubType) []byte {
+	if len(cmd.Args) == 0 {
+		return FormatError("wrong number of arguments for '" + strings.ToLower(cmd.Name) + "' command")
+	}
+	if t.shard {
+		slot := keyHashSlot(cmd.Args[0])
+		for _, channel := range cmd.Args[1:] {
+			if keyHashSlot(channel) != slot {
+				return FormatErrorCode("CROSSSLOT", "Keys in request don't hash to the same slot")
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ensurePubsub(c)
+
+	clientChannels, serverChannels := t.clientChannels(c), t.serverChannels(r)
+	for _, channel := range cmd.Args {
+		if _, ok := clientChannels[channel]; !ok {
+			clientChannels[channel] = struct{}{}
+			if serverChannels[channel] == nil {
+				serverChannels[channel] = make(map[*client]struct{})
+			}
+			serverChannels[channel][c] = struct{}{}
+		}
+		c.out.writeReply(pubsubReplyFor(c, formatPubsubReply(t.subscribeMsg, channel, t.subscriptions(c))))
+	}
+	c.updateOutputLimit()
+	return nil
+}
+
+// unsubscribe removes c from the given channels, or from all of them.
+func (r *Radisa) unsubscribe(c *client, cmd *Command, t pubsubType) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	channels := cmd.Args
+	if len(channels) == 0 {
+		channels = slices.Sorted(maps.Keys(t.clientChannels(c)))
+	}
+	if len(channels) == 0 {
+		return pubsubReplyFor(c, formatPubsubNoneReply(t.unsubscribeMsg, t.subscriptions(c)))
+	}
+
+	var replies []byte
+	for _, channel := range channels {
+		r.removeSubscriber(c, channel, t)
+		replies = append(replies, pubsubReplyFor(c, formatPubsubReply(t.unsubscribeMsg, channel, t.subscriptions(c)))...)
+	}
+	c.updateOutputLimit()
+	return replies
+}
+
+// psubscribe adds c to each pattern, see subscribe.
+func (r *Radisa) psubscribe(c *client, cmd *Command) []byte {
+	if len(cmd.Args) == 0 {
+		return FormatError("wrong number of arguments for 'psubscribe' command")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ensurePubsub(c)
+
+	for _, pattern := range cmd.Args {
+		if _, ok := c.patterns[pattern]; !ok {
+			c.patterns[pattern] = struct{}{}
+			if r.pubsubPatterns[pattern] == nil {
+				r.pubsubPatterns[pattern] = make(map[*client]struct{})
+				prefix := literalPrefix(pattern)
+				if r.patternsByPrefix[prefix] == nil {
+					r.patternsByPrefix[prefix] = make(map[string]struct{})
+				}
+				r.patternsByPrefix[prefix][pattern] = struct{}{}
+			}
+			r.pubsubPatterns[pattern][c] = struct{}{}
+		}
+		c.out.writeReply(pubsubReplyFor(c, formatPubsubReply("psubscribe", pattern, c.subscriptionCount())))
+	}
+	c.updateOutputLimit()
+	return nil
+}
+
+// punsubscribe removes c from the given patterns, or from all of them.
+func (r *Radisa) punsubscribe(c *client, cmd *Command) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	patterns := cmd.Args
+	if len(patterns) == 0 {
+		patterns = slices.Sorted(maps.Keys(c.patterns))
+	}
+	if len(patterns) == 0 {
+		return pubsubReplyFor(c, formatPubsubNoneReply("punsubscribe", c.subscriptionCount()))
+	}
+
+	var replies []byte
+	for _, pattern := range patterns {
+		r.removePatternSubscriber(c, pattern)
+		replies = append(replies, pubsubReplyFor(c, formatPubsubReply("punsubscribe", pattern, c.subscriptionCount()))...)
+	}
+	c.updateOutputLimit()
+	return replies
+}
+
+// unsubscribeAll drops every subscription of c. The caller holds mu.
+func (r *Radisa) unsubscribeAll(c *client) {
+	for channel := range c.channels {
+		r.removeSubscriber(c, channel, pubsubGlobal)
+	}
+	for channel := range c.shardChannels {
+		r.removeSubscriber(c, channel, pubsubShard)
+	}
+	for pattern := range c.patterns {
+		r.removePatternSubscriber(c, pattern)
+	}
+}
+
+// removeSubscriber takes c off channel. The caller holds mu.
+func (r *Radisa) removeSubscriber(c *client, channel string, t pubsubType) {
+	delete(t.clientChannels(c), channel)
+	serverChannels := t.serverChannels(r)
+	subscribers := serverChannels[channel]
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(serverChannels, channel)
+	}
+}
+
+// removePatternSubscriber takes c off pattern. The caller holds mu.
+func (r *Radisa) removePatternSubscriber(c *client, pattern string) {
+	delete(c.patterns, pattern)
+	subscribers, ok := r.pubsubPatterns[pattern]
+	if !ok {
+		return
+	}
+	delete(subscribers, c)
+	if len(subscribers) > 0 {
+		return
+	}
+
+	delete(r.pubsubPatterns, pattern)
+	prefix := literalPrefix(pattern)
+	delete(r.patternsByPrefix[prefix], pattern)
+	if len(r.patternsByPrefix[prefix]) == 0 {
+		delete(r.patternsByPrefix, prefix)
+	}
+}
+
+// literalPrefix is the part of pattern before its first special character,
+// which every channel it matches starts with.
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// publish queues message for every subscriber of channel and of a pattern
+// matching it, and returns how many deliveries that made. Patterns are
+// looked up by each prefix of channel, so only those that can match are
+// tried. A subscriber over its output limit is disconnected rather than
+// waited for. The caller holds mu.
+func (r *Radisa) publish(channel, message string) int {
+	receivers := r.publishChannel(channel, message, pubsubGlobal)
+	if len(r.patternsByPrefix) == 0 {
+		return receivers
+	}
+	for end := 0; end <= len(channel); end++ {
+		for pattern := range r.patternsByPrefix[channel[:end]] {
+			if !matchesGlob(channel, pattern) {
+				continue
+			}
+			msg := newPubsubMessage("pmessage", pattern, channel, message)
+			for c := range r.pubsubPatterns[pattern] {
+				c.out.write(msg.formatFor(c))
+				receivers++
+			}
+		}
+	}
+	return receivers
+}
+
+// publishChannel queues message for the subscribers of channel, without
+// patterns, and returns how many there are. The caller holds mu.
+func (r *Radisa) publishChannel(channel, message string, t pubsubType) int {
+	subscribers := t.serverChannels(r)[channel]
+	if len(subscribers) == 0 {
+		return 0
+	}
+
+	msg := newPubsubMessage(t.messageMsg, channel, message)
+	for c := range subscribers {
+		c.out.write(msg.formatFor(c))
+	}
+	return len(subscribers)
+}
+
+// pubsub implements PUBSUB CHANNELS, NUMSUB, NUMPAT, SHARDCHANNELS and
+// SHARDNUMSUB. The caller holds mu.
+func (r *Radisa) pubsub(args []string) []byte {
+	if len(args) == 0 {
+		return FormatError("wrong number of arguments for 'pubsub' command")
+	}
+
+	switch subcommand := strings.ToUpper(args[0]); subcommand {
+	case "CHANNELS", "SHARDCHANNELS":
+		if len(args) > 2 {
+			return FormatError("wrong number of arguments for 'pubsub|" + strings.ToLower(subcommand) + "' command")
+		}
+		registry := r.pubsubChannels
+		if subcommand == "SHARDCHANNELS" {
+			registry = r.pubsubShardChannels
+		}
+		channels := slices.Sorted(maps.Keys(registry))
+		if len(args) == 2 {
+			channels = slices.DeleteFunc(channels, func(channel string) bool { return !matchesGlob(channel, args[1]) })
+		}
+		return FormatArray(channels)
+
+	case "NUMSUB", "SHARDNUMSUB":
+		registry := r.pubsubChannels
+		if subcommand == "SHARDNUMSUB" {
+			registry = r.pubsubShardChannels
+		}
+		var replies [][]byte
+		for _, channel := range args[1:] {
+			replies = append(replies, FormatBulk(channel), FormatInteger(int64(len(registry[channel]))))
+		}
+		return FormatReplies(replies)
+
+	case "NUMPAT":
+		if len(args) != 1 {
+			return FormatError("wrong number of arguments for 'pubsub|numpat' command")
+		}
+		return FormatInteger(int64(len(r.pubsubPatterns)))
+	}
+	return FormatError("unknown subcommand '" + args[0] + "'. Try PUBSUB HELP.")
+}
+
+// pubsubMessage is a message formatted once for the subscribers speaking
+// RESP2, and once for those speaking RESP3 when the first of them needs it.
+type pubsubMessage struct {
+	array []byte
+	push  []byte
+}
+
+func newPubsubMessage(parts ...string) *pubsubMessage {
+	return &pubsubMessage{array: FormatArray(parts)}
+}
+
+func (m *pubsubMessage) formatFor(c *client) []byte {
+	if c.resp != 3 {
+		return m.array
+	}
+	if m.push == nil {
+		m.push = pubsubReplyFor(c, slices.Clone(m.array))
+	}
+	return m.push
+}
+
+// pubsubReplyFor turns a pub/sub array into the push a RESP3 client gets
+// instead, which only differs in its type. reply is changed in place.
+func pubsubReplyFor(c *client, reply []byte) []byte {
+	if c.resp == 3 {
+		reply[0] = '>'
+	}
+	return reply
+}
+
+// pubsubPing answers PING in RESP2 subscribe mode, where replies are arrays.
+func pubsubPing(cmd *Command) []byte {
+	message := ""
+	if len(cmd.Args) > 0 {
+		message = cmd.Args[0]
+	}
+	return FormatArray([]string{"pong", message})
+}
+
+// formatPubsubNoneReply answers an unsubscribe from nothing.
+func formatPubsubNoneReply(kind string, count int) []byte {
+	return FormatReplies([][]byte{FormatBulk(kind), FormatNullBulkString(), FormatInteger(int64(count))})
+}
+
+// formatPubsubReply formats the confirmation of a (un)subscription with the
+// number of subscriptions left.
+func formatPubsubReply(kind, channel string, count int) []byte {
+	return FormatReplies([][]byte{FormatBulk(kind), FormatBulk(channel), FormatInteger(int64(count))})
+}
@@ -0,0 +1,324 @@
+package radisa
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPubSub_SubscribePublishUnsubscribe(t *testing.T) {
+	r := createTestServer()
+	port := serveForTest(t, r)
+	sub, subReader := dialForTest(t, port)
+	pub, pubReader := dialForTest(t, port)
+
+	sub.Write(FormatCommand(&Command{Name: "SUBSCRIBE", Args: []string{"news", "sports"}}))
+	for i, channel := range []string{"news", "sports"} {
+		expected := string(formatPubsubReply("subscribe", channel, i+1))
+		if got := readReply(t, subReader); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+
+	if got := roundTrip(t, pub, pubReader, "PUBLISH", "news", "hello"); got != ":1\r\n" {
+		t.Errorf("Expected 1 receiver, got %q", got)
+	}
+	if got := roundTrip(t, pub, pubReader, "PUBLISH", "weather", "rain"); got != ":0\r\n" {
+		t.Errorf("Expected no receivers, got %q", got)
+	}
+	if got, expected := readReply(t, subReader), "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	pub.Write(FormatCommand(&Command{Name: "PUBSUB", Args: []string{"CHANNELS"}}))
+	if got, expected := readReply(t, pubReader), "*2\r\n$4\r\nnews\r\n$6\r\nsports\r\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	pub.Write(FormatCommand(&Command{Name: "PUBSUB", Args: []string{"CHANNELS", "n*"}}))
+	if got, expected := readReply(t, pubReader), "*1\r\n$4\r\nnews\r\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	pub.Write(FormatCommand(&Command{Name: "PUBSUB", Args: []string{"NUMSUB", "news", "weather"}}))
+	if got, expected := readReply(t, pubReader), "*4\r\n$4\r\nnews\r\n:1\r\n$7\r\nweather\r\n:0\r\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	// Subscribe mode only allows a few commands
+	got := roundTrip(t, sub, subReader, "GET", "k")
+	if !strings.HasPrefix(got, "-ERR Can't execute 'get'") {
+		t.Errorf("Expected subscribe mode error, got %q", got)
+	}
+	sub.Write(FormatCommand(&Command{Name: "PING"}))
+	if got, expected := readReply(t, subReader), "*2\r\n$4\r\npong\r\n$0\r\n\r\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	sub.Write(FormatCommand(&Command{Name: "UNSUBSCRIBE"}))
+	for i, channel := range []string{"news", "sports"} {
+		expected := string(formatPubsubReply("unsubscribe", channel, 1-i))
+		if got := readReply(t, subReader); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+	if got := roundTrip(t, sub, subReader, "GET", "k"); got != "$-1\r\n" {
+		t.Errorf("Expected GET to work again, got %q", got)
+	}
+}
+
+func TestPubSub_RESP3(t *testing.T) {
+	r := createTestServer()
+	port := serveForTest(t, r)
+	sub, subReader := dialForTest(t, port)
+	pub, pubReader := dialForTest(t, port)
+	commandForTest(t, sub, subReader, "HELLO", "3")
+
+	expected := ">3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"
+	if got := commandForTest(t, sub, subReader, "SUBSCRIBE", "news"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	commandForTest(t, sub, subReader, "PSUBSCRIBE", "n*")
+
+	// Messages are pushes, so any command can be run in between
+	if got := commandForTest(t, pub, pubReader, "PUBLISH", "news", "hello"); got != ":2\r\n" {
+		t.Errorf("Expected :2, got %q", got)
+	}
+	messages := []string{
+		">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+		">4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+	}
+	for _, expected := range messages {
+		if got := readReply(t, subReader); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+	if got := commandForTest(t, sub, subReader, "SET", "k", "v"); got != "+OK\r\n" {
+		t.Errorf("Expected SET to be allowed, got %q", got)
+	}
+	if got := commandForTest(t, sub, subReader, "PING"); got != "+PONG\r\n" {
+		t.Errorf("Expected a plain +PONG, got %q", got)
+	}
+
+	expected = ">3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:1\r\n"
+	if got := commandForTest(t, sub, subReader, "UNSUBSCRIBE"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestPubSub_SlowSubscriberIsDisconnected(t *testing.T) {
+	r := createTestServer()
+	sub, subReader := dialForTest(t, serveForTest(t, r))
+	sub.Write(FormatCommand(&Command{Name: "SUBSCRIBE", Args: []string{"firehose"}}))
+	readReply(t, subReader)
+
+	// The subscriber never reads, publishing still doesn't block
+	message := strings.Repeat("x", 1<<20)
+	start := time.Now()
+	for range 2 * pubsubOutputLimit >> 20 {
+		r.executeCommand(&Command{Name: "PUBLISH", Args: []string{"firehose", message}})
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected publishing not to wait for the subscriber, took %v", elapsed)
+	}
+
+	waitFor(t, "the subscriber to be dropped", func() bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return len(r.pubsubChannels) == 0
+	})
+}
+
+func TestPubSub_NoLimitAfterUnsubscribing(t *testing.T) {
+	r := createTestServer()
+	sub, subReader := dialForTest(t, serveForTest(t, r))
+	sub.Write(FormatCommand(&Command{Name: "SUBSCRIBE", Args: []string{"news"}}))
+	readReply(t, subReader)
+	sub.Write(FormatCommand(&Command{Name: "UNSUBSCRIBE"}))
+	readReply(t, subReader)
+
+	// A normal client again, so a reply over the pub/sub limit is sent
+	value := strings.Repeat("x", pubsubOutputLimit+1)
+	r.executeCommand(&Command{Name: "SET", Args: []string{"big", value}})
+	sub.Write(FormatCommand(&Command{Name: "GET", Args: []string{"big"}}))
+	expected := string(FormatBulk(value))
+	got := make([]byte, len(expected))
+	sub.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(subReader, got); err != nil {
+		t.Fatalf("Expected the whole value, got %v", err)
+	}
+	if string(got) != expected {
+		t.Errorf("Expected the value back, got %d other bytes", len(got))
+	}
+}
+
+func TestPubSub_Quit(t *testing.T) {
+	r := createTestServer()
+	sub, subReader := dialForTest(t, serveForTest(t, r))
+	sub.Write(FormatCommand(&Command{Name: "SUBSCRIBE", Args: []string{"news"}}))
+	readReply(t, subReader)
+
+	if got := roundTrip(t, sub, subReader, "QUIT"); got != "+OK\r\n" {
+		t.Errorf("Expected +OK, got %q", got)
+	}
+	sub.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := subReader.ReadByte(); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+}
+
+func TestPubSub_PatternSubscriptions(t *testing.T) {
+	r := createTestServer()
+	port := serveForTest(t, r)
+	sub, subReader := dialForTest(t, port)
+	other, otherReader := dialForTest(t, port)
+
+	sub.Write(FormatCommand(&Command{Name: "PSUBSCRIBE", Args: []string{"orders.*.updated", "*"}}))
+	readReply(t, subReader)
+	if got, expected := readReply(t, subReader), string(formatPubsubReply("psubscribe", "*", 2)); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	other.Write(FormatCommand(&Command{Name: "SUBSCRIBE", Args: []string{"orders.42.updated"}}))
+	readReply(t, otherReader)
+
+	// One delivery per matching subscription
+	got := string(r.executeCommand(&Command{Name: "PUBLISH", Args: []string{"orders.42.updated", "shipped"}}))
+	if got != ":3\r\n" {
+		t.Errorf("Expected 3 receivers, got %q", got)
+	}
+	received := map[string]bool{readReply(t, subReader): true, readReply(t, subReader): true}
+	for _, pattern := range []string{"orders.*.updated", "*"} {
+		msg := string(FormatArray([]string{"pmessage", pattern, "orders.42.updated", "shipped"}))
+		if !received[msg] {
+			t.Errorf("Expected %q, got %v", msg, received)
+		}
+	}
+	if got, expected := readReply(t, otherReader), "*3\r\n$7\r\nmessage\r\n$17\r\norders.42.updated\r\n$7\r\nshipped\r\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	if got := string(r.executeCommand(&Command{Name: "PUBSUB", Args: []string{"NUMPAT"}})); got != ":2\r\n" {
+		t.Errorf("Expected 2 patterns, got %q", got)
+	}
+	sub.Write(FormatCommand(&Command{Name: "PUNSUBSCRIBE"}))
+	readReply(t, subReader)
+	if got, expected := readReply(t, subReader), string(formatPubsubReply("punsubscribe", "orders.*.updated", 0)); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	r.mu.RLock()
+	if len(r.pubsubPatterns) != 0 || len(r.patternsByPrefix) != 0 {
+		t.Errorf("Expected no patterns left, got %v and %v", r.pubsubPatterns, r.patternsByPrefix)
+	}
+	r.mu.RUnlock()
+}
+
+func TestPubSub_ManyPatterns(t *testing.T) {
+	r := createTestServer()
+	sub, subReader := dialForTest(t, serveForTest(t, r))
+
+	patterns := make([]string, 5000)
+	for i := range patterns {
+		patterns[i] = "sensor." + strconv.Itoa(i) + ".*"
+	}
+	sub.Write(FormatCommand(&Command{Name: "PSUBSCRIBE", Args: patterns}))
+	for range patterns {
+		readReply(t, subReader)
+	}
+
+	if got := string(r.executeCommand(&Command{Name: "PUBLISH", Args: []string{"sensor.42.temp", "21"}})); got != ":1\r\n" {
+		t.Errorf("Expected 1 receiver, got %q", got)
+	}
+	if got, expected := readReply(t, subReader), string(FormatArray([]string{"pmessage", "sensor.42.*", "sensor.42.temp", "21"})); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestLiteralPrefix(t *testing.T) {
+	tests := map[string]string{
+		"orders.*.updated": "orders.",
+		"*":                "",
+		"news":             "news",
+		"a?c":              "a",
+	}
+	for pattern, expected := range tests {
+		if got := literalPrefix(pattern); got != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, pattern, got)
+		}
+	}
+}
+
+func TestKeyHashSlot(t *testing.T) {
+	if got := crc16("123456789"); got != 0x31C3 {
+		t.Errorf("Expected CRC16 0x31C3, got 0x%04X", got)
+	}
+
+	tests := map[string]int{
+		"foo":                  12182,
+		"bar":                  5061,
+		"{user1000}.following": keyHashSlot("user1000"),
+		"foo{}{bar}":           crc16Slot("foo{}{bar}"),
+		"{}foo":                crc16Slot("{}foo"),
+	}
+	for key, expected := range tests {
+		if got := keyHashSlot(key); got != expected {
+			t.Errorf("Expected slot %d for %q, got %d", expected, key, got)
+		}
+	}
+	if keyHashSlot("{user1000}.following") != keyHashSlot("{user1000}.followers") {
+		t.Errorf("Expected keys sharing a hash tag to share a slot")
+	}
+}
+
+func crc16Slot(key string) int {
+	return int(crc16(key)) % clusterSlots
+}
+
+func TestPubSub_ShardChannels(t *testing.T) {
+	r := createTestServer()
+	port := serveForTest(t, r)
+	sub, subReader := dialForTest(t, port)
+	global, globalReader := dialForTest(t, port)
+
+	if got := roundTrip(t, sub, subReader, "SSUBSCRIBE", "foo", "bar"); got != "-CROSSSLOT Keys in request don't hash to the same slot\r\n" {
+		t.Errorf("Expected CROSSSLOT, got %q", got)
+	}
+	sub.Write(FormatCommand(&Command{Name: "SSUBSCRIBE", Args: []string{"{orders}.eu", "{orders}.us"}}))
+	readReply(t, subReader)
+	if got, expected := readReply(t, subReader), string(formatPubsubReply("ssubscribe", "{orders}.us", 2)); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	global.Write(FormatCommand(&Command{Name: "SUBSCRIBE", Args: []string{"{orders}.eu"}}))
+	readReply(t, globalReader)
+
+	// Shard and global channels of the same name don't see each other
+	if got := string(r.executeCommand(&Command{Name: "SPUBLISH", Args: []string{"{orders}.eu", "a"}})); got != ":1\r\n" {
+		t.Errorf("Expected 1 shard receiver, got %q", got)
+	}
+	if got := string(r.executeCommand(&Command{Name: "PUBLISH", Args: []string{"{orders}.eu", "b"}})); got != ":1\r\n" {
+		t.Errorf("Expected 1 global receiver, got %q", got)
+	}
+	if got, expected := readReply(t, subReader), string(FormatArray([]string{"smessage", "{orders}.eu", "a"})); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if got, expected := readReply(t, globalReader), string(FormatArray([]string{"message", "{orders}.eu", "b"})); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	got := string(r.executeCommand(&Command{Name: "PUBSUB", Args: []string{"SHARDCHANNELS", "*.eu"}}))
+	if expected := string(FormatArray([]string{"{orders}.eu"})); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	got = string(r.executeCommand(&Command{Name: "PUBSUB", Args: []string{"SHARDNUMSUB", "{orders}.us"}}))
+	if expected := "*2\r\n$11\r\n{orders}.us\r\n:1\r\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	sub.Write(FormatCommand(&Command{Name: "SUNSUBSCRIBE"}))
+	readReply(t, subReader)
+	if got, expected := readReply(t, subReader), string(formatPubsubReply("sunsubscribe", "{orders}.us", 0)); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if got := roundTrip(t, sub, subReader, "GET", "k"); got != "$-1\r\n" {
+		t.Errorf("Expected to leave subscribe mode, got %q", got)
+	}
+}
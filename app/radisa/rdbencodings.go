@@ -0,0 +1,350 @@
+package radisa
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Decoders and encoders for the compact blobs redis stores inside RDB
+// strings: ziplist, listpack, intset and zipmap.
+
+// blobReader walks a byte slice; the first short read sticks in err.
+type blobReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *blobReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b)-r.pos {
+		r.err = fmt.Errorf("truncated at byte %d: need %d, have %d", r.pos, n, len(r.b)-r.pos)
+		return nil
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *blobReader) byte() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *blobReader) peek() byte {
+	if r.err == nil && r.pos >= len(r.b) {
+		r.err = fmt.Errorf("missing end marker")
+	}
+	if r.err != nil {
+		return 0xFF
+	}
+	return r.b[r.pos]
+}
+
+func (r *blobReader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *blobReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *blobReader) uint64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// decodeZiplist returns the entries of a ziplist, integers as decimal text.
+func decodeZiplist(blob []byte) ([]string, error) {
+	r := &blobReader{b: blob}
+	r.take(4) // zlbytes
+	r.take(4) // zltail
+	count := int(r.uint16())
+	if r.err != nil {
+		return nil, fmt.Errorf("ziplist header: %v", r.err)
+	}
+
+	entries := make([]string, 0, min(count, len(blob)))
+	for r.peek() != 0xFF {
+		// prevlen
+		if r.byte() == 0xFE {
+			r.take(4)
+		}
+
+		enc := r.byte()
+		switch {
+		case enc>>6 == 0: // 6 bit string length
+			entries = append(entries, string(r.take(int(enc&0x3F))))
+		case enc>>6 == 1: // 14 bit string length
+			n := int(enc&0x3F)<<8 | int(r.byte())
+			entries = append(entries, string(r.take(n)))
+		case enc == 0x80: // 32 bit big endian string length
+			b := r.take(4)
+			if b != nil {
+				entries = append(entries, string(r.take(int(binary.BigEndian.Uint32(b)))))
+			}
+		case enc == 0xC0:
+			entries = append(entries, strconv.Itoa(int(int16(r.uint16()))))
+		case enc == 0xD0:
+			entries = append(entries, strconv.Itoa(int(int32(r.uint32()))))
+		case enc == 0xE0:
+			entries = append(entries, strconv.FormatInt(int64(r.uint64()), 10))
+		case enc == 0xF0: // 24 bit signed
+			b := r.take(3)
+			if b != nil {
+				v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+				entries = append(entries, strconv.Itoa(int(v)))
+			}
+		case enc == 0xFE:
+			entries = append(entries, strconv.Itoa(int(int8(r.byte()))))
+		case enc >= 0xF1 && enc <= 0xFD: // immediate 0..12
+			entries = append(entries, strconv.Itoa(int(enc&0x0F)-1))
+		default:
+			return nil, fmt.Errorf("ziplist: unknown encoding 0x%02X at byte %d", enc, r.pos-1)
+		}
+
+		if r.err != nil {
+			return nil, fmt.Errorf("ziplist: %v", r.err)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("ziplist: %v", r.err)
+	}
+
+	return entries, nil
+}
+
+// listpackBacklenSize is how many bytes the back-length of an entry of
+// size bytes takes.
+func listpackBacklenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// decodeListpack returns the entries of a listpack, integers as decimal text.
+func decodeListpack(blob []byte) ([]string, error) {
+	r := &blobReader{b: blob}
+	r.take(4) // total bytes
+	count := int(r.uint16())
+	if r.err != nil {
+		return nil, fmt.Errorf("listpack header: %v", r.err)
+	}
+
+	entries := make([]string, 0, min(count, len(blob)))
+	for r.peek() != 0xFF {
+		start := r.pos
+		enc := r.byte()
+		switch {
+		case enc&0x80 == 0: // 7 bit unsigned
+			entries = append(entries, strconv.Itoa(int(enc)))
+		case enc&0xC0 == 0x80: // 6 bit string length
+			entries = append(entries, string(r.take(int(enc&0x3F))))
+		case enc&0xE0 == 0xC0: // 13 bit signed
+			v := int(enc&0x1F)<<8 | int(r.byte())
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			entries = append(entries, strconv.Itoa(v))
+		case enc&0xF0 == 0xE0: // 12 bit string length
+			n := int(enc&0x0F)<<8 | int(r.byte())
+			entries = append(entries, string(r.take(n)))
+		case enc == 0xF0: // 32 bit string length
+			n := r.uint32()
+			entries = append(entries, string(r.take(int(n))))
+		case enc == 0xF1:
+			entries = append(entries, strconv.Itoa(int(int16(r.uint16()))))
+		case enc == 0xF2:
+			b := r.take(3)
+			if b != nil {
+				v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+				entries = append(entries, strconv.Itoa(int(v)))
+			}
+		case enc == 0xF3:
+			entries = append(entries, strconv.Itoa(int(int32(r.uint32()))))
+		case enc == 0xF4:
+			entries = append(entries, strconv.FormatInt(int64(r.uint64()), 10))
+		default:
+			return nil, fmt.Errorf("listpack: unknown encoding 0x%02X at byte %d", enc, start)
+		}
+
+		r.take(listpackBacklenSize(r.pos - start))
+		if r.err != nil {
+			return nil, fmt.Errorf("listpack: %v", r.err)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("listpack: %v", r.err)
+	}
+
+	return entries, nil
+}
+
+// decodeIntset returns the members of an intset as decimal text.
+func decodeIntset(blob []byte) ([]string, error) {
+	r := &blobReader{b: blob}
+	width := int(r.uint32())
+	count := int(r.uint32())
+	if r.err != nil {
+		return nil, fmt.Errorf("intset header: %v", r.err)
+	}
+	if width != 2 && width != 4 && width != 8 {
+		return nil, fmt.Errorf("intset: invalid encoding %d", width)
+	}
+	if count > (len(blob)-8)/width {
+		return nil, fmt.Errorf("intset: %d members don't fit in %d bytes", count, len(blob))
+	}
+
+	members := make([]string, count)
+	for i := range members {
+		switch width {
+		case 2:
+			members[i] = strconv.Itoa(int(int16(r.uint16())))
+		case 4:
+			members[i] = strconv.Itoa(int(int32(r.uint32())))
+		case 8:
+			members[i] = strconv.FormatInt(int64(r.uint64()), 10)
+		}
+	}
+	return members, nil
+}
+
+// decodeZipmap returns the field/value pairs of a pre-2.6 zipmap hash.
+func decodeZipmap(blob []byte) (map[string]string, error) {
+	r := &blobReader{b: blob}
+	r.byte() // zmlen, only a hint
+
+	readLen := func() (int, bool) {
+		first := r.byte()
+		switch first {
+		case 0xFF:
+			return 0, false
+		case 0xFE:
+			return int(r.uint32()), true
+		default:
+			return int(first), true
+		}
+	}
+
+	hash := make(map[string]string)
+	for r.err == nil {
+		n, ok := readLen()
+		if !ok {
+			return hash, nil
+		}
+		field := string(r.take(n))
+
+		n, ok = readLen()
+		if !ok {
+			return nil, errors.New("zipmap: field without value")
+		}
+		free := int(r.byte())
+		value := string(r.take(n))
+		r.take(free)
+
+		hash[field] = value
+	}
+	return nil, fmt.Errorf("zipmap: %v", r.err)
+}
+
+// listpackBuilder encodes entries the way lpAppend does.
+type listpackBuilder struct {
+	buf   []byte
+	count int
+}
+
+func newListpackBuilder() *listpackBuilder {
+	return &listpackBuilder{buf: make([]byte, 6, 64)}
+}
+
+// appendString stores canonical decimal numbers as integers.
+func (lp *listpackBuilder) appendString(s string) {
+	if len(s) <= 20 {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+			lp.appendInt(n)
+			return
+		}
+	}
+
+	start := len(lp.buf)
+	switch n := len(s); {
+	case n < 64:
+		lp.buf = append(lp.buf, 0x80|byte(n))
+	case n < 4096:
+		lp.buf = append(lp.buf, 0xE0|byte(n>>8), byte(n))
+	default:
+		lp.buf = append(lp.buf, 0xF0)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(n))
+	}
+	lp.buf = append(lp.buf, s...)
+	lp.finishEntry(start)
+}
+
+func (lp *listpackBuilder) appendInt(v int64) {
+	start := len(lp.buf)
+	switch {
+	case v >= 0 && v <= 127:
+		lp.buf = append(lp.buf, byte(v))
+	case v >= -4096 && v <= 4095:
+		u := uint16(v) & 0x1FFF
+		lp.buf = append(lp.buf, 0xC0|byte(u>>8), byte(u))
+	case v >= -1<<15 && v < 1<<15:
+		lp.buf = append(lp.buf, 0xF1)
+		lp.buf = binary.LittleEndian.AppendUint16(lp.buf, uint16(v))
+	case v >= -1<<23 && v < 1<<23:
+		u := uint32(v)
+		lp.buf = append(lp.buf, 0xF2, byte(u), byte(u>>8), byte(u>>16))
+	case v >= -1<<31 && v < 1<<31:
+		lp.buf = append(lp.buf, 0xF3)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(v))
+	default:
+		lp.buf = append(lp.buf, 0xF4)
+		lp.buf = binary.LittleEndian.AppendUint64(lp.buf, uint64(v))
+	}
+	lp.finishEntry(start)
+}
+
+// finishEntry appends the back-length: the entry size in 7 bit groups,
+// most significant first, with the high bit set on all but the first byte.
+func (lp *listpackBuilder) finishEntry(start int) {
+	size := len(lp.buf) - start
+	n := listpackBacklenSize(size)
+	for i := 0; i < n; i++ {
+		b := byte(size>>(7*(n-1-i))) & 127
+		if i > 0 {
+			b |= 128
+		}
+		lp.buf = append(lp.buf, b)
+	}
+	lp.count++
+}
+
+func (lp *listpackBuilder) bytes() []byte {
+	out := append(lp.buf, 0xFF)
+	binary.LittleEndian.PutUint32(out[0:4], uint32(len(out)))
+	binary.LittleEndian.PutUint16(out[4:6], uint16(min(lp.count, 65535)))
+	return out
+}
@@ -0,0 +1,280 @@
+package radisa
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rdbWithValue wraps one encoded key-value pair in a minimal RDB file. The
+// checksum is left at zero, which the parser accepts without verifying.
+func rdbWithValue(valueType byte, key string, value []byte) []byte {
+	file := []byte("REDIS0011\xfe\x00")
+	file = append(file, valueType, byte(len(key)))
+	file = append(file, key...)
+	file = append(file, value...)
+	file = append(file, 0xFF)
+	return append(file, make([]byte, 8)...)
+}
+
+// rdbString length-prefixes a blob shorter than 64 bytes.
+func rdbString(blob []byte) []byte {
+	return append([]byte{byte(len(blob))}, blob...)
+}
+
+var (
+	// "a", 12, -1, 1000
+	testZiplist = []byte{
+		0x17, 0, 0, 0, 0x12, 0, 0, 0, 0x04, 0,
+		0x00, 0x01, 'a',
+		0x03, 0xFD,
+		0x02, 0xFE, 0xFF,
+		0x03, 0xC0, 0xE8, 0x03,
+		0xFF,
+	}
+	// "a", 5, -1, 300, "hello"
+	testListpack = []byte{
+		0x19, 0, 0, 0, 0x05, 0,
+		0x81, 'a', 0x02,
+		0x05, 0x01,
+		0xDF, 0xFF, 0x02,
+		0xC1, 0x2C, 0x02,
+		0x85, 'h', 'e', 'l', 'l', 'o', 0x06,
+		0xFF,
+	}
+	// -2, 5, 10
+	testIntset = []byte{0x02, 0, 0, 0, 0x03, 0, 0, 0, 0xFE, 0xFF, 0x05, 0x00, 0x0A, 0x00}
+	// foo => bar
+	testZipmap = []byte{0x01, 0x03, 'f', 'o', 'o', 0x03, 0x00, 'b', 'a', 'r', 0xFF}
+)
+
+func TestDecodeZiplist(t *testing.T) {
+	result, err := decodeZiplist(testZiplist)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []string{"a", "12", "-1", "1000"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestDecodeListpack(t *testing.T) {
+	result, err := decodeListpack(testListpack)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []string{"a", "5", "-1", "300", "hello"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestDecodeIntset(t *testing.T) {
+	result, err := decodeIntset(testIntset)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []string{"-2", "5", "10"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestDecodeZipmap(t *testing.T) {
+	result, err := decodeZipmap(testZipmap)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := map[string]string{"foo": "bar"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestDecode_Truncated(t *testing.T) {
+	for i := 0; i < len(testListpack)-1; i++ {
+		if _, err := decodeListpack(testListpack[:i]); err == nil {
+			t.Errorf("Expected listpack error for %d bytes", i)
+		}
+	}
+	for i := 0; i < len(testZiplist)-1; i++ {
+		if _, err := decodeZiplist(testZiplist[:i]); err == nil {
+			t.Errorf("Expected ziplist error for %d bytes", i)
+		}
+	}
+}
+
+func TestListpackBuilder_RoundTrip(t *testing.T) {
+	items := []string{
+		"0", "127", "128", "-1", "-4096", "4095", "-32768", "32767",
+		"8388607", "-8388608", "2147483647", "-2147483648", "9223372036854775807",
+		"007", "", "short", strings.Repeat("m", 200), strings.Repeat("l", 5000),
+	}
+
+	lp := newListpackBuilder()
+	for _, item := range items {
+		lp.appendString(item)
+	}
+
+	result, err := decodeListpack(lp.bytes())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(result, items) {
+		t.Errorf("Expected %v, got %v", items, result)
+	}
+}
+
+func TestListpackBuilder_MatchesRedis(t *testing.T) {
+	lp := newListpackBuilder()
+	for _, item := range []string{"a", "5", "-1", "300", "hello"} {
+		lp.appendString(item)
+	}
+
+	if result := lp.bytes(); !bytes.Equal(result, testListpack) {
+		t.Errorf("Expected %x, got %x", testListpack, result)
+	}
+}
+
+func TestRDBParser_CompactEncodings(t *testing.T) {
+	quicklist := append([]byte{0x02}, rdbString(testZiplist)...)
+	quicklist = append(quicklist, rdbString(testZiplist)...)
+
+	quicklist2 := []byte{0x02, quicklistNodePacked}
+	quicklist2 = append(quicklist2, rdbString(testListpack)...)
+	quicklist2 = append(quicklist2, quicklistNodePlain)
+	quicklist2 = append(quicklist2, rdbString([]byte("plain"))...)
+
+	// member "m" score 1.5, member "n" score 3, as ziplist and listpack
+	zsetZiplist := []byte{
+		0x18, 0, 0, 0, 0x15, 0, 0, 0, 0x04, 0,
+		0x00, 0x01, 'm',
+		0x03, 0x03, '1', '.', '5',
+		0x05, 0x01, 'n',
+		0x03, 0xF4,
+		0xFF,
+	}
+	zsetListpack := newListpackBuilder()
+	for _, item := range []string{"m", "1.5", "n", "3"} {
+		zsetListpack.appendString(item)
+	}
+
+	// legacy ZSET with text scores: "m" 1.5, "inf" +inf
+	zsetText := []byte{0x02, 0x01, 'm', 0x03, '1', '.', '5', 0x03, 'i', 'n', 'f', 254}
+
+	cases := []struct {
+		name      string
+		valueType byte
+		value     []byte
+		expected  Data
+	}{
+		{"zipmap", rdbTypeHashZipmap, rdbString(testZipmap), Data{kind: kindHash, hash: map[string]string{"foo": "bar"}}},
+		{"ziplist", rdbTypeListZiplist, rdbString(testZiplist), Data{kind: kindList, list: []string{"a", "12", "-1", "1000"}}},
+		{"intset", rdbTypeSetIntset, rdbString(testIntset), Data{kind: kindSet, list: []string{"-2", "5", "10"}}},
+		{"set listpack", rdbTypeSetListpack, rdbString(testListpack), Data{kind: kindSet, list: []string{"a", "5", "-1", "300", "hello"}}},
+		{"hash ziplist", rdbTypeHashZiplist, rdbString(testZiplist), Data{kind: kindHash, hash: map[string]string{"a": "12", "-1": "1000"}}},
+		{"zset ziplist", rdbTypeZSetZiplist, rdbString(zsetZiplist), Data{kind: kindZSet, zset: map[string]float64{"m": 1.5, "n": 3}}},
+		{"zset listpack", rdbTypeZSetListpack, rdbString(zsetListpack.bytes()), Data{kind: kindZSet, zset: map[string]float64{"m": 1.5, "n": 3}}},
+		{"zset text scores", rdbTypeZSet, zsetText, Data{kind: kindZSet, zset: map[string]float64{"m": 1.5, "inf": math.Inf(1)}}},
+		{"quicklist", rdbTypeListQuicklist, quicklist, Data{kind: kindList, list: []string{"a", "12", "-1", "1000", "a", "12", "-1", "1000"}}},
+		{"quicklist 2", rdbTypeListQuicklist2, quicklist2, Data{kind: kindList, list: []string{"a", "5", "-1", "300", "hello", "plain"}}},
+	}
+
+	for _, c := range cases {
+		result, err := NewRDBParser(rdbWithValue(c.valueType, "key", c.value)).Parse()
+		if err != nil {
+			t.Errorf("%s: expected no error, got: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(result["key"], c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.expected, result["key"])
+		}
+	}
+}
+
+func TestRDBParser_UnknownTypeKeepsEarlierKeys(t *testing.T) {
+	file := []byte("REDIS0011\xfe\x00\x00\x01a\x01b\x06\x01c\x00\xff")
+	file = append(file, make([]byte, 8)...)
+
+	result, err := NewRDBParser(file).Parse()
+	if err == nil {
+		t.Fatal("Expected error for module type 6")
+	}
+	if result["a"].value != "b" {
+		t.Errorf("Expected a=b before the bad key, got %v", result)
+	}
+}
+
+func TestRDBParser_SkipsModule2Values(t *testing.T) {
+	// type 7: module id, an unsigned int field, a string field, EOF
+	module := []byte{0x05, rdbModuleOpUInt, 0x2A, rdbModuleOpString, 0x01, 'x', rdbModuleOpEOF}
+	file := []byte("REDIS0011\xfe\x00\x07\x03mod")
+	file = append(file, module...)
+	file = append(file, "\x00\x01a\x01b\xff"...)
+	file = append(file, make([]byte, 8)...)
+
+	result, err := NewRDBParser(file).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, exists := result["mod"]; exists || result["a"].value != "b" {
+		t.Errorf("Expected only a=b, got %v", result)
+	}
+}
+
+func TestRDBWriter_ZSetAndStreamRoundTrip(t *testing.T) {
+	now := time.Now().UnixMilli()
+	s := &stream{
+		entries: []streamEntry{
+			{id: streamID{1000, 0}, fields: []string{"temp", "20", "unit", "c"}},
+			{id: streamID{1000, 1}, fields: []string{"temp", "21", "unit", "c"}},
+			{id: streamID{1005, 0}, fields: []string{"humidity", "40"}},
+		},
+		lastID:       streamID{1005, 0},
+		firstID:      streamID{1000, 0},
+		entriesAdded: 3,
+		groups: []streamGroup{{
+			name:        "workers",
+			lastID:      streamID{1000, 1},
+			entriesRead: 2,
+			pending: []streamPendingEntry{
+				{id: streamID{1000, 0}, consumer: "alice", deliveryTime: now, deliveryCount: 1},
+				{id: streamID{1000, 1}, consumer: "bob", deliveryTime: now, deliveryCount: 3},
+			},
+			consumers: []streamConsumer{
+				{name: "alice", seenTime: now, activeTime: now},
+				{name: "bob", seenTime: now, activeTime: now - 5},
+			},
+		}},
+	}
+	for i := 0; i < 250; i++ {
+		s.entries = append(s.entries, streamEntry{id: streamID{2000 + uint64(i), 0}, fields: []string{"n", "x"}})
+	}
+	s.lastID = s.entries[len(s.entries)-1].id
+
+	data := map[string]Data{
+		"zset":   {kind: kindZSet, zset: map[string]float64{"a": 1, "b": -2.5, "c": math.Inf(1)}},
+		"stream": {kind: kindStream, stream: s},
+	}
+
+	var buf bytes.Buffer
+	if err := NewRDBWriter(&buf, true).Write(data); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	result, err := NewRDBParser(buf.Bytes()).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(result, data) {
+		t.Errorf("Expected %+v, got %+v", data, result)
+	}
+}
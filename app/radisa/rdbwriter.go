@@ -0,0 +1,370 @@
+package radisa
+
+import (
+	"cmp"
+	"encoding/binary"
+	"io"
+	"math"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rdbVersion matches the dumps redis 7.2 produces, see dump.rdb.
+const rdbVersion = "0011"
+
+// RDBWriter encodes a keyspace in the format RDBParser reads. The first
+// write error sticks and is returned by Write.
+type RDBWriter struct {
+	w        io.Writer
+	compress bool
+	crc      uint64
+	err      error
+}
+
+// NewRDBWriter returns a writer that LZF-compresses long strings when
+// compress is set, like rdbcompression in redis.conf.
+func NewRDBWriter(w io.Writer, compress bool) *RDBWriter {
+	return &RDBWriter{w: w, compress: compress}
+}
+
+func (w *RDBWriter) Write(data map[string]Data) error {
+	w.writeHeader()
+	w.writeMetadata()
+	w.writeDatabase(0, data)
+	w.writeEOF()
+	return w.err
+}
+
+func (w *RDBWriter) writeHeader() {
+	w.write([]byte("REDIS" + rdbVersion))
+}
+
+func (w *RDBWriter) writeMetadata() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	w.writeAux("redis-ver", "7.2.0")
+	w.writeAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	w.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	w.writeAux("used-mem", strconv.FormatUint(mem.HeapAlloc, 10))
+	w.writeAux("aof-base", "0")
+}
+
+func (w *RDBWriter) writeAux(name, value string) {
+	w.write([]byte{rdbOpAux})
+	w.writeString(name)
+	w.writeString(value)
+}
+
+func (w *RDBWriter) writeDatabase(index int, data map[string]Data) {
+	now := time.Now()
+	keys := make([]string, 0, len(data))
+	expires := 0
+	for key, value := range data {
+		if !value.expire.IsZero() {
+			// Keys that already expired are dropped instead of being saved
+			if now.After(value.expire) {
+				continue
+			}
+			expires++
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return
+	}
+	slices.Sort(keys)
+
+	w.write([]byte{rdbOpSelectDB})
+	w.writeSize(uint64(index))
+	w.write([]byte{rdbOpResizeDB})
+	w.writeSize(uint64(len(keys)))
+	w.writeSize(uint64(expires))
+
+	for _, key := range keys {
+		w.writeKeyValue(key, data[key])
+	}
+}
+
+func (w *RDBWriter) writeKeyValue(key string, value Data) {
+	if !value.expire.IsZero() {
+		var ms [8]byte
+		binary.LittleEndian.PutUint64(ms[:], uint64(value.expire.UnixMilli()))
+		w.write([]byte{rdbOpExpireTimeMS})
+		w.write(ms[:])
+	}
+
+	switch value.kind {
+	case kindList:
+		w.write([]byte{rdbTypeList})
+		w.writeString(key)
+		w.writeStringList(value.list)
+	case kindSet:
+		w.write([]byte{rdbTypeSet})
+		w.writeString(key)
+		w.writeStringList(value.list)
+	case kindHash:
+		w.write([]byte{rdbTypeHash})
+		w.writeString(key)
+		fields := make([]string, 0, len(value.hash))
+		for field := range value.hash {
+			fields = append(fields, field)
+		}
+		slices.Sort(fields)
+		w.writeSize(uint64(len(fields)))
+		for _, field := range fields {
+			w.writeString(field)
+			w.writeString(value.hash[field])
+		}
+	case kindZSet:
+		w.write([]byte{rdbTypeZSet2})
+		w.writeString(key)
+		w.writeZSet(value.zset)
+	case kindStream:
+		w.write([]byte{rdbTypeStreamListpacks3})
+		w.writeString(key)
+		w.writeStream(value.stream)
+	default:
+		w.write([]byte{rdbTypeString})
+		w.writeString(key)
+		w.writeString(value.value)
+	}
+}
+
+func (w *RDBWriter) writeStringList(list []string) {
+	w.writeSize(uint64(len(list)))
+	for _, s := range list {
+		w.writeString(s)
+	}
+}
+
+// writeZSet writes members in score order with binary scores, as ZSET_2.
+func (w *RDBWriter) writeZSet(zset map[string]float64) {
+	members := make([]string, 0, len(zset))
+	for member := range zset {
+		members = append(members, member)
+	}
+	slices.SortFunc(members, func(a, b string) int {
+		if c := cmp.Compare(zset[a], zset[b]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+
+	w.writeSize(uint64(len(members)))
+	for _, member := range members {
+		var score [8]byte
+		binary.LittleEndian.PutUint64(score[:], math.Float64bits(zset[member]))
+		w.writeString(member)
+		w.write(score[:])
+	}
+}
+
+// streamNodeMaxEntries matches stream-node-max-entries' default.
+const streamNodeMaxEntries = 100
+
+// writeStream writes a STREAM_LISTPACKS_3 value: listpack nodes keyed by
+// their first id, then the stream metadata and consumer groups.
+func (w *RDBWriter) writeStream(s *stream) {
+	if s == nil {
+		s = &stream{}
+	}
+
+	nodes := (len(s.entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	w.writeSize(uint64(nodes))
+	for start := 0; start < len(s.entries); start += streamNodeMaxEntries {
+		node := s.entries[start:min(start+streamNodeMaxEntries, len(s.entries))]
+		w.writeString(string(rawStreamID(node[0].id)))
+		w.writeString(string(encodeStreamNode(node)))
+	}
+
+	w.writeSize(uint64(len(s.entries)))
+	w.writeStreamID(s.lastID)
+	w.writeStreamID(s.firstID)
+	w.writeStreamID(s.maxDeletedID)
+	w.writeSize(s.entriesAdded)
+
+	w.writeSize(uint64(len(s.groups)))
+	for _, g := range s.groups {
+		w.writeString(g.name)
+		w.writeStreamID(g.lastID)
+		w.writeSize(uint64(g.entriesRead))
+
+		w.writeSize(uint64(len(g.pending)))
+		for _, pe := range g.pending {
+			w.write(rawStreamID(pe.id))
+			w.writeMillis(pe.deliveryTime)
+			w.writeSize(pe.deliveryCount)
+		}
+
+		w.writeSize(uint64(len(g.consumers)))
+		for _, c := range g.consumers {
+			w.writeString(c.name)
+			w.writeMillis(c.seenTime)
+			w.writeMillis(c.activeTime)
+
+			var owned []streamID
+			for _, pe := range g.pending {
+				if pe.consumer == c.name {
+					owned = append(owned, pe.id)
+				}
+			}
+			w.writeSize(uint64(len(owned)))
+			for _, id := range owned {
+				w.write(rawStreamID(id))
+			}
+		}
+	}
+}
+
+// encodeStreamNode builds the listpack decodeStreamNode reads, using the
+// first entry's fields as the master fields.
+func encodeStreamNode(entries []streamEntry) []byte {
+	master := entries[0].id
+	var masterFields []string
+	for i := 0; i < len(entries[0].fields); i += 2 {
+		masterFields = append(masterFields, entries[0].fields[i])
+	}
+
+	lp := newListpackBuilder()
+	lp.appendInt(int64(len(entries))) // count
+	lp.appendInt(0)                   // deleted
+	lp.appendInt(int64(len(masterFields)))
+	for _, field := range masterFields {
+		lp.appendString(field)
+	}
+	lp.appendInt(0)
+
+	for _, entry := range entries {
+		sameFields := len(entry.fields) == len(masterFields)*2
+		for i := 0; sameFields && i < len(masterFields); i++ {
+			sameFields = entry.fields[i*2] == masterFields[i]
+		}
+
+		flags := int64(0)
+		if sameFields {
+			flags = streamItemFlagSameFields
+		}
+		lp.appendInt(flags)
+		lp.appendInt(int64(entry.id.ms - master.ms))
+		lp.appendInt(int64(entry.id.seq - master.seq))
+
+		var lpCount int
+		if sameFields {
+			for i := 1; i < len(entry.fields); i += 2 {
+				lp.appendString(entry.fields[i])
+			}
+			lpCount = len(masterFields)
+		} else {
+			lp.appendInt(int64(len(entry.fields) / 2))
+			for _, item := range entry.fields {
+				lp.appendString(item)
+			}
+			lpCount = len(entry.fields) + 1
+		}
+		lp.appendInt(int64(lpCount + 3))
+	}
+
+	return lp.bytes()
+}
+
+func rawStreamID(id streamID) []byte {
+	raw := make([]byte, 16)
+	binary.BigEndian.PutUint64(raw[:8], id.ms)
+	binary.BigEndian.PutUint64(raw[8:], id.seq)
+	return raw
+}
+
+func (w *RDBWriter) writeStreamID(id streamID) {
+	w.writeSize(id.ms)
+	w.writeSize(id.seq)
+}
+
+func (w *RDBWriter) writeMillis(ms int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(ms))
+	w.write(buf[:])
+}
+
+// writeEOF writes the end marker followed by the checksum of everything before it
+func (w *RDBWriter) writeEOF() {
+	w.write([]byte{rdbOpEOF})
+
+	var checksum [8]byte
+	binary.LittleEndian.PutUint64(checksum[:], w.crc)
+	w.write(checksum[:])
+}
+
+// writeSize uses the 6, 14, 32 or 64 bit length encoding readSize understands
+func (w *RDBWriter) writeSize(size uint64) {
+	switch {
+	case size < 1<<6:
+		w.write([]byte{byte(size)})
+	case size < 1<<14:
+		w.write([]byte{0x40 | byte(size>>8), byte(size)})
+	case size <= math.MaxUint32:
+		var buf [5]byte
+		buf[0] = 0x80
+		binary.BigEndian.PutUint32(buf[1:], uint32(size))
+		w.write(buf[:])
+	default:
+		var buf [9]byte
+		buf[0] = 0x81
+		binary.BigEndian.PutUint64(buf[1:], size)
+		w.write(buf[:])
+	}
+}
+
+// writeString stores short decimal numbers as integers and, with
+// compression on, strings over 20 bytes as LZF when that saves space, like
+// redis does. Everything else is a length-prefixed string.
+func (w *RDBWriter) writeString(s string) {
+	if len(s) <= 11 {
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+			w.writeIntString(n)
+			return
+		}
+	}
+
+	if w.compress && len(s) > 20 {
+		// redis only keeps the result if it saves at least 4 bytes
+		if compressed := lzfCompress([]byte(s), len(s)-4); compressed != nil {
+			w.write([]byte{0xC0 | rdbEncLZF})
+			w.writeSize(uint64(len(compressed)))
+			w.writeSize(uint64(len(s)))
+			w.write(compressed)
+			return
+		}
+	}
+
+	w.writeSize(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+func (w *RDBWriter) writeIntString(n int64) {
+	switch {
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		w.write([]byte{0xC0 | rdbEncInt8, byte(int8(n))})
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		var buf [3]byte
+		buf[0] = 0xC0 | rdbEncInt16
+		binary.LittleEndian.PutUint16(buf[1:], uint16(int16(n)))
+		w.write(buf[:])
+	default:
+		var buf [5]byte
+		buf[0] = 0xC0 | rdbEncInt32
+		binary.LittleEndian.PutUint32(buf[1:], uint32(int32(n)))
+		w.write(buf[:])
+	}
+}
+
+func (w *RDBWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc = crc64Jones(w.crc, b)
+	_, w.err = w.w.Write(b)
+}
@@ -0,0 +1,95 @@
+package radisa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCRC64Jones_CheckValue(t *testing.T) {
+	result := crc64Jones(0, []byte("123456789"))
+	var expected uint64 = 0xe9c6d914c4b8d9ca
+
+	if result != expected {
+		t.Errorf("Expected %x, got %x", expected, result)
+	}
+}
+
+// See TestRDBParser_CodecraftersDump for where the fixture comes from.
+func TestCRC64Jones_CodecraftersDump(t *testing.T) {
+	file, err := os.ReadFile("testdata/codecrafters-dump.rdb")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	body := file[:len(file)-8]
+	expected := binary.LittleEndian.Uint64(file[len(file)-8:])
+	if result := crc64Jones(0, body); result != expected {
+		t.Errorf("Expected %x, got %x", expected, result)
+	}
+}
+
+func TestRDBWriter_RoundTrip(t *testing.T) {
+	expire := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	data := map[string]Data{
+		"str":      {value: "hello"},
+		"int8":     {value: "-12"},
+		"int16":    {value: "1000"},
+		"int32":    {value: "-100000"},
+		"notint":   {value: "007"},
+		"long":     {value: string(bytes.Repeat([]byte("x"), 300))},
+		"expiring": {value: "soon", expire: expire},
+		"list":     {kind: kindList, list: []string{"a", "b", "a"}},
+		"set":      {kind: kindSet, list: []string{"x", "y"}},
+		"hash":     {kind: kindHash, hash: map[string]string{"f1": "v1", "f2": "2"}},
+	}
+
+	var buf bytes.Buffer
+	if err := NewRDBWriter(&buf, true).Write(data); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	result, err := NewRDBParser(buf.Bytes()).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(result, data) {
+		t.Errorf("Expected %v, got %v", data, result)
+	}
+}
+
+func TestRDBWriter_SkipsExpiredKeys(t *testing.T) {
+	data := map[string]Data{
+		"alive": {value: "1"},
+		"dead":  {value: "2", expire: time.Now().Add(-time.Second)},
+	}
+
+	var buf bytes.Buffer
+	if err := NewRDBWriter(&buf, true).Write(data); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	result, err := NewRDBParser(buf.Bytes()).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, exists := result["dead"]; exists || len(result) != 1 {
+		t.Errorf("Expected only 'alive', got %v", result)
+	}
+}
+
+func TestRDBWriter_Checksum(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewRDBWriter(&buf, true).Write(map[string]Data{"foo": {value: "bar"}}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	file := buf.Bytes()
+	expected := crc64Jones(0, file[:len(file)-8])
+	if result := binary.LittleEndian.Uint64(file[len(file)-8:]); result != expected {
+		t.Errorf("Expected %x, got %x", expected, result)
+	}
+}
@@ -0,0 +1,534 @@
+package radisa
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long the replica waits before reconnecting to a master it lost. The
+// delay doubles after every failed attempt.
+const (
+	replMinBackoff = 500 * time.Millisecond
+	replMaxBackoff = 30 * time.Second
+	// replTimeout matches repl-timeout: a master silent for this long is
+	// considered gone. Masters PING their replicas well within it.
+	replTimeout = 60 * time.Second
+)
+
+var errReplicationTargetChanged = errors.New("replication target changed")
+
+// replicaof implements REPLICAOF host port and REPLICAOF NO ONE. The switch
+// happens in one critical section, so clients never see a half updated
+// role, replid or offset. The caller holds mu.
+func (r *Radisa) replicaof(args []string) []byte {
+	if len(args) != 2 {
+		return FormatError("wrong number of arguments for 'replicaof' command")
+	}
+
+	if strings.EqualFold(args[0], "no") && strings.EqualFold(args[1], "one") {
+		if r.replicaOf != nil {
+			r.promote()
+			fmt.Println("MASTER MODE enabled")
+		}
+		return FormatSimpleString("OK")
+	}
+
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port < 0 || port > 65535 {
+		return FormatError("Invalid master port")
+	}
+	if link := r.replicaOf; link != nil && link.masterHost == args[0] && link.masterPort == port {
+		return FormatSimpleString("OK Already connected to specified master")
+	}
+
+	link := &ReplicaOf{masterHost: args[0], masterPort: port}
+	if r.replicaOf != nil {
+		r.dropMasterLink()
+		// Our stream is the old master's, the new one may be able to continue it
+		link.cached = r.replicaOf.cached
+	} else {
+		// Our own history is what a promoted sibling knows as replid2
+		link.cached = r.backlog != nil
+	}
+	r.replicaOf = link
+	r.disconnectReplicas()
+	go r.replicate(link)
+
+	fmt.Printf("REPLICAOF %s:%d enabled\n", link.masterHost, link.masterPort)
+	return FormatSimpleString("OK")
+}
+
+// promote turns a replica into a master. The stream so far keeps going under
+// a new replid; the old one becomes replID2 so replicas of the old master
+// can continue from us with a partial sync. The caller holds mu.
+func (r *Radisa) promote() {
+	r.dropMasterLink()
+	r.replicaOf = nil
+
+	r.replID2 = r.replID
+	r.secondReplOffset = r.replOffset + 1
+	r.replID = newReplID()
+	if r.backlog == nil {
+		r.backlog = newReplBacklog(r.config.ReplBacklogSize, r.replOffset+1)
+	}
+
+	// Our replicas reconnect and learn the new replid through +CONTINUE
+	r.disconnectReplicas()
+}
+
+// dropMasterLink closes the connection to the current master. Its
+// replicate loop notices replicaOf changed and exits. The caller holds mu.
+func (r *Radisa) dropMasterLink() {
+	if link := r.replicaOf; link != nil && link.conn != nil {
+		link.conn.Close()
+	}
+}
+
+// replicate keeps link in sync with its master until REPLICAOF points the
+// server elsewhere.
+func (r *Radisa) replicate(link *ReplicaOf) {
+	backoff := replMinBackoff
+	for r.isCurrentLink(link) {
+		synced, err := r.syncWithMaster(link)
+		if !r.isCurrentLink(link) {
+			return
+		}
+		fmt.Printf("Connection with master %s:%d lost: %v\n", link.masterHost, link.masterPort, err)
+
+		if synced {
+			backoff = replMinBackoff
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, replMaxBackoff)
+	}
+}
+
+func (r *Radisa) isCurrentLink(link *ReplicaOf) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.replicaOf == link
+}
+
+// syncWithMaster performs the handshake, syncs with the master and then
+// applies the command stream until the connection breaks. synced reports
+// whether the sync succeeded, which resets the reconnect backoff.
+func (r *Radisa) syncWithMaster(link *ReplicaOf) (synced bool, err error) {
+	addr := net.JoinHostPort(link.masterHost, strconv.Itoa(link.masterPort))
+	conn, err := net.DialTimeout("tcp", addr, replTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	r.mu.Lock()
+	if r.replicaOf != link {
+		r.mu.Unlock()
+		return false, errReplicationTargetChanged
+	}
+	link.conn = conn
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		link.conn = nil
+		link.linkUp = false
+		r.mu.Unlock()
+	}()
+
+	reader := bufio.NewReader(&replIOTracker{conn: conn, link: link})
+	if err := replHandshake(conn, reader, r.Port, r.config.MasterAuth); err != nil {
+		return false, err
+	}
+
+	if err := r.psync(conn, reader, link); err != nil {
+		return false, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go r.sendAcks(conn, done)
+
+	return true, r.applyMasterStream(conn, reader, link)
+}
+
+// replHandshake introduces the replica the way redis does before PSYNC,
+// authenticating with masterauth if it is set.
+func replHandshake(conn net.Conn, reader *bufio.Reader, port int, masterauth string) error {
+	// A master that wants a password answers PING with -NOAUTH, that's
+	// still a sign of life
+	reply, err := replRequest(conn, reader, &Command{Name: "PING"})
+	if err != nil {
+		return err
+	}
+	if reply != "+PONG" && !strings.HasPrefix(reply, "-NOAUTH") {
+		return fmt.Errorf("master replied %q to PING", reply)
+	}
+
+	type step struct {
+		cmd    *Command
+		expect string
+	}
+	var steps []step
+	if masterauth != "" {
+		steps = append(steps, step{&Command{Name: "AUTH", Args: []string{masterauth}}, "+OK"})
+	}
+	steps = append(steps,
+		step{&Command{Name: "REPLCONF", Args: []string{"listening-port", strconv.Itoa(port)}}, "+OK"},
+		step{&Command{Name: "REPLCONF", Args: []string{"capa", "psync2"}}, "+OK"},
+	)
+
+	for _, step := range steps {
+		reply, err := replRequest(conn, reader, step.cmd)
+		if err != nil {
+			return err
+		}
+		if reply != step.expect {
+			return fmt.Errorf("master replied %q to %s", reply, step.cmd.Name)
+		}
+	}
+	return nil
+}
+
+// replRequest sends cmd and reads the single line reply.
+func replRequest(conn net.Conn, reader *bufio.Reader, cmd *Command) (string, error) {
+	conn.SetDeadline(time.Now().Add(replTimeout))
+	if _, err := conn.Write(FormatCommand(cmd)); err != nil {
+		return "", err
+	}
+	return replReadLine(reader)
+}
+
+// replReadLine reads a reply line, skipping the bare newlines a master sends
+// as keepalives while it prepares the snapshot.
+func replReadLine(reader *bufio.Reader) (string, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return line, nil
+		}
+	}
+}
+
+// psync continues the history this replica already has when it has one,
+// otherwise it asks for a full copy and replaces the keyspace with it.
+func (r *Radisa) psync(conn net.Conn, reader *bufio.Reader, link *ReplicaOf) error {
+	request := &Command{Name: "PSYNC", Args: []string{"?", "-1"}}
+	r.mu.RLock()
+	if link.cached {
+		request.Args = []string{r.replID, strconv.FormatInt(r.replOffset+1, 10)}
+	}
+	r.mu.RUnlock()
+
+	reply, err := replRequest(conn, reader, request)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(reply)
+	if len(fields) > 0 && fields[0] == "+CONTINUE" {
+		return r.continueSync(link, fields[1:])
+	}
+	if len(fields) != 3 || fields[0] != "+FULLRESYNC" {
+		return fmt.Errorf("unexpected reply to PSYNC: %q", reply)
+	}
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid offset in %q", reply)
+	}
+
+	r.mu.Lock()
+	link.syncing = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		link.syncing = false
+		r.mu.Unlock()
+	}()
+
+	data, err := r.loadMasterRDB(reader)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.replicaOf != link {
+		return errReplicationTargetChanged
+	}
+	r.replaceData(data)
+	r.touchAllWatchedKeys()
+	r.trackingInvalidateAll()
+	r.replID = fields[1]
+	r.replOffset = offset
+	r.replID2 = zeroReplID
+	r.secondReplOffset = -1
+	r.backlog = newReplBacklog(r.config.ReplBacklogSize, offset+1)
+	link.linkUp = true
+	link.cached = true
+	// Our replicas hold the dataset we just replaced
+	r.disconnectReplicas()
+	fmt.Printf("MASTER <-> REPLICA sync: loaded %d keys\n", len(data))
+	return nil
+}
+
+// continueSync handles +CONTINUE [<replid>]. A master that changed its replid
+// since, because it was promoted, still continues our stream; our old replid
+// becomes replID2 so our own replicas can continue too.
+func (r *Radisa) continueSync(link *ReplicaOf, args []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.replicaOf != link {
+		return errReplicationTargetChanged
+	}
+
+	if len(args) == 1 && args[0] != r.replID {
+		r.replID2 = r.replID
+		r.secondReplOffset = r.replOffset + 1
+		r.replID = args[0]
+		// Our replicas reconnect and learn the new replid from us
+		r.disconnectReplicas()
+	}
+	if r.backlog == nil {
+		r.backlog = newReplBacklog(r.config.ReplBacklogSize, r.replOffset+1)
+	}
+	link.linkUp = true
+	fmt.Printf("MASTER <-> REPLICA sync: partial resynchronization accepted at offset %d\n", r.replOffset)
+	return nil
+}
+
+// loadMasterRDB reads the snapshot that follows +FULLRESYNC. With
+// repl-diskless-load it is parsed as it arrives, otherwise received whole
+// first. Either way the current keyspace keeps being served until the new
+// one is complete.
+func (r *Radisa) loadMasterRDB(reader *bufio.Reader) (map[string]Data, error) {
+	r.mu.RLock()
+	diskless := r.config.ReplDisklessLoad == "swapdb" || r.config.ReplDisklessLoad == "on-empty-db" && len(r.data) == 0
+	r.mu.RUnlock()
+
+	if diskless {
+		data, err := streamRDBPayload(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load RDB from master: %v", err)
+		}
+		return data, nil
+	}
+
+	payload, err := readRDBPayload(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RDB from master: %v", err)
+	}
+	data, err := NewRDBParser(payload).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("bad RDB from master: %v", err)
+	}
+	return data, nil
+}
+
+// readRDBPayloadHeader reads "$<length>" or, from a diskless master,
+// "$EOF:<mark>", in which case the mark is returned.
+func readRDBPayloadHeader(reader *bufio.Reader) (int64, string, error) {
+	line, err := replReadLine(reader)
+	if err != nil {
+		return 0, "", err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return 0, "", fmt.Errorf("expected '$' prefix, got %q", line)
+	}
+	if mark, ok := strings.CutPrefix(line, "$EOF:"); ok {
+		if len(mark) != rdbEOFMarkLen {
+			return 0, "", fmt.Errorf("invalid EOF mark %q", mark)
+		}
+		return 0, mark, nil
+	}
+	length, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || length < 0 {
+		return 0, "", fmt.Errorf("invalid RDB length %q", line[1:])
+	}
+	return length, "", nil
+}
+
+// readRDBPayload reads the snapshot: "$<length>\r\n" and that many bytes, or
+// "$EOF:<mark>\r\n" and everything up to the mark. Unlike a bulk string the
+// snapshot isn't followed by CRLF.
+func readRDBPayload(reader *bufio.Reader) ([]byte, error) {
+	length, mark, err := readRDBPayloadHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if mark == "" {
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return nil, err
+		}
+		return payload, nil
+	}
+
+	var payload []byte
+	for !bytes.HasSuffix(payload, []byte(mark)) {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, b)
+	}
+	return payload[:len(payload)-len(mark)], nil
+}
+
+// streamRDBPayload parses the snapshot straight from reader. The parser
+// stops right after the RDB, where a diskless master's mark has to follow.
+func streamRDBPayload(reader *bufio.Reader) (map[string]Data, error) {
+	length, mark, err := readRDBPayloadHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if mark == "" {
+		payload := io.LimitReader(reader, length)
+		data, err := NewRDBStreamParser(payload).Parse()
+		if err != nil {
+			return nil, err
+		}
+		// Skip whatever the master sent after the EOF opcode
+		if _, err := io.Copy(io.Discard, payload); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	data, err := NewRDBStreamParser(reader).Parse()
+	if err != nil {
+		return nil, err
+	}
+	tail := make([]byte, len(mark))
+	if _, err := io.ReadFull(reader, tail); err != nil {
+		return nil, err
+	}
+	if string(tail) != mark {
+		return nil, fmt.Errorf("RDB isn't followed by its EOF mark")
+	}
+	return data, nil
+}
+
+// applyMasterStream executes the writes the master streams after the
+// snapshot. They are applied without replying, as the master expects. The
+// offset advances by the bytes of every command, which also go into our own
+// backlog and to our own replicas unchanged, so this replica can serve
+// partial syncs to them and once promoted.
+func (r *Radisa) applyMasterStream(conn net.Conn, reader *bufio.Reader, link *ReplicaOf) error {
+	stream := NewRESPReader(reader)
+	// A transaction is held back until its EXEC and applied in one go, so
+	// no client reads part of it
+	var multi []*Command
+	var multiRaw []byte
+	inMulti := false
+	for {
+		conn.SetReadDeadline(time.Now().Add(replTimeout))
+		cmd, raw, err := stream.ReadCommandRaw()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case cmd.Name == "MULTI":
+			multi, multiRaw, inMulti = nil, append(multiRaw[:0], raw...), true
+			continue
+		case inMulti && cmd.Name != "EXEC":
+			multi = append(multi, cmd)
+			multiRaw = append(multiRaw, raw...)
+			continue
+		}
+		cmds := []*Command{cmd}
+		if inMulti {
+			cmds, raw, inMulti = multi, append(multiRaw, raw...), false
+		}
+
+		if cmd.Name == "REPLCONF" && len(cmd.Args) > 0 && strings.EqualFold(cmd.Args[0], "GETACK") {
+			// The ack covers what came before the GETACK
+			r.mu.RLock()
+			offset := r.replOffset
+			r.mu.RUnlock()
+			if err := sendAck(conn, offset); err != nil {
+				return err
+			}
+			cmd = nil
+		}
+
+		r.mu.Lock()
+		// A command read just before REPLICAOF switched masters is dropped
+		if r.replicaOf != link {
+			r.mu.Unlock()
+			return errReplicationTargetChanged
+		}
+		if cmd != nil {
+			r.applyingMaster = true
+			for _, cmd := range cmds {
+				r.execute(cmd)
+			}
+			r.applyingMaster = false
+		}
+		// Our replicas get the exact same bytes, so offsets agree down the chain
+		r.feedReplicationStream(raw)
+		r.mu.Unlock()
+	}
+}
+
+// replAckPeriod is how often a replica reports its offset, like redis does
+// from replicationCron.
+const replAckPeriod = time.Second
+
+// sendAcks sends REPLCONF ACK <offset> until done is closed, which lets the
+// master report each replica's offset and lag. The master can also ask for
+// one with REPLCONF GETACK, see applyMasterStream.
+func (r *Radisa) sendAcks(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(replAckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		r.mu.RLock()
+		offset := r.replOffset
+		r.mu.RUnlock()
+
+		if err := sendAck(conn, offset); err != nil {
+			return
+		}
+	}
+}
+
+func sendAck(conn net.Conn, offset int64) error {
+	ack := &Command{Name: "REPLCONF", Args: []string{"ACK", strconv.FormatInt(offset, 10)}}
+	_, err := conn.Write(FormatCommand(ack))
+	return err
+}
+
+// replIOTracker records when the master was last heard from, for
+// master_last_io_seconds_ago.
+type replIOTracker struct {
+	conn net.Conn
+	link *ReplicaOf
+}
+
+func (t *replIOTracker) Read(p []byte) (int, error) {
+	n, err := t.conn.Read(p)
+	if n > 0 {
+		t.link.lastIO.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,456 @@
+package radisa
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the timeout passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fakeMasterSession accepts one replica, checks its handshake and sends a
+// FULLRESYNC with data, returning the connection for streaming commands.
+func fakeMasterSession(t *testing.T, l net.Listener, data map[string]Data) (net.Conn, [][]string) {
+	t.Helper()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Expected replica to connect, got: %v", err)
+	}
+
+	reader := NewRESPReader(bufio.NewReader(conn))
+	var received [][]string
+	for _, reply := range []string{"+PONG\r\n", "+OK\r\n", "+OK\r\n"} {
+		cmd, err := reader.ReadCommand()
+		if err != nil {
+			t.Fatalf("Expected handshake command, got: %v", err)
+		}
+		received = append(received, append([]string{cmd.Name}, cmd.Args...))
+		conn.Write([]byte(reply))
+	}
+
+	cmd, err := reader.ReadCommand()
+	if err != nil {
+		t.Fatalf("Expected PSYNC, got: %v", err)
+	}
+	received = append(received, append([]string{cmd.Name}, cmd.Args...))
+
+	var rdb bytes.Buffer
+	NewRDBWriter(&rdb, true).Write(data)
+	fmt.Fprintf(conn, "+FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0\r\n\n$%d\r\n", rdb.Len())
+	conn.Write(rdb.Bytes())
+	return conn, received
+}
+
+func TestReplica_HandshakeAndFullResync(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	port := l.Addr().(*net.TCPAddr).Port
+	r := NewReplica(t.TempDir(), "dump.rdb", 6380, fmt.Sprintf("127.0.0.1 %d", port), DefaultConfig())
+	go r.replicate(r.replicaOf)
+	defer func() {
+		r.mu.Lock()
+		r.replicaOf = nil
+		r.mu.Unlock()
+	}()
+
+	conn, received := fakeMasterSession(t, l, map[string]Data{"snap": {value: "shot"}})
+	expected := [][]string{
+		{"PING"},
+		{"REPLCONF", "listening-port", "6380"},
+		{"REPLCONF", "capa", "psync2"},
+		{"PSYNC", "?", "-1"},
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected %v, got %v", expected, received)
+	}
+
+	set := FormatCommand(&Command{Name: "SET", Args: []string{"streamed", "1"}})
+	conn.Write(set)
+	waitFor(t, "streamed write", func() bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.data["streamed"].value == "1"
+	})
+
+	r.mu.RLock()
+	if r.data["snap"].value != "shot" {
+		t.Errorf("Expected snapshot key, got %v", r.data)
+	}
+	if r.replOffset != int64(len(set)) {
+		t.Errorf("Expected offset %d, got %d", len(set), r.replOffset)
+	}
+	if !r.replicaOf.linkUp {
+		t.Errorf("Expected link to be up")
+	}
+	r.mu.RUnlock()
+
+	// Losing the master triggers a reconnect and a fresh full sync
+	conn.Close()
+	conn, _ = fakeMasterSession(t, l, map[string]Data{"second": {value: "sync"}})
+	defer conn.Close()
+	waitFor(t, "second sync", func() bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.data["second"].value == "sync"
+	})
+}
+
+func TestReadRDBPayload_NoTrailingCRLF(t *testing.T) {
+	reader := bufio.NewReader(bytes.NewReader([]byte("\n\n$5\r\nREDIS*1\r\n$4\r\nPING\r\n")))
+	payload, err := readRDBPayload(reader)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if string(payload) != "REDIS" {
+		t.Errorf("Expected REDIS, got %q", payload)
+	}
+
+	cmd, err := NewRESPReader(reader).ReadCommand()
+	if err != nil || cmd.Name != "PING" {
+		t.Errorf("Expected the stream to continue with PING, got %v (%v)", cmd, err)
+	}
+}
+
+func TestReplica_GETACK(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	r := NewReplica(t.TempDir(), "dump.rdb", 6380, fmt.Sprintf("127.0.0.1 %d", l.Addr().(*net.TCPAddr).Port), DefaultConfig())
+	go r.replicate(r.replicaOf)
+	defer func() {
+		r.mu.Lock()
+		r.replicaOf = nil
+		r.mu.Unlock()
+	}()
+
+	conn, _ := fakeMasterSession(t, l, map[string]Data{})
+	defer conn.Close()
+	set := FormatCommand(&Command{Name: "SET", Args: []string{"k", "v"}})
+	conn.Write(set)
+	conn.Write(FormatCommand(&Command{Name: "REPLCONF", Args: []string{"GETACK", "*"}}))
+
+	// Periodic acks may report 0 before the SET; the first other one must
+	// count the SET but not the GETACK
+	reader := NewRESPReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		cmd, err := reader.ReadCommand()
+		if err != nil {
+			t.Fatalf("Expected REPLCONF ACK, got: %v", err)
+		}
+		if cmd.Name != "REPLCONF" || len(cmd.Args) != 2 || cmd.Args[0] != "ACK" {
+			t.Fatalf("Expected REPLCONF ACK, got %v", cmd)
+		}
+		if cmd.Args[1] == "0" {
+			continue
+		}
+		if cmd.Args[1] != strconv.Itoa(len(set)) {
+			t.Errorf("Expected ACK %d, got %s", len(set), cmd.Args[1])
+		}
+		break
+	}
+}
+
+// startTestServer serves a fresh master on an ephemeral port and turns it
+// back into a master when the test ends, which stops any replication.
+func startTestServer(t *testing.T) *Radisa {
+	t.Helper()
+	l := listenForTest(t)
+	r := NewRadisa(t.TempDir(), "dump.rdb", l.Addr().(*net.TCPAddr).Port, DefaultConfig())
+	go acceptForTest(l, r)
+	t.Cleanup(func() { r.executeCommand(&Command{Name: "REPLICAOF", Args: []string{"NO", "ONE"}}) })
+	return r
+}
+
+func linkUp(r *Radisa) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.replicaOf != nil && r.replicaOf.linkUp
+}
+
+func TestReplicaOf_SwitchAndPromote(t *testing.T) {
+	a, b, c := startTestServer(t), startTestServer(t), startTestServer(t)
+
+	// Keep clients busy on the servers being reconfigured
+	stop := make(chan struct{})
+	defer close(stop)
+	for _, r := range []*Radisa{b, c} {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					r.executeCommand(&Command{Name: "INFO", Args: []string{"replication"}})
+					r.executeCommand(&Command{Name: "GET", Args: []string{"x"}})
+				}
+			}
+		}()
+	}
+
+	aPort := strconv.Itoa(a.Port)
+	for _, r := range []*Radisa{b, c} {
+		if got := string(r.executeCommand(&Command{Name: "REPLICAOF", Args: []string{"127.0.0.1", aPort}})); got != "+OK\r\n" {
+			t.Fatalf("Expected +OK, got %q", got)
+		}
+	}
+	if got := string(b.executeCommand(&Command{Name: "SLAVEOF", Args: []string{"127.0.0.1", aPort}})); got != "+OK Already connected to specified master\r\n" {
+		t.Errorf("Expected already connected, got %q", got)
+	}
+
+	a.executeCommand(&Command{Name: "SET", Args: []string{"x", "1"}})
+	waitFor(t, "both replicas to get x", func() bool {
+		vb, _ := replicaValue(b, "x")
+		vc, _ := replicaValue(c, "x")
+		return linkUp(b) && linkUp(c) && vb == "1" && vc == "1"
+	})
+
+	a.mu.RLock()
+	oldReplID := a.replID
+	a.mu.RUnlock()
+	b.mu.RLock()
+	offset := b.replOffset
+	b.mu.RUnlock()
+
+	if got := string(b.executeCommand(&Command{Name: "REPLICAOF", Args: []string{"no", "one"}})); got != "+OK\r\n" {
+		t.Fatalf("Expected +OK, got %q", got)
+	}
+	info := string(b.executeCommand(&Command{Name: "INFO", Args: []string{"replication"}}))
+	for _, line := range []string{
+		"role:master",
+		"master_replid2:" + oldReplID,
+		fmt.Sprintf("second_repl_offset:%d", offset+1),
+		fmt.Sprintf("master_repl_offset:%d", offset),
+	} {
+		if !strings.Contains(info, line+"\r\n") {
+			t.Errorf("Expected %q in %q", line, info)
+		}
+	}
+	if strings.Contains(info, "master_replid:"+oldReplID) {
+		t.Errorf("Expected a new replid after promotion")
+	}
+
+	// C follows its promoted sibling without a full resync
+	if got := string(c.executeCommand(&Command{Name: "REPLICAOF", Args: []string{"127.0.0.1", strconv.Itoa(b.Port)}})); got != "+OK\r\n" {
+		t.Fatalf("Expected +OK, got %q", got)
+	}
+	b.executeCommand(&Command{Name: "SET", Args: []string{"y", "2"}})
+	waitFor(t, "c to follow b", func() bool {
+		value, _ := replicaValue(c, "y")
+		return linkUp(c) && value == "2"
+	})
+
+	b.mu.RLock()
+	if b.stats.syncFull != 0 || b.stats.syncPartialOK != 1 {
+		t.Errorf("Expected a partial sync, got %+v", b.stats)
+	}
+	b.mu.RUnlock()
+
+	c.mu.RLock()
+	if c.replID != b.replID || c.replID2 != oldReplID {
+		t.Errorf("Expected c to take over b's replid and keep the old one, got %s and %s", c.replID, c.replID2)
+	}
+	c.mu.RUnlock()
+
+	// A has lost both replicas
+	waitFor(t, "a to drop its replicas", func() bool {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+		return len(a.replicas) == 0
+	})
+}
+
+func TestReplicaOf_InvalidPort(t *testing.T) {
+	r := createTestServer()
+	if got := string(r.executeCommand(&Command{Name: "REPLICAOF", Args: []string{"localhost", "x"}})); !strings.HasPrefix(got, "-ERR") {
+		t.Errorf("Expected error, got %q", got)
+	}
+	if r.replicaOf != nil {
+		t.Errorf("Expected the role to stay unchanged")
+	}
+}
+
+func TestReplica_ReadOnlyAndStaleData(t *testing.T) {
+	config := DefaultConfig()
+	config.ReplicaServeStaleData = false
+	r := NewReplica(t.TempDir(), "dump.rdb", 6380, "127.0.0.1 1", config)
+
+	// The link isn't up yet, so only a few commands are served
+	got := string(r.executeCommand(&Command{Name: "GET", Args: []string{"k"}}))
+	if expected := "-MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'.\r\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if got := string(r.executeCommand(&Command{Name: "PING"})); got != "+PONG\r\n" {
+		t.Errorf("Expected +PONG, got %q", got)
+	}
+
+	r.replicaOf.linkUp = true
+	if got := string(r.executeCommand(&Command{Name: "GET", Args: []string{"k"}})); got != "$-1\r\n" {
+		t.Errorf("Expected null bulk string, got %q", got)
+	}
+	got = string(r.executeCommand(&Command{Name: "SET", Args: []string{"k", "v"}}))
+	if expected := "-READONLY You can't write against a read only replica.\r\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	r.config.ReplicaReadOnly = false
+	if got := string(r.executeCommand(&Command{Name: "SET", Args: []string{"k", "v"}})); got != "+OK\r\n" {
+		t.Errorf("Expected +OK, got %q", got)
+	}
+}
+
+func TestReplica_LogicalExpire(t *testing.T) {
+	l := listenForTest(t)
+	defer l.Close()
+
+	r := NewReplica(t.TempDir(), "dump.rdb", 6380, fmt.Sprintf("127.0.0.1 %d", l.Addr().(*net.TCPAddr).Port), DefaultConfig())
+	go r.replicate(r.replicaOf)
+	defer func() {
+		r.mu.Lock()
+		r.replicaOf = nil
+		r.mu.Unlock()
+	}()
+
+	conn, _ := fakeMasterSession(t, l, map[string]Data{})
+	defer conn.Close()
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	conn.Write(FormatCommand(&Command{Name: "SET", Args: []string{"k", "v", "PXAT", past}}))
+	waitFor(t, "streamed write", func() bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		_, ok := r.data["k"]
+		return ok
+	})
+
+	// Clients see the key as gone, but only the master's DEL removes it
+	if got := string(r.executeCommand(&Command{Name: "GET", Args: []string{"k"}})); got != "$-1\r\n" {
+		t.Errorf("Expected null bulk string, got %q", got)
+	}
+	r.mu.RLock()
+	_, ok := r.data["k"]
+	r.mu.RUnlock()
+	if !ok {
+		t.Errorf("Expected the key to stay until the master deletes it")
+	}
+
+	conn.Write(FormatCommand(&Command{Name: "DEL", Args: []string{"k"}}))
+	waitFor(t, "master DEL", func() bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		_, ok := r.data["k"]
+		return !ok
+	})
+}
+
+func TestReadRDBPayload_EOFMark(t *testing.T) {
+	mark := strings.Repeat("m", rdbEOFMarkLen)
+	reader := bufio.NewReader(strings.NewReader("$EOF:" + mark + "\r\nREDIS" + mark + "*1\r\n$4\r\nPING\r\n"))
+	payload, err := readRDBPayload(reader)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if string(payload) != "REDIS" {
+		t.Errorf("Expected REDIS, got %q", payload)
+	}
+
+	cmd, err := NewRESPReader(reader).ReadCommand()
+	if err != nil || cmd.Name != "PING" {
+		t.Errorf("Expected the stream to continue with PING, got %v (%v)", cmd, err)
+	}
+}
+
+func TestReplica_ChainedReplication(t *testing.T) {
+	a, b, c, d := startTestServer(t), startTestServer(t), startTestServer(t), startTestServer(t)
+	replicaOf := func(r, master *Radisa) {
+		t.Helper()
+		if got := string(r.executeCommand(&Command{Name: "REPLICAOF", Args: []string{"127.0.0.1", strconv.Itoa(master.Port)}})); got != "+OK\r\n" {
+			t.Fatalf("Expected +OK, got %q", got)
+		}
+	}
+	offset := func(r *Radisa) int64 {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.replOffset
+	}
+
+	// A -> B -> C, plus D as B's sibling
+	replicaOf(b, a)
+	replicaOf(d, a)
+	replicaOf(c, b)
+	a.executeCommand(&Command{Name: "SET", Args: []string{"x", "1"}})
+	waitFor(t, "x to reach the end of the chain", func() bool {
+		value, _ := replicaValue(c, "x")
+		return linkUp(c) && value == "1"
+	})
+	a.executeCommand(&Command{Name: "SET", Args: []string{"x", "2"}})
+	waitFor(t, "offsets to agree", func() bool {
+		value, _ := replicaValue(c, "x")
+		return value == "2" && offset(b) == offset(a) && offset(c) == offset(a)
+	})
+	a.mu.RLock()
+	b.mu.RLock()
+	c.mu.RLock()
+	if b.replID != a.replID || c.replID != a.replID {
+		t.Errorf("Expected the chain to share replid %s, got %s and %s", a.replID, b.replID, c.replID)
+	}
+	c.mu.RUnlock()
+	b.mu.RUnlock()
+	a.mu.RUnlock()
+
+	// D takes over from A and B follows it: C learns the new history from B
+	// without a full resync
+	waitFor(t, "d to catch up", func() bool { return linkUp(d) && offset(d) == offset(a) })
+	d.executeCommand(&Command{Name: "REPLICAOF", Args: []string{"NO", "ONE"}})
+	replicaOf(b, d)
+	d.executeCommand(&Command{Name: "SET", Args: []string{"y", "3"}})
+	waitFor(t, "c to follow the new master", func() bool {
+		value, _ := replicaValue(c, "y")
+		return linkUp(c) && value == "3" && offset(c) == offset(d)
+	})
+
+	d.mu.RLock()
+	c.mu.RLock()
+	if c.replID != d.replID {
+		t.Errorf("Expected c to learn replid %s, got %s", d.replID, c.replID)
+	}
+	c.mu.RUnlock()
+	d.mu.RUnlock()
+
+	b.mu.RLock()
+	if b.stats.syncFull != 1 || b.stats.syncPartialOK != 1 {
+		t.Errorf("Expected one full and one partial sync of c, got %+v", b.stats)
+	}
+	b.mu.RUnlock()
+}
+
+func TestReplica_NoMasterLink(t *testing.T) {
+	r := NewReplica(t.TempDir(), "dump.rdb", 0, "127.0.0.1 1", DefaultConfig())
+	port := serveForTest(t, r)
+	conn, reader := dialForTest(t, port)
+	if got := roundTrip(t, conn, reader, "PSYNC", "?", "-1"); got != "-NOMASTERLINK Can't SYNC while not connected with my master\r\n" {
+		t.Errorf("Expected NOMASTERLINK, got %q", got)
+	}
+}
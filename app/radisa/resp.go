@@ -3,10 +3,14 @@ package radisa
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 )
 
+// maxBulkLength is redis' default proto-max-bulk-len.
+const maxBulkLength = 512 * 1024 * 1024
+
 type Command struct {
 	Name string   
 	Args []string 
@@ -121,12 +125,196 @@ func FormatArray(elements []string) []byte {
 	return []byte(result)
 }
 
+// FormatBulk formats a bulk string like FormatBulkString, but keeps the
+// empty string apart from null (e.g., "$0\r\n\r\n")
+func FormatBulk(s string) []byte {
+	return []byte("$" + strconv.Itoa(len(s)) + CRLF + s + CRLF)
+}
+
+// FormatReplies wraps complete replies in an array (e.g., "*2\r\n+OK\r\n:1\r\n")
+func FormatReplies(replies [][]byte) []byte {
+	result := []byte("*" + strconv.Itoa(len(replies)) + CRLF)
+	for _, reply := range replies {
+		result = append(result, reply...)
+	}
+	return result
+}
+
+// FormatPush wraps complete replies in a RESP3 push (e.g., ">2\r\n$10\r\ninvalidate\r\n*-1\r\n")
+func FormatPush(replies [][]byte) []byte {
+	result := FormatReplies(replies)
+	result[0] = '>'
+	return result
+}
+
+// FormatMap formats RESP3 map pairs, each a key followed by its value (e.g., "%1\r\n$5\r\nproto\r\n:3\r\n")
+func FormatMap(pairs [][]byte) []byte {
+	result := []byte("%" + strconv.Itoa(len(pairs)/2) + CRLF)
+	for _, reply := range pairs {
+		result = append(result, reply...)
+	}
+	return result
+}
+
 // FormatError formats an error response (e.g., "-ERR message\r\n")
 func FormatError(errMsg string) []byte {
 	return []byte("-ERR " + errMsg + CRLF)
 }
 
+// FormatErrorCode formats an error with its own code instead of ERR (e.g., "-WRONGTYPE message\r\n")
+func FormatErrorCode(code string, errMsg string) []byte {
+	return []byte("-" + code + " " + errMsg + CRLF)
+}
+
+// FormatWrongType returns the error for a command run against a key of another type
+func FormatWrongType() []byte {
+	return FormatErrorCode("WRONGTYPE", "Operation against a key holding the wrong kind of value")
+}
+
+// FormatInteger formats an integer response (e.g., ":1000\r\n")
+func FormatInteger(n int64) []byte {
+	return []byte(":" + strconv.FormatInt(n, 10) + CRLF)
+}
+
+// FormatNullArray returns a null array response
+func FormatNullArray() []byte {
+	return []byte("*-1" + CRLF)
+}
+
+// FormatNull returns the RESP3 null
+func FormatNull() []byte {
+	return []byte("_" + CRLF)
+}
+
 // FormatNullBulkString returns a null bulk string response
 func FormatNullBulkString() []byte {
 	return []byte(NULL_BULK_STR)
 }
+
+// RESPReader reads commands byte-exactly from a stream. Unlike RESPParser it
+// keeps bulk strings binary safe and counts the bytes it consumed, which the
+// AOF and replication streams depend on.
+type RESPReader struct {
+	r      *bufio.Reader
+	offset int64
+	// raw collects the bytes of the command being read for ReadCommandRaw
+	raw    []byte
+	keep   bool
+}
+
+func NewRESPReader(r io.Reader) *RESPReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &RESPReader{r: br}
+}
+
+// Offset is the number of bytes of complete commands read so far.
+func (p *RESPReader) Offset() int64 {
+	return p.offset
+}
+
+// ReadCommand returns io.EOF at a clean end of input and
+// io.ErrUnexpectedEOF when the input stops in the middle of a command.
+func (p *RESPReader) ReadCommand() (*Command, error) {
+	n := int64(0)
+	line, err := p.readLine(&n)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF && n == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("invalid command format, expected '*' prefix")
+	}
+	arrayLength, err := strconv.Atoi(line[1:])
+	if err != nil || arrayLength < 1 {
+		return nil, fmt.Errorf("invalid array length: %q", line[1:])
+	}
+
+	parts := make([]string, arrayLength)
+	for i := range parts {
+		if parts[i], err = p.readBulkString(&n); err != nil {
+			return nil, err
+		}
+	}
+
+	p.offset += n
+	return &Command{
+		Name: strings.ToUpper(parts[0]),
+		Args: parts[1:],
+	}, nil
+}
+
+// ReadCommandRaw is ReadCommand that also returns the bytes the command was
+// read from, for passing a stream on unchanged. They are only valid until the
+// next read.
+func (p *RESPReader) ReadCommandRaw() (*Command, []byte, error) {
+	p.raw = p.raw[:0]
+	p.keep = true
+	defer func() { p.keep = false }()
+
+	cmd, err := p.ReadCommand()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cmd, p.raw, nil
+}
+
+func (p *RESPReader) readLine(n *int64) (string, error) {
+	line, err := p.r.ReadString('\n')
+	*n += int64(len(line))
+	if p.keep {
+		p.raw = append(p.raw, line...)
+	}
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, CRLF) {
+		return "", fmt.Errorf("line not terminated by CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (p *RESPReader) readBulkString(n *int64) (string, error) {
+	line, err := p.readLine(n)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return "", fmt.Errorf("invalid bulk string format, expected '$' prefix")
+	}
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 || length > maxBulkLength {
+		return "", fmt.Errorf("invalid bulk string length: %q", line[1:])
+	}
+
+	buf := make([]byte, length+2)
+	read, err := io.ReadFull(p.r, buf)
+	*n += int64(read)
+	if p.keep {
+		p.raw = append(p.raw, buf[:read]...)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	if string(buf[length:]) != CRLF {
+		return "", fmt.Errorf("bulk string not terminated by CRLF")
+	}
+	return string(buf[:length]), nil
+}
+
+// FormatCommand encodes a command as a RESP array of bulk strings, the form
+// it takes in the AOF and the replication stream.
+func FormatCommand(cmd *Command) []byte {
+	return FormatArray(append([]string{cmd.Name}, cmd.Args...))
+}
@@ -2,6 +2,7 @@ package radisa
 
 import (
 	"bufio"
+	"io"
 	"reflect"
 	"strings"
 	"testing"
@@ -352,3 +353,43 @@ func TestRESPParser_WhitespaceHandling(t *testing.T) {
 		t.Errorf("Expected args %v (trimmed), got: %v", expectedArgs, cmd.Args)
 	}
 }
+
+func TestRESPReader_BinarySafeAndOffset(t *testing.T) {
+	first := FormatCommand(&Command{Name: "SET", Args: []string{"k", "a\r\nb"}})
+	input := string(first) + "*1\r\n$4\r\nPING\r\n"
+	reader := NewRESPReader(strings.NewReader(input))
+
+	cmd, err := reader.ReadCommand()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(cmd.Args, []string{"k", "a\r\nb"}) {
+		t.Errorf("Expected [k a\\r\\nb], got %q", cmd.Args)
+	}
+	if reader.Offset() != int64(len(first)) {
+		t.Errorf("Expected offset %d, got %d", len(first), reader.Offset())
+	}
+
+	if cmd, err = reader.ReadCommand(); err != nil || cmd.Name != "PING" {
+		t.Errorf("Expected PING, got %v (%v)", cmd, err)
+	}
+	if _, err = reader.ReadCommand(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if reader.Offset() != int64(len(input)) {
+		t.Errorf("Expected offset %d, got %d", len(input), reader.Offset())
+	}
+}
+
+func TestRESPReader_Truncated(t *testing.T) {
+	full := "*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n"
+	for i := 1; i < len(full); i++ {
+		reader := NewRESPReader(strings.NewReader(full[:i]))
+		if _, err := reader.ReadCommand(); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF after %d bytes, got %v", i, err)
+		}
+		if reader.Offset() != 0 {
+			t.Errorf("Expected offset 0, got %d", reader.Offset())
+		}
+	}
+}
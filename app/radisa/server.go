@@ -4,26 +4,57 @@ import (
 	"bufio"
 	"fmt"
 	"maps"
+	"math"
 	"net"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const CRLF = "\r\n"
 const NULL_BULK_STR = "$-1" + CRLF
 
+// valueKind tells which of Data's fields holds the value. The zero value is a
+// plain string so existing `Data{value: ...}` literals keep working.
+type valueKind byte
+
+const (
+	kindString valueKind = iota
+	kindList
+	kindSet
+	kindHash
+	kindZSet
+	kindStream
+)
+
 type Data struct {
 	value string
 	expire time.Time	
+	kind valueKind
+	list []string // kindList and kindSet members
+	hash map[string]string
+	zset map[string]float64
+	stream *stream
 }
 
 type ReplicaOf struct {
 	masterHost string
 	masterPort int
+
+	// The link to the master, guarded by Radisa.mu
+	conn net.Conn
+	linkUp bool
+	syncing bool
+	// cached is set once replID and replOffset describe this master's stream,
+	// so a reconnect can ask to continue it
+	cached bool
+	// lastIO is when the master was last heard from in unix nanoseconds. It is
+	// updated on every read, so it is atomic rather than guarded by mu.
+	lastIO atomic.Int64
 }
 
 // om du vet, du vet
@@ -34,10 +65,92 @@ type Radisa struct {
 	dir string
 	dbfilename string
 	replicaOf *ReplicaOf
+	config Config
+
+	// dirty counts writes since the last successful save and is guarded by mu.
+	dirty int
+	// snapshots are being copied in the background, guarded by mu. Every
+	// write to data goes through setKey or deleteKey so they can keep the
+	// old value.
+	snapshots []*keyspaceSnapshot
+
+	saveMu sync.Mutex
+	saving bool
+	lastSave time.Time
+	lastSaveErr error
+	lastSaveTry time.Time
+
+	// loadErr keeps Start from serving a keyspace that failed to load
+	loadErr error
+
+	aof *aof
+	// loading is set while the AOF is replayed so commands aren't logged twice
+	loading bool
+	// applyingMaster is set while a replica executes its master's stream
+	applyingMaster bool
+
+	// Replicas attached to this server and the stream they are fed, guarded by
+	// mu. A replica takes over its master's replID and offset. replID2 is the
+	// history this server can still continue from up to secondReplOffset.
+	replicas []*replicaClient
+	replID string
+	replOffset int64
+	replID2 string
+	secondReplOffset int64
+	backlog *replBacklog
+	// ackSignal is closed when a replica acks, waking WAIT
+	ackSignal chan struct{}
+	// disklessWaiting are the replicas that will share the next diskless
+	// transfer once repl-diskless-sync-delay passed
+	disklessWaiting []*replicaClient
+
+	// multiPending is set by EXEC for a transaction with several writes, so
+	// the first command propagated is preceded by MULTI; multiPropagated
+	// then tells EXEC to close it. Guarded by mu.
+	multiPending bool
+	multiPropagated bool
+
+	// watchedKeys lists the clients that WATCH each key, guarded by mu
+	watchedKeys map[string][]*client
+	// pubsubChannels are the subscribers of each channel and pubsubPatterns
+	// those of each pattern, indexed by patternsByPrefix. Guarded by mu.
+	pubsubChannels map[string]map[*client]struct{}
+	pubsubPatterns map[string]map[*client]struct{}
+	patternsByPrefix map[string]map[string]struct{}
+	// pubsubShardChannels are the subscribers of each shard channel, apart
+	// from the global ones. Guarded by mu.
+	pubsubShardChannels map[string]map[*client]struct{}
+
+	// clients are the open connections by ID, guarded by mu.
+	clients map[int64]*client
+	nextClientID int64
+	// currentClient is the client whose command is executing, nil for the
+	// master's stream and background jobs. Guarded by mu.
+	currentClient *client
+	// trackingTable lists the IDs of the clients that read each key since
+	// it last changed, trackingPrefixes the BCAST clients of each prefix.
+	// Guarded by mu.
+	trackingTable map[string]map[int64]struct{}
+	trackingPrefixes map[string]map[*client]struct{}
+
+	// users are the ACL users by name, see aclUsers. aclLog holds the
+	// latest denials, newest first. Guarded by mu.
+	users map[string]*aclUser
+	aclLog []*aclLogEntry
+	aclLogNextID int64
+
+	stats serverStats
+}
+
+// serverStats are the counters INFO stats reports, guarded by mu.
+type serverStats struct {
+	syncFull       int64
+	syncPartialOK  int64
+	syncPartialErr int64
 }
 
-func NewReplica(dir string, dbfilename string, port int, replicaof string) *Radisa {
-	replica := NewRadisa(dir, dbfilename, port);
+func NewReplica(dir string, dbfilename string, port int, replicaof string, config Config) *Radisa {
+	replica := NewRadisa(dir, dbfilename, port, config);
 	masterInfo := strings.Split(replicaof, " ");
 	
 	if len(masterInfo) < 2 {
@@ -58,42 +171,80 @@ func NewReplica(dir string, dbfilename string, port int, replicaof string) *Radi
 	return replica
 }
 
-func NewRadisa(dir string, dbfilename string, port int) *Radisa {
-	file, err := os.ReadFile(dir + "/" + dbfilename)
+func NewRadisa(dir string, dbfilename string, port int, config Config) *Radisa {
+	r := &Radisa{
+		Port: port,
+		data: make(map[string]Data),
+		mu:   sync.RWMutex{},
+		dir: dir,
+		dbfilename: dbfilename,
+		replicaOf: nil,
+		config: config,
+		lastSave: time.Now(),
+		replID: newReplID(),
+		replID2: zeroReplID,
+		secondReplOffset: -1,
+	}
+
+	// With appendonly on the AOF is the source of truth and the RDB file is
+	// only read to seed a brand new AOF.
+	if config.AppendOnly {
+		r.loadErr = r.openAOF()
+	} else {
+		r.loadErr = r.loadRDBFile()
+	}
+	if r.loadErr == nil && config.ACLFile != "" {
+		r.loadErr = r.loadACLFile()
+	}
+
+	return r
+}
+
+func (r *Radisa) loadRDBFile() error {
+	file, err := os.ReadFile(r.dir + "/" + r.dbfilename)
 	// @TODO: This actually is not super smart, since dbfilename is just a flag,
 	// so when not provided we should not print any error and just start redis in 
 	// memory, without restoring snapshot.
 	if err != nil {
 		fmt.Printf("Error reading RDB file: %v\n", err)
-
-		return &Radisa{
-			Port: port, // Default Redis port
-			data: make(map[string]Data),
-			mu:   sync.RWMutex{},
-			dir: dir,
-			dbfilename: dbfilename,
-			replicaOf: nil,
-		}
+		return nil
 	}
 
 	parser := NewRDBParser(file)
-
-	return &Radisa{
-		Port: port,
-		data: parser.Parse(),
-		mu:   sync.RWMutex{},
-		dir: dir,
-		dbfilename: dbfilename,
-		replicaOf: nil,
+	data, err := parser.Parse()
+	if err != nil {
+		if r.config.RDBCorruptPolicy != RDBLoadValid {
+			return fmt.Errorf("bad RDB file %s: %v", r.dbfilename, err)
+		}
+		fmt.Printf("Loaded %d keys from a damaged RDB file, ignoring the rest: %v\n", len(data), err)
 	}
+
+	r.data = data
+	return nil
 }
 
 func (r *Radisa) Start() error {
+	if r.loadErr != nil {
+		return r.loadErr
+	}
+
+
 	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", r.Port))
 	if err != nil {
 		fmt.Printf("Failed to bind to port %d\r\n", r.Port)
 		os.Exit(1)
 	}
+
+	go r.saveCron()
+	go r.replicationCron()
+	go r.activeExpireCron()
+	if r.replicaOf != nil {
+		go r.replicate(r.replicaOf)
+	}
+	if r.aof != nil {
+		go r.aof.fsyncCron()
+		go r.aofRewriteCron()
+	}
 	
 	for {
 		conn, err := l.Accept()
@@ -108,6 +259,10 @@ func (r *Radisa) Start() error {
 
 func (r *Radisa) handleConnection(conn net.Conn) {
 	defer conn.Close()
+
+	c := newClient(conn)
+	r.linkClient(c)
+	defer r.freeClient(c)
 	
 	scanner := bufio.NewScanner(conn)
 	parser := NewRESPParser(scanner)
@@ -120,120 +275,392 @@ func (r *Radisa) handleConnection(conn net.Conn) {
 			if err.Error() == "failed to read command" {
 				return
 			}
-			conn.Write(FormatError(err.Error()))
+			c.write(FormatError(err.Error()))
 			continue
 		}
 
-		// Execute command and send response
-		response := r.executeCommand(cmd)
-		conn.Write(response)
-	}	
-}
-
-
+		spec := commandTable[cmd.Name]
+		if r.authRequired(c) && (spec == nil || spec.flags&cmdNoAuth == 0) {
+			c.write(FormatErrorCode("NOAUTH", "Authentication required."))
+			continue
+		}
 
-// executeCommand processes a parsed command and returns the appropriate RESP response
-func (r *Radisa) executeCommand(cmd *Command) []byte {
-	switch cmd.Name {
-	case "PING":
-		return FormatSimpleString("PONG")
+		if c.resp != 3 && c.inSubscribeMode() && !subscribeModeCommands[cmd.Name] {
+			c.write(FormatError(fmt.Sprintf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context", strings.ToLower(cmd.Name))))
+			continue
+		}
 
-	case "ECHO":
-		if len(cmd.Args) < 1 {
-			return FormatError("wrong number of arguments for 'echo' command")
+		if c.inMulti && !transactionCommands[cmd.Name] {
+			c.write(r.queueCommand(c, cmd))
+			continue
 		}
-		bulk := strings.Join(cmd.Args, " ")
-		return FormatBulkString(bulk)
 
-	case "SET":
-		if len(cmd.Args) < 2 {
-			return FormatError("wrong number of arguments for 'set' command")
+		if reply := checkArity(cmd); reply != nil {
+			c.write(reply)
+			continue
 		}
 
-		key := cmd.Args[0]
-		value := cmd.Args[1]
-		expires := time.Time{}
+		if reply := r.checkACL(c, cmd); reply != nil {
+			c.write(reply)
+			continue
+		}
 
-		// Handle PX argument for expiry
-		if len(cmd.Args) > 2 && strings.ToUpper(cmd.Args[2]) == "PX" {
-			if len(cmd.Args) < 4 {
-				return FormatError("invalid duration for PX argument")
+		// Execute command and send response
+		var response []byte
+		switch {
+		case cmd.Name == "PSYNC":
+			// From here on the connection carries the replication stream
+			r.syncReplica(c, cmd)
+			c.isReplica = true
+			continue
+		case cmd.Name == "QUIT":
+			c.write(FormatSimpleString("OK"))
+			if c.out != nil {
+				c.out.closeWhenFlushed()
 			}
-			duration, err := strconv.Atoi(cmd.Args[3])
-			if err != nil {
-				return FormatError("invalid duration for PX argument")
+			return
+		case cmd.Name == "PING" && c.resp != 3 && c.inSubscribeMode():
+			response = pubsubPing(cmd)
+		case spec.connProc != nil:
+			response = spec.connProc(r, c, cmd)
+		default:
+			response = r.executeClientCommand(c, cmd)
+			if spec.flags&cmdWrite != 0 {
+				r.mu.RLock()
+				c.woff = r.replOffset
+				r.mu.RUnlock()
 			}
-			expires = time.Now().Add(time.Duration(duration) * time.Millisecond)
 		}
 
-		r.mu.Lock()
-		r.data[key] = Data{
-			value:  value,
-			expire: expires,
+		// Replicas apply the stream without being answered
+		if !c.isReplica {
+			c.write(response)
+		}
+		for _, push := range c.pendingPushes {
+			c.push(push)
 		}
-		r.mu.Unlock()
+		c.pendingPushes = nil
 
-		return FormatSimpleString("OK")
+		// CLIENT CACHING only applies to the next command, or to the
+		// transaction that follows
+		if !c.inMulti && !isClientCaching(cmd) {
+			c.trackingCaching = false
+		}
 
-	case "GET":
-		if len(cmd.Args) < 1 {
-			return FormatError("wrong number of arguments for 'get' command")
+		if c.closeAfterReply {
+			if c.out != nil {
+				c.out.closeWhenFlushed()
+			}
+			return
 		}
+	}	
+}
+
+
+
+// freeClient drops what a closed connection left registered.
+func (r *Radisa) freeClient(c *client) {
+	r.removeReplica(c)
+
+	r.mu.Lock()
+	r.unwatchAllKeys(c)
+	r.unsubscribeAll(c)
+	r.disableTracking(c)
+	delete(r.clients, c.id)
+	r.mu.Unlock()
+
+	if c.out != nil {
+		c.out.close()
+	}
+}
 
-		key := cmd.Args[0]
-		r.mu.RLock()
-		value, exists := r.data[key]
-		r.mu.RUnlock()
+// executeCommand runs cmd with the keyspace locked, so commands execute one
+// at a time like in redis' event loop and writes reach the AOF in the same
+// order they were applied.
+func (r *Radisa) executeCommand(cmd *Command) []byte {
+	return r.executeClientCommand(nil, cmd)
+}
+
+// executeClientCommand is executeCommand for a command c sent, so the keys
+// it reads can be tracked for c.
+func (r *Radisa) executeClientCommand(c *client, cmd *Command) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-		if !exists {
-			return FormatNullBulkString()
+	if reply := r.rejectCommand(cmd); reply != nil {
+		return reply
+	}
+	r.currentClient = c
+	defer func() { r.currentClient = nil }()
+	reply := r.execute(cmd)
+	r.rememberTrackedKeys(c, cmd)
+	return reply
+}
+
+// rejectCommand returns the error refusing cmd in the server's current
+// state, nil if it may run. The caller holds mu.
+func (r *Radisa) rejectCommand(cmd *Command) []byte {
+	spec := lookupCommand(cmd)
+	if spec == nil {
+		return nil
+	}
+	if link := r.replicaOf; link != nil {
+		if !link.linkUp && !r.config.ReplicaServeStaleData && spec.flags&cmdStale == 0 {
+			return FormatErrorCode("MASTERDOWN", "Link with MASTER is down and replica-serve-stale-data is set to 'no'.")
 		}
+		if r.config.ReplicaReadOnly && spec.flags&cmdWrite != 0 {
+			return FormatErrorCode("READONLY", "You can't write against a read only replica.")
+		}
+	}
 
-		if !value.expire.IsZero() && time.Now().After(value.expire) {
-			r.mu.Lock()
-			delete(r.data, key)
-			r.mu.Unlock()
-			return FormatNullBulkString()
+	// Writes are refused while the AOF can't be written, so a client is
+	// never told OK for a write that won't survive
+	if r.aof != nil && spec.flags&cmdWrite != 0 {
+		if err := r.aof.writeError(); err != nil {
+			return FormatErrorCode("MISCONF", "Errors writing to the AOF file: "+err.Error())
 		}
+	}
+	return nil
+}
 
-		return FormatBulkString(value.value)
+// execute runs cmd's entry in the command table and returns the RESP
+// reply. The caller holds mu.
+func (r *Radisa) execute(cmd *Command) []byte {
+	if reply := checkArity(cmd); reply != nil {
+		return reply
+	}
+	spec := commandTable[cmd.Name]
+	if spec.proc == nil {
+		// Commands of the connection itself never get here from a client
+		return FormatError("unknown command")
+	}
+	return spec.proc(r, cmd)
+}
 
-	case "CONFIG":
-		if len(cmd.Args) < 2 {
-			return FormatError("wrong number of arguments for 'config' command")
-		}
+// pingCommand implements PING outside of subscribe mode.
+func (r *Radisa) pingCommand(cmd *Command) []byte {
+	return FormatSimpleString("PONG")
+}
+
+// echoCommand implements ECHO message.
+func (r *Radisa) echoCommand(cmd *Command) []byte {
+	bulk := strings.Join(cmd.Args, " ")
+	return FormatBulkString(bulk)
+}
+
+// setCommand implements SET key value [PX milliseconds | PXAT unix-time-ms].
+func (r *Radisa) setCommand(cmd *Command) []byte {
+	key := cmd.Args[0]
+	value := cmd.Args[1]
+	expires := time.Time{}
 
-		if cmd.Args[0] == "GET" && cmd.Args[1] == "dir" {
-			return FormatArray([]string{"dir", r.dir})
+	// Handle PX argument for expiry
+	if len(cmd.Args) > 2 && strings.ToUpper(cmd.Args[2]) == "PX" {
+		if len(cmd.Args) < 4 {
+			return FormatError("invalid duration for PX argument")
 		}
+		duration, err := strconv.Atoi(cmd.Args[3])
+		if err != nil {
+			return FormatError("invalid duration for PX argument")
+		}
+		expires = time.Now().Add(time.Duration(duration) * time.Millisecond)
+	}
 
-		if cmd.Args[0] == "GET" && cmd.Args[1] == "dbfilename" {
-			return FormatArray([]string{"dbfilename", r.dbfilename})
+	// PXAT is what a PX turns into in the AOF, so replays keep the deadline
+	if len(cmd.Args) > 2 && strings.ToUpper(cmd.Args[2]) == "PXAT" {
+		if len(cmd.Args) < 4 {
+			return FormatError("invalid timestamp for PXAT argument")
 		}
+		unixMilli, err := strconv.ParseInt(cmd.Args[3], 10, 64)
+		if err != nil {
+			return FormatError("invalid timestamp for PXAT argument")
+		}
+		expires = time.UnixMilli(unixMilli)
+	}
+
+	_, existed := r.lookupKey(key)
+	r.setKey(key, Data{
+		value:  value,
+		expire: expires,
+	})
+	r.signalModifiedKey(key)
+	r.dirty++
+
+	if expires.IsZero() {
+		r.propagate(&Command{Name: "SET", Args: []string{key, value}})
+	} else {
+		r.propagate(&Command{Name: "SET", Args: []string{key, value, "PXAT", strconv.FormatInt(expires.UnixMilli(), 10)}})
+	}
+	if !existed {
+		r.notifyKeyspaceEvent(notifyNew, "new", key)
+	}
+	r.notifyKeyspaceEvent(notifyString, "set", key)
+
+	return FormatSimpleString("OK")
+}
+
+// getCommand implements GET key.
+func (r *Radisa) getCommand(cmd *Command) []byte {
+	key := cmd.Args[0]
+	value, exists := r.lookupKey(key)
+
+	if !exists {
+		r.notifyKeyspaceEvent(notifyKeyMiss, "keymiss", key)
+		return FormatNullBulkString()
+	}
+
+	if value.kind != kindString {
+		return FormatWrongType()
+	}
 
-		return FormatError("unknown config parameter")
+	return FormatBulkString(value.value)
+}
 
-	case "KEYS":
-		if len(cmd.Args) < 1 {
-			return FormatError("wrong number of arguments for 'keys' command")
+// delCommand implements DEL key [key ...].
+func (r *Radisa) delCommand(cmd *Command) []byte {
+	var deleted []string
+	for _, key := range cmd.Args {
+		if _, exists := r.lookupKey(key); exists {
+			r.deleteKey(key)
+			r.signalModifiedKey(key)
+			r.notifyKeyspaceEvent(notifyGeneric, "del", key)
+			deleted = append(deleted, key)
 		}
+	}
+	if len(deleted) > 0 {
+		r.dirty += len(deleted)
+		r.propagate(&Command{Name: "DEL", Args: deleted})
+	}
 
-		pattern := cmd.Args[0]
-		r.mu.RLock()
-		keys := SearchKeys(pattern, slices.Collect(maps.Keys(r.data)))
-		r.mu.RUnlock()
+	return FormatInteger(int64(len(deleted)))
+}
 
-		return FormatArray(keys)
+// expireCommand implements EXPIRE key seconds and PEXPIREAT key
+// unix-time-ms.
+func (r *Radisa) expireCommand(cmd *Command) []byte {
+	key := cmd.Args[0]
+	n, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		return FormatError("value is not an integer or out of range")
+	}
 
-	case "INFO":
-		if r.replicaOf != nil {
-			return FormatBulkString("role:slave")
+	var deadline time.Time
+	if cmd.Name == "EXPIRE" {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return FormatError("invalid expire time in 'expire' command")
 		}
-		
-		return FormatBulkString("role:master")
+		deadline = time.Now().Add(time.Duration(n) * time.Second)
+	} else {
+		deadline = time.UnixMilli(n)
+	}
 
-	default:
-		return FormatError("unknown command")
+	value, exists := r.lookupKey(key)
+	if !exists {
+		return FormatInteger(0)
 	}
+	r.signalModifiedKey(key)
+	r.dirty++
+
+	// A deadline in the past deletes the key right away
+	if !deadline.After(time.Now()) {
+		r.deleteKey(key)
+		r.propagate(&Command{Name: "DEL", Args: []string{key}})
+		r.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		return FormatInteger(1)
+	}
+
+	value.expire = deadline
+	r.setKey(key, value)
+	r.propagate(&Command{Name: "PEXPIREAT", Args: []string{key, strconv.FormatInt(deadline.UnixMilli(), 10)}})
+	r.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+
+	return FormatInteger(1)
+}
+
+// configCommand implements CONFIG GET parameter.
+func (r *Radisa) configCommand(cmd *Command) []byte {
+	if !strings.EqualFold(cmd.Args[0], "GET") {
+		return FormatError("unknown subcommand '" + cmd.Args[0] + "'. Try CONFIG HELP.")
+	}
+
+	if cmd.Args[1] == "dir" {
+		return FormatArray([]string{"dir", r.dir})
+	}
+
+	if cmd.Args[1] == "dbfilename" {
+		return FormatArray([]string{"dbfilename", r.dbfilename})
+	}
+
+	if value, ok := r.config.get(cmd.Args[1]); ok {
+		return FormatArray([]string{cmd.Args[1], value})
+	}
+
+	return FormatError("unknown config parameter")
+}
+
+// keysCommand implements KEYS pattern.
+func (r *Radisa) keysCommand(cmd *Command) []byte {
+	pattern := cmd.Args[0]
+	keys := SearchKeys(pattern, slices.Collect(maps.Keys(r.data)))
+
+	return FormatArray(keys)
+}
+
+// saveCommand implements SAVE.
+func (r *Radisa) saveCommand(cmd *Command) []byte {
+	if err := r.save(); err != nil {
+		return FormatError(err.Error())
+	}
+	return FormatSimpleString("OK")
+}
+
+// bgsaveCommand implements BGSAVE.
+func (r *Radisa) bgsaveCommand(cmd *Command) []byte {
+	if err := r.bgsave(); err != nil {
+		return FormatError(err.Error())
+	}
+	return FormatSimpleString("Background saving started")
+}
+
+// bgrewriteaofCommand implements BGREWRITEAOF.
+func (r *Radisa) bgrewriteaofCommand(cmd *Command) []byte {
+	if err := r.bgrewriteaof(); err != nil {
+		return FormatError(err.Error())
+	}
+	return FormatSimpleString("Background append only file rewriting started")
+}
+
+// selectCommand implements SELECT index.
+func (r *Radisa) selectCommand(cmd *Command) []byte {
+	// Only database 0 exists; AOF files from redis start with SELECT 0
+	if cmd.Args[0] != "0" {
+		return FormatError("DB index is out of range")
+	}
+	return FormatSimpleString("OK")
+}
+
+// lastsaveCommand implements LASTSAVE.
+func (r *Radisa) lastsaveCommand(cmd *Command) []byte {
+	return FormatInteger(r.LastSave().Unix())
+}
+
+// infoCommand implements INFO [section ...].
+func (r *Radisa) infoCommand(cmd *Command) []byte {
+	return FormatBulkString(r.info(cmd.Args))
+}
+
+// publishCommand implements PUBLISH channel message.
+func (r *Radisa) publishCommand(cmd *Command) []byte {
+	receivers := r.publish(cmd.Args[0], cmd.Args[1])
+	// Subscribers on replicas get it too, the AOF has no use for it
+	r.feedReplicas(cmd)
+	return FormatInteger(int64(receivers))
+}
+
+// spublishCommand implements SPUBLISH shardchannel message.
+func (r *Radisa) spublishCommand(cmd *Command) []byte {
+	receivers := r.publishChannel(cmd.Args[0], cmd.Args[1], pubsubShard)
+	r.feedReplicas(cmd)
+	return FormatInteger(int64(receivers))
 }
 
@@ -0,0 +1,50 @@
+package radisa
+
+import "fmt"
+
+// streamID is the <ms>-<seq> id of a stream entry.
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string // field, value, field, value, ...
+}
+
+type streamPendingEntry struct {
+	id            streamID
+	consumer      string
+	deliveryTime  int64 // unix ms
+	deliveryCount uint64
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64 // unix ms
+	activeTime int64 // unix ms
+}
+
+type streamGroup struct {
+	name        string
+	lastID      streamID
+	entriesRead int64 // -1 if unknown
+	pending     []streamPendingEntry
+	consumers   []streamConsumer
+}
+
+// stream holds what an RDB stream carries. There are no stream commands yet,
+// it is kept so snapshots survive a load and save unchanged.
+type stream struct {
+	entries      []streamEntry
+	lastID       streamID
+	firstID      streamID
+	maxDeletedID streamID
+	entriesAdded uint64
+	groups       []streamGroup
+}
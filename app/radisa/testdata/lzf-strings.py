@@ -0,0 +1,115 @@
+"""Writes lzf-strings.rdb: string keys over 20 bytes, LZF-compressed with a
+line-by-line Python port of redis' lzf_c.c (HLOG 16, VERY_FAST, zeroed
+hash table), in the layout rdbSaveLzfStringObject uses. It is not a dump
+taken from a running redis-server."""
+import struct, sys
+
+HLOG = 16
+HSIZE = 1 << HLOG
+MAX_LIT = 1 << 5
+MAX_OFF = 1 << 13
+MAX_REF = (1 << 8) + (1 << 3)
+M32 = 0xFFFFFFFF
+
+def frst(d, p): return (d[p] << 8) | d[p + 1]
+def nxt(v, d, p): return ((v << 8) | d[p + 2]) & M32
+def idx(h): return (((h >> (3 * 8 - HLOG)) - h * 5) & M32) & (HSIZE - 1)
+
+def lzf_compress(d, out_len):
+    in_len = len(d)
+    if not in_len or not out_len:
+        return None
+    htab = [0] * HSIZE  # 0 stands for the NULL redis' zeroed table holds
+    out = bytearray(out_len + 4)
+    ip, op, lit = 0, 1, 0
+    in_end, out_end = in_len, out_len
+    hval = frst(d, ip)
+    while ip < in_end - 2:
+        hval = nxt(hval, d, ip)
+        slot = idx(hval)
+        ref = htab[slot] - 1  # positions stored +1, so -1 is NULL
+        htab[slot] = ip + 1
+        off = ip - ref - 1
+        if ref > 0 and off < MAX_OFF and d[ref + 2] == d[ip + 2] and d[ref:ref + 2] == d[ip:ip + 2]:
+            ln = 2
+            maxlen = min(in_end - ip - ln, MAX_REF)
+            if op + 3 + 1 >= out_end and op - (0 if lit else 1) + 3 + 1 >= out_end:
+                return None
+            out[op - lit - 1] = (lit - 1) & 0xFF
+            op -= 0 if lit else 1
+            while True:
+                ln += 1
+                if not (ln < maxlen and d[ref + ln] == d[ip + ln]):
+                    break
+            ln -= 2
+            ip += 1
+            if ln < 7:
+                out[op] = ((off >> 8) + (ln << 5)) & 0xFF; op += 1
+            else:
+                out[op] = ((off >> 8) + (7 << 5)) & 0xFF; op += 1
+                out[op] = (ln - 7) & 0xFF; op += 1
+            out[op] = off & 0xFF; op += 1
+            lit = 0; op += 1
+            ip += ln + 1
+            if ip >= in_end - 2:
+                break
+            ip -= 2
+            hval = frst(d, ip)
+            hval = nxt(hval, d, ip)
+            htab[idx(hval)] = ip + 1
+            ip += 1
+            hval = nxt(hval, d, ip)
+            htab[idx(hval)] = ip + 1
+            ip += 1
+        else:
+            if op >= out_end:
+                return None
+            lit += 1; out[op] = d[ip]; op += 1; ip += 1
+            if lit == MAX_LIT:
+                out[op - lit - 1] = lit - 1
+                lit = 0; op += 1
+    if op + 3 > out_end:
+        return None
+    while ip < in_end:
+        lit += 1; out[op] = d[ip]; op += 1; ip += 1
+        if lit == MAX_LIT:
+            out[op - lit - 1] = lit - 1
+            lit = 0; op += 1
+    out[op - lit - 1] = (lit - 1) & 0xFF
+    op -= 0 if lit else 1
+    return bytes(out[:op])
+
+def crc64(crc, data):
+    poly = 0x95AC9329AC4BC9B5
+    for b in data:
+        crc ^= b
+        for _ in range(8):
+            crc = (crc >> 1) ^ poly if crc & 1 else crc >> 1
+    return crc
+
+def length(n):
+    if n < 64: return bytes([n])
+    if n < 16384: return bytes([0x40 | (n >> 8), n & 0xFF])
+    return b"\x80" + struct.pack(">I", n)
+
+def string(s):
+    if len(s) > 20:
+        c = lzf_compress(s, len(s) - 4)
+        if c is not None:
+            return b"\xc3" + length(len(c)) + length(len(s)) + c
+    return length(len(s)) + s
+
+VALUES = [
+    (b"repeated", b"a" * 30),
+    (b"sentence", b"the quick brown fox jumps over the lazy dog, then the quick brown fox naps"),
+    (b"items", b",".join(b"item:%04d" % i for i in range(1, 21))),
+    (b"raw", b"0123456789abcdefghijklmnopqrstuvwxyz"),
+]
+
+assert crc64(0, b"123456789") == 0xE9C6D914C4B8D9CA
+body = b"REDIS0011" + b"\xfe\x00\xfb" + length(len(VALUES)) + length(0)
+for k, v in VALUES:
+    body += b"\x00" + string(k) + string(v)
+body += b"\xff"
+body += struct.pack("<Q", crc64(0, body))
+sys.stdout.buffer.write(body)
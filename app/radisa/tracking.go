@@ -0,0 +1,337 @@
+package radisa
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// trackingChannel is where RESP2 clients subscribe to receive the
+// invalidations redirected to them.
+const trackingChannel = "__redis__:invalidate"
+
+// trackingOptions are what CLIENT TRACKING ON was given.
+type trackingOptions struct {
+	bcast  bool
+	optin  bool
+	optout bool
+	noloop bool
+	// redirect is the ID of the client invalidations are sent to, 0 to
+	// send them to the tracking client itself.
+	redirect int64
+	// prefixes are the BCAST prefixes, the empty one standing for all keys.
+	prefixes []string
+}
+
+// clientTracking implements CLIENT TRACKING ON|OFF [REDIRECT id]
+// [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP].
+func (r *Radisa) clientTracking(c *client, args []string) []byte {
+	if len(args) == 0 {
+		return FormatError("wrong number of arguments for 'client|tracking' command")
+	}
+
+	opts := &trackingOptions{}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REDIRECT", "PREFIX":
+			if i+1 == len(args) {
+				return FormatError("syntax error")
+			}
+			i++
+			if strings.EqualFold(args[i-1], "PREFIX") {
+				opts.prefixes = append(opts.prefixes, args[i])
+				continue
+			}
+			id, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return FormatError("value is not an integer or out of range")
+			}
+			opts.redirect = id
+		case "BCAST":
+			opts.bcast = true
+		case "OPTIN":
+			opts.optin = true
+		case "OPTOUT":
+			opts.optout = true
+		case "NOLOOP":
+			opts.noloop = true
+		default:
+			return FormatError("syntax error")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "ON":
+		if reply := r.checkTrackingOptions(c, opts); reply != nil {
+			return reply
+		}
+		r.enableTracking(c, opts)
+	case "OFF":
+		r.disableTracking(c)
+	default:
+		return FormatError("syntax error")
+	}
+	return FormatSimpleString("OK")
+}
+
+// checkTrackingOptions refuses options that contradict each other or the
+// mode tracking is already on in. The caller holds mu.
+func (r *Radisa) checkTrackingOptions(c *client, opts *trackingOptions) []byte {
+	if opts.redirect != 0 && r.clients[opts.redirect] == nil {
+		return FormatError("The client ID you want redirect to does not exist")
+	}
+	if old := c.tracking; old != nil {
+		if old.bcast != opts.bcast {
+			return FormatError("You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+		if opts.optin && old.optout || opts.optout && old.optin {
+			return FormatError("You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+	}
+	if opts.optin && opts.optout {
+		return FormatError("You can't use both OPTIN and OPTOUT")
+	}
+	if opts.bcast && (opts.optin || opts.optout) {
+		return FormatError("OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if !opts.bcast && len(opts.prefixes) > 0 {
+		return FormatError("PREFIX option requires BCAST mode to be enabled")
+	}
+
+	// A key matching two prefixes of one client would be invalidated twice
+	var existing []string
+	if c.tracking != nil {
+		existing = c.tracking.prefixes
+	}
+	for i, prefix := range opts.prefixes {
+		for _, other := range append(existing, opts.prefixes[:i]...) {
+			if prefix != other && (strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix)) {
+				return FormatError(fmt.Sprintf("Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", prefix, other))
+			}
+		}
+	}
+	return nil
+}
+
+// enableTracking turns tracking on for c, or changes its options. BCAST
+// prefixes add up with the ones c already had. The caller holds mu.
+func (r *Radisa) enableTracking(c *client, opts *trackingOptions) {
+	var prefixes []string
+	if c.tracking != nil {
+		prefixes = c.tracking.prefixes
+		r.disableTracking(c)
+	}
+	for _, prefix := range opts.prefixes {
+		if !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if opts.bcast && len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	opts.prefixes = prefixes
+	c.tracking = opts
+
+	if r.trackingPrefixes == nil {
+		r.trackingPrefixes = make(map[string]map[*client]struct{})
+	}
+	for _, prefix := range opts.prefixes {
+		if r.trackingPrefixes[prefix] == nil {
+			r.trackingPrefixes[prefix] = make(map[*client]struct{})
+		}
+		r.trackingPrefixes[prefix][c] = struct{}{}
+	}
+}
+
+// disableTracking turns tracking off for c. Keys it read stay in the
+// tracking table until they are invalidated, it is just skipped then. The
+// caller holds mu.
+func (r *Radisa) disableTracking(c *client) {
+	if c.tracking == nil {
+		return
+	}
+	for _, prefix := range c.tracking.prefixes {
+		delete(r.trackingPrefixes[prefix], c)
+		if len(r.trackingPrefixes[prefix]) == 0 {
+			delete(r.trackingPrefixes, prefix)
+		}
+	}
+	c.tracking = nil
+	c.trackingCaching = false
+}
+
+// clientCaching implements CLIENT CACHING YES|NO, which makes the next
+// command tracked in OPTIN mode or untracked in OPTOUT mode.
+func (r *Radisa) clientCaching(c *client, args []string) []byte {
+	if len(args) != 1 {
+		return FormatError("wrong number of arguments for 'client|caching' command")
+	}
+
+	r.mu.RLock()
+	opts := c.tracking
+	r.mu.RUnlock()
+
+	if opts == nil || !opts.optin && !opts.optout {
+		return FormatError("CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+	switch strings.ToLower(args[0]) {
+	case "yes":
+		if !opts.optin {
+			return FormatError("CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+	case "no":
+		if !opts.optout {
+			return FormatError("CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+	default:
+		return FormatError("syntax error")
+	}
+	c.trackingCaching = true
+	return FormatSimpleString("OK")
+}
+
+// isClientCaching reports whether cmd is CLIENT CACHING, which doesn't
+// use up what the previous one said.
+func isClientCaching(cmd *Command) bool {
+	return cmd.Name == "CLIENT" && len(cmd.Args) > 0 && strings.EqualFold(cmd.Args[0], "CACHING")
+}
+
+// rememberTrackedKeys records that c read the keys of cmd, if it is a
+// read-only command, so it is told when they change. BCAST clients are
+// told by prefix instead. The caller holds mu.
+func (r *Radisa) rememberTrackedKeys(c *client, cmd *Command) {
+	if c == nil || c.tracking == nil || c.tracking.bcast {
+		return
+	}
+	if c.tracking.optin && !c.trackingCaching || c.tracking.optout && c.trackingCaching {
+		return
+	}
+	spec := lookupCommand(cmd)
+	if spec == nil || spec.flags&cmdReadonly == 0 {
+		return
+	}
+
+	for _, key := range spec.keys.keys(cmd) {
+		if r.trackingTable == nil {
+			r.trackingTable = make(map[string]map[int64]struct{})
+		}
+		if r.trackingTable[key] == nil {
+			r.trackingTable[key] = make(map[int64]struct{})
+		}
+		r.trackingTable[key][c.id] = struct{}{}
+	}
+	r.limitTrackingTable()
+}
+
+// limitTrackingTable keeps the tracking table within
+// tracking-table-max-keys by invalidating random keys, so their clients
+// drop them rather than miss a change later. The caller holds mu.
+func (r *Radisa) limitTrackingTable() {
+	limit := r.config.TrackingTableMaxKeys
+	if limit == 0 {
+		return
+	}
+	for key := range r.trackingTable {
+		if len(r.trackingTable) <= limit {
+			return
+		}
+		r.invalidateTrackedKey(key, false)
+	}
+}
+
+// trackingInvalidateKey tells the clients caching key that it changed:
+// those that read it and those whose BCAST prefix matches it. The caller
+// holds mu.
+func (r *Radisa) trackingInvalidateKey(key string) {
+	for prefix, clients := range r.trackingPrefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for c := range clients {
+			if c.tracking.noloop && c == r.currentClient {
+				continue
+			}
+			r.sendInvalidation(c, FormatArray([]string{key}))
+		}
+	}
+	r.invalidateTrackedKey(key, true)
+}
+
+// invalidateTrackedKey sends an invalidation for key to the clients that
+// read it and forgets them. noloop is false when the key is only evicted
+// from the table, then even the client evicting it is told. The caller
+// holds mu.
+func (r *Radisa) invalidateTrackedKey(key string, noloop bool) {
+	ids, ok := r.trackingTable[key]
+	if !ok {
+		return
+	}
+	delete(r.trackingTable, key)
+
+	for id := range ids {
+		c := r.clients[id]
+		if c == nil || c.tracking == nil || c.tracking.bcast {
+			continue
+		}
+		if noloop && c.tracking.noloop && c == r.currentClient {
+			continue
+		}
+		r.sendInvalidation(c, FormatArray([]string{key}))
+	}
+}
+
+// trackingInvalidateAll tells every tracking client to drop its whole
+// cache, for when the keyspace is replaced. The caller holds mu.
+func (r *Radisa) trackingInvalidateAll() {
+	for _, c := range r.clients {
+		if c.tracking != nil {
+			r.sendInvalidation(c, nil)
+		}
+	}
+	r.trackingTable = nil
+}
+
+// sendInvalidation delivers keys to c, or to the client it redirects to: as
+// a push on RESP3, as a message on __redis__:invalidate on RESP2 if that
+// client subscribed to it. nil keys invalidate everything. The caller holds
+// mu.
+func (r *Radisa) sendInvalidation(c *client, keys []byte) {
+	target := c
+	if c.tracking.redirect != 0 {
+		if target = r.clients[c.tracking.redirect]; target == nil {
+			// Let c know its invalidations go nowhere
+			if c.resp == 3 {
+				r.queuePush(c, FormatPush([][]byte{FormatBulk("tracking-redir-broken"), FormatInteger(c.tracking.redirect)}))
+			}
+			return
+		}
+	}
+
+	if target.resp == 3 {
+		if keys == nil {
+			keys = FormatNull()
+		}
+		r.queuePush(target, FormatPush([][]byte{FormatBulk("invalidate"), keys}))
+		return
+	}
+	if _, ok := target.channels[trackingChannel]; ok {
+		if keys == nil {
+			keys = FormatNullArray()
+		}
+		r.queuePush(target, FormatReplies([][]byte{FormatBulk("message"), FormatBulk(trackingChannel), keys}))
+	}
+}
+
+// queuePush sends msg to c. An invalidation caused by c's own command is
+// held back until c got its reply. The caller holds mu.
+func (r *Radisa) queuePush(c *client, msg []byte) {
+	if c == r.currentClient {
+		c.pendingPushes = append(c.pendingPushes, msg)
+		return
+	}
+	c.push(msg)
+}
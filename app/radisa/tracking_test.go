@@ -0,0 +1,176 @@
+package radisa
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// commandForTest sends a command and returns its whole reply.
+func commandForTest(t *testing.T, conn net.Conn, reader *bufio.Reader, args ...string) string {
+	t.Helper()
+	conn.Write(FormatCommand(&Command{Name: args[0], Args: args[1:]}))
+	return readReply(t, reader)
+}
+
+func invalidatePush(keys ...string) string {
+	return string(FormatPush([][]byte{FormatBulk("invalidate"), FormatArray(keys)}))
+}
+
+func TestTracking_DefaultMode(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+
+	if got := commandForTest(t, conn, reader, "HELLO", "3"); !strings.HasPrefix(got, "%7\r\n") {
+		t.Errorf("Expected a RESP3 map, got %q", got)
+	}
+	commandForTest(t, conn, reader, "CLIENT", "TRACKING", "ON")
+	commandForTest(t, conn, reader, "GET", "k")
+
+	r.executeCommand(&Command{Name: "SET", Args: []string{"k", "1"}})
+	if got, expected := readReply(t, reader), invalidatePush("k"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	// The key was forgotten until it is read again
+	r.executeCommand(&Command{Name: "SET", Args: []string{"k", "2"}})
+	if got := commandForTest(t, conn, reader, "PING"); got != "+PONG\r\n" {
+		t.Errorf("Expected no invalidation, got %q", got)
+	}
+
+	// A change made by the client itself arrives after the reply
+	commandForTest(t, conn, reader, "GET", "k")
+	if got := commandForTest(t, conn, reader, "SET", "k", "3"); got != "+OK\r\n" {
+		t.Errorf("Expected OK first, got %q", got)
+	}
+	if got, expected := readReply(t, reader), invalidatePush("k"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestTracking_RedirectToRESP2Subscriber(t *testing.T) {
+	r := createTestServer()
+	port := serveForTest(t, r)
+	sub, subReader := dialForTest(t, port)
+	conn, reader := dialForTest(t, port)
+
+	id := strings.TrimSpace(commandForTest(t, sub, subReader, "CLIENT", "ID")[1:])
+	commandForTest(t, sub, subReader, "SUBSCRIBE", trackingChannel)
+
+	if got := commandForTest(t, conn, reader, "CLIENT", "TRACKING", "ON", "REDIRECT", "999"); got != "-ERR The client ID you want redirect to does not exist\r\n" {
+		t.Errorf("Expected missing client error, got %q", got)
+	}
+	commandForTest(t, conn, reader, "CLIENT", "TRACKING", "ON", "REDIRECT", id)
+	if got := commandForTest(t, conn, reader, "CLIENT", "GETREDIR"); got != ":"+id+"\r\n" {
+		t.Errorf("Expected redirect to %s, got %q", id, got)
+	}
+	commandForTest(t, conn, reader, "GET", "k")
+
+	r.executeCommand(&Command{Name: "DEL", Args: []string{"k"}})
+	r.executeCommand(&Command{Name: "SET", Args: []string{"k", "1"}})
+	expected := "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$1\r\nk\r\n"
+	if got := readReply(t, subReader); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestTracking_BroadcastWithPrefixes(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+	commandForTest(t, conn, reader, "HELLO", "3")
+
+	if got := commandForTest(t, conn, reader, "CLIENT", "TRACKING", "ON", "PREFIX", "user:"); !strings.Contains(got, "requires BCAST") {
+		t.Errorf("Expected PREFIX to require BCAST, got %q", got)
+	}
+	if got := commandForTest(t, conn, reader, "CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "PREFIX", "user:1"); !strings.Contains(got, "overlaps") {
+		t.Errorf("Expected overlapping prefixes to be refused, got %q", got)
+	}
+	commandForTest(t, conn, reader, "CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "PREFIX", "order:", "NOLOOP")
+
+	// Keys are invalidated without being read, but not when the client
+	// changed them itself
+	commandForTest(t, conn, reader, "SET", "user:1", "a")
+	r.executeCommand(&Command{Name: "SET", Args: []string{"session:1", "b"}})
+	r.executeCommand(&Command{Name: "SET", Args: []string{"order:7", "c"}})
+	if got, expected := readReply(t, reader), invalidatePush("order:7"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	commandForTest(t, conn, reader, "CLIENT", "TRACKING", "OFF")
+	r.executeCommand(&Command{Name: "SET", Args: []string{"user:2", "d"}})
+	if got := commandForTest(t, conn, reader, "PING"); got != "+PONG\r\n" {
+		t.Errorf("Expected no invalidation once off, got %q", got)
+	}
+}
+
+func TestTracking_OptIn(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+	commandForTest(t, conn, reader, "HELLO", "3")
+
+	if got := commandForTest(t, conn, reader, "CLIENT", "CACHING", "yes"); !strings.Contains(got, "OPTIN or OPTOUT") {
+		t.Errorf("Expected CACHING to need OPTIN or OPTOUT, got %q", got)
+	}
+	commandForTest(t, conn, reader, "CLIENT", "TRACKING", "ON", "OPTIN")
+	if got := commandForTest(t, conn, reader, "CLIENT", "CACHING", "no"); !strings.Contains(got, "OPTOUT mode") {
+		t.Errorf("Expected CACHING no to need OPTOUT, got %q", got)
+	}
+
+	commandForTest(t, conn, reader, "GET", "a")
+	commandForTest(t, conn, reader, "CLIENT", "CACHING", "yes")
+	commandForTest(t, conn, reader, "GET", "b")
+	// CACHING yes only applied to the command after it
+	commandForTest(t, conn, reader, "GET", "c")
+
+	for _, key := range []string{"a", "b", "c"} {
+		r.executeCommand(&Command{Name: "SET", Args: []string{key, "1"}})
+	}
+	if got, expected := readReply(t, reader), invalidatePush("b"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if got := commandForTest(t, conn, reader, "PING"); got != "+PONG\r\n" {
+		t.Errorf("Expected only b to be invalidated, got %q", got)
+	}
+}
+
+func TestTracking_TableMaxKeys(t *testing.T) {
+	r := createTestServer()
+	r.config.TrackingTableMaxKeys = 2
+	conn, reader := dialForTest(t, serveForTest(t, r))
+	commandForTest(t, conn, reader, "HELLO", "3")
+	commandForTest(t, conn, reader, "CLIENT", "TRACKING", "ON")
+
+	commandForTest(t, conn, reader, "GET", "a")
+	commandForTest(t, conn, reader, "GET", "b")
+	if got := commandForTest(t, conn, reader, "GET", "c"); got != "$-1\r\n" {
+		t.Errorf("Expected the reply first, got %q", got)
+	}
+
+	// One of the keys was evicted and its reader told to drop it
+	got := readReply(t, reader)
+	if !strings.HasPrefix(got, ">2\r\n$10\r\ninvalidate\r\n*1\r\n") {
+		t.Errorf("Expected an invalidation, got %q", got)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.trackingTable) != 2 {
+		t.Errorf("Expected 2 tracked keys, got %d", len(r.trackingTable))
+	}
+}
+
+func TestTracking_RESP3RepliesAreNotLimited(t *testing.T) {
+	r := createTestServer()
+	conn, reader := dialForTest(t, serveForTest(t, r))
+	commandForTest(t, conn, reader, "HELLO", "3")
+
+	// Only pushes count against the limit, a reply is sent whatever its size
+	value := strings.Repeat("x", pubsubOutputLimit+1)
+	r.executeCommand(&Command{Name: "SET", Args: []string{"big", value}})
+	if got := commandForTest(t, conn, reader, "GET", "big"); got != string(FormatBulk(value)) {
+		t.Errorf("Expected the value back, got %d other bytes", len(got))
+	}
+	if got := commandForTest(t, conn, reader, "PING"); got != "+PONG\r\n" {
+		t.Errorf("Expected +PONG, got %q", got)
+	}
+}